@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/c3d4r/agent-cli-tools/internal/atomicfile"
+)
+
+// scriptOp is one operation of an apply script. Which fields are used
+// depends on Op, mirroring the arguments of the matching command.
+type scriptOp struct {
+	Op     string    `json:"op"`
+	File   string    `json:"file"`
+	Line   lineField `json:"line"`  // set, setrange, delete, insert, append
+	Text   string    `json:"text"`  // set, setrange, insert, append, after, before
+	Old    string    `json:"old"`   // replace
+	New    string    `json:"new"`   // replace
+	Match  string    `json:"match"` // after, before
+	All    bool      `json:"all"`
+	Regex  bool      `json:"regex"`
+	Expect *string   `json:"expect"`
+
+	where string // position in the script, for errors
+}
+
+// lineField accepts a line address as a JSON number (42) or string
+// ("42", "42#a3f", "10-15").
+type lineField string
+
+func (l *lineField) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = lineField(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("line must be a number or string, got %s", data)
+	}
+	*l = lineField(n)
+	return nil
+}
+
+// opAliases maps command abbreviations to operation names.
+var opAliases = map[string]string{
+	"del": "delete",
+	"ins": "insert",
+	"app": "append",
+	"rep": "replace",
+}
+
+// opArgs is the number of arguments each operation takes in a script line.
+var opArgs = map[string]int{
+	"set":      3,
+	"setrange": 3,
+	"delete":   2,
+	"insert":   3,
+	"append":   3,
+	"replace":  3,
+	"after":    3,
+	"before":   3,
+}
+
+// byteEdit replaces content[start:end] with text. Every edit of a script
+// is resolved against the original content of its file.
+type byteEdit struct {
+	start, end int
+	text       string
+	op         *scriptOp
+}
+
+// scriptFile is a file touched by a script, as read before any edit.
+type scriptFile struct {
+	path    string
+	info    os.FileInfo // identifies the file, whatever path reached it
+	lines   []string
+	format  fileFormat
+	content string // lines, each terminated by "\n"
+	starts  []int  // byte offset of each line, plus len(content)
+	edits   []byteEdit
+}
+
+func cmdApply(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: e apply [script|-]")
+	}
+
+	var data []byte
+	var err error
+	if len(args) == 0 || args[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return fmt.Errorf("read script: %w", err)
+	}
+
+	ops, err := parseScript(data)
+	if err != nil {
+		return err
+	}
+	if len(ops) == 0 {
+		return fmt.Errorf("script has no operations")
+	}
+
+	// Resolve every operation against the files as they are now. Paths
+	// that reach the same file, through symlinks or hard links, share one
+	// buffer, so no edit is lost to another path's write.
+	var files []*scriptFile
+	for i := range ops {
+		op := &ops[i]
+		if op.File == "" {
+			return fmt.Errorf("%s: file is required", op.where)
+		}
+		info, err := os.Stat(op.File)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op.where, err)
+		}
+		var f *scriptFile
+		for _, sf := range files {
+			if os.SameFile(sf.info, info) {
+				f = sf
+				break
+			}
+		}
+		if f == nil {
+			f, err = loadScriptFile(op.File)
+			if err != nil {
+				return fmt.Errorf("%s: %w", op.where, err)
+			}
+			f.info = info
+			files = append(files, f)
+		}
+
+		edits, err := f.resolve(op)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", op.where, op.Op, err)
+		}
+		f.edits = append(f.edits, edits...)
+	}
+
+	// Apply them, in memory, per file.
+	var results []fileResult
+	for _, f := range files {
+		content, err := f.apply()
+		if err != nil {
+			return err
+		}
+		if content == f.content {
+			continue
+		}
+		results = append(results, fileResult{
+			path:     f.path,
+			format:   f.format,
+			original: f.lines,
+			modified: toLines(content),
+		})
+	}
+	return writeResults(results)
+}
+
+// fileResult is the outcome of a multi-file command for one file.
+type fileResult struct {
+	path     string
+	format   fileFormat
+	original []string
+	modified []string
+}
+
+// writeResults is writeResult for several files: it prints a combined
+// diff or preview, or writes every file or none.
+func writeResults(results []fileResult) error {
+	if len(results) == 0 {
+		fmt.Fprintln(os.Stderr, "no changes")
+		return nil
+	}
+
+	if flagDiff {
+		for _, r := range results {
+			printDiff(r.path, r.format, r.original, r.modified)
+		}
+		return nil
+	}
+	if flagDryRun {
+		for _, r := range results {
+			fmt.Fprintf(os.Stdout, "==> %s <==\n", r.path)
+			for i, line := range r.modified {
+				fmt.Fprintf(os.Stdout, "%4d\t%s\n", i+1, line)
+			}
+		}
+		return nil
+	}
+
+	writes := make([]atomicfile.File, len(results))
+	for i, r := range results {
+		writes[i] = atomicfile.File{Path: r.path, Data: r.format.encode(r.modified)}
+	}
+	return atomicfile.WriteFiles(writes, 0644)
+}
+
+// parseScript parses a JSON array of operations or, if the script does not
+// start with "[", the line-oriented form:
+//
+//	# comment
+//	set main.go 42#a3f "    return nil"
+//	delete main.go 10-12
+//	replace --all main.go 'oldName' 'newName'
+//	after main.go "import (" "\t\"fmt\""
+//
+// Each line is a command as it would be given to e, with its own --all,
+// --regex and --expect flags. Words are separated by spaces; "..." strings
+// take Go escapes and '...' strings are literal.
+func parseScript(data []byte) ([]scriptOp, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var ops []scriptOp
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&ops); err != nil {
+			return nil, fmt.Errorf("parse script: %w", err)
+		}
+		for i := range ops {
+			ops[i].where = fmt.Sprintf("op %d", i+1)
+			if alias, ok := opAliases[ops[i].Op]; ok {
+				ops[i].Op = alias
+			}
+		}
+		return ops, nil
+	}
+
+	var ops []scriptOp
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		op, err := parseScriptLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("script line %d: %w", i+1, err)
+		}
+		op.where = fmt.Sprintf("line %d", i+1)
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+func parseScriptLine(line string) (scriptOp, error) {
+	words, err := splitWords(line)
+	if err != nil {
+		return scriptOp{}, err
+	}
+
+	var op scriptOp
+	var args []string
+	for i := 0; i < len(words); i++ {
+		switch w := words[i]; {
+		case w == "--all":
+			op.All = true
+		case w == "--regex":
+			op.Regex = true
+		case w == "--expect" && i+1 < len(words):
+			i++
+			op.Expect = &words[i]
+		case strings.HasPrefix(w, "--"):
+			return scriptOp{}, fmt.Errorf("unknown flag %s", w)
+		default:
+			args = append(args, w)
+		}
+	}
+	if len(args) == 0 {
+		return scriptOp{}, fmt.Errorf("missing command")
+	}
+
+	op.Op = args[0]
+	if alias, ok := opAliases[op.Op]; ok {
+		op.Op = alias
+	}
+	want, ok := opArgs[op.Op]
+	if !ok {
+		return scriptOp{}, fmt.Errorf("unknown command %q", op.Op)
+	}
+	if len(args)-1 != want {
+		return scriptOp{}, fmt.Errorf("%s takes %d arguments, got %d", op.Op, want, len(args)-1)
+	}
+
+	op.File = args[1]
+	switch op.Op {
+	case "set", "setrange", "insert", "append":
+		op.Line = lineField(args[2])
+		op.Text = args[3]
+	case "delete":
+		op.Line = lineField(args[2])
+	case "replace":
+		op.Old, op.New = args[2], args[3]
+	case "after", "before":
+		op.Match, op.Text = args[2], args[3]
+	}
+	return op, nil
+}
+
+// splitWords splits a script line into words, unquoting "..." (with Go
+// escapes) and '...' (literal) strings.
+func splitWords(s string) ([]string, error) {
+	var words []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; c {
+		case ' ', '\t', '\r':
+			i++
+		case '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string %s", s[i:])
+			}
+			w, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s: %w", s[i:j+1], err)
+			}
+			words = append(words, w)
+			i = j + 1
+		case '\'':
+			j := strings.IndexByte(s[i+1:], '\'')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated string %s", s[i:])
+			}
+			words = append(words, s[i+1:i+1+j])
+			i += j + 2
+		default:
+			j := i
+			for j < len(s) && s[j] != ' ' && s[j] != '\t' && s[j] != '\r' {
+				j++
+			}
+			words = append(words, s[i:j])
+			i = j
+		}
+	}
+	return words, nil
+}
+
+func loadScriptFile(path string) (*scriptFile, error) {
+	lines, format, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &scriptFile{path: path, lines: lines, format: format}
+
+	var sb strings.Builder
+	f.starts = make([]int, 0, len(lines)+1)
+	for _, line := range lines {
+		f.starts = append(f.starts, sb.Len())
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+	f.content = sb.String()
+	f.starts = append(f.starts, len(f.content))
+	return f, nil
+}
+
+// resolve turns op into edits of the file's original content.
+func (f *scriptFile) resolve(op *scriptOp) ([]byteEdit, error) {
+	edit := func(start, end int, text string) []byteEdit {
+		return []byteEdit{{start: start, end: end, text: text, op: op}}
+	}
+
+	switch op.Op {
+	case "set", "insert", "append":
+		addr, err := parseLineAddr(string(op.Line))
+		if err != nil {
+			return nil, err
+		}
+		limit := len(f.lines)
+		if op.Op == "insert" {
+			limit++ // insert may add a line at the end
+		}
+		if addr.line < 1 || addr.line > limit {
+			return nil, fmt.Errorf("line %d out of range (file has %d lines)", addr.line, len(f.lines))
+		}
+		if err := checkExpected(f.lines, addr, addr, op.Expect); err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "set":
+			return edit(f.starts[addr.line-1], f.starts[addr.line], op.Text+"\n"), nil
+		case "insert":
+			return edit(f.starts[addr.line-1], f.starts[addr.line-1], op.Text+"\n"), nil
+		default:
+			return edit(f.starts[addr.line], f.starts[addr.line], op.Text+"\n"), nil
+		}
+
+	case "setrange", "delete":
+		from, to, err := parseRangeAddr(string(op.Line))
+		if err != nil {
+			return nil, err
+		}
+		if err := validateLine(to.line, len(f.lines)); err != nil {
+			return nil, err
+		}
+		if err := checkExpected(f.lines, from, to, op.Expect); err != nil {
+			return nil, err
+		}
+		text := ""
+		if op.Op == "setrange" {
+			text = op.Text + "\n"
+		}
+		return edit(f.starts[from.line-1], f.starts[to.line], text), nil
+
+	case "replace":
+		return f.resolveReplace(op)
+
+	case "after", "before":
+		m, err := newMatcher(op.Match, op.Regex || flagRegex)
+		if err != nil {
+			return nil, err
+		}
+		spans := findMatches(f.lines, m, op.All || flagAll)
+		if len(spans) == 0 {
+			return nil, fmt.Errorf("pattern %q not found", op.Match)
+		}
+		var edits []byteEdit
+		seen := make(map[int]bool)
+		for _, sp := range spans {
+			pos := f.starts[sp.first]
+			if op.Op == "after" {
+				pos = f.starts[sp.last+1]
+			}
+			if !seen[pos] {
+				seen[pos] = true
+				edits = append(edits, edit(pos, pos, op.Text+"\n")...)
+			}
+		}
+		return edits, nil
+
+	case "":
+		return nil, fmt.Errorf("op is required")
+	default:
+		return nil, fmt.Errorf("unknown operation")
+	}
+}
+
+// resolveReplace finds op.Old (first or all matches) in the original
+// content and returns an edit per match.
+func (f *scriptFile) resolveReplace(op *scriptOp) ([]byteEdit, error) {
+	n := 1
+	if op.All || flagAll {
+		n = -1
+	}
+
+	var edits []byteEdit
+	if op.Regex || flagRegex {
+		re, err := compileRegex(op.Old)
+		if err != nil {
+			return nil, err
+		}
+		for _, loc := range re.FindAllStringSubmatchIndex(f.content, n) {
+			text := string(re.ExpandString(nil, op.New, f.content, loc))
+			edits = append(edits, byteEdit{start: loc[0], end: loc[1], text: text, op: op})
+		}
+		if len(edits) == 0 {
+			return nil, fmt.Errorf("pattern %q not found", op.Old)
+		}
+		return edits, nil
+	}
+
+	if op.Old == "" {
+		return nil, fmt.Errorf("old text is empty")
+	}
+	for pos := 0; n < 0 || len(edits) < n; {
+		idx := strings.Index(f.content[pos:], op.Old)
+		if idx < 0 {
+			break
+		}
+		start := pos + idx
+		pos = start + len(op.Old)
+		edits = append(edits, byteEdit{start: start, end: pos, text: op.New, op: op})
+	}
+	if len(edits) == 0 {
+		return nil, fmt.Errorf("text %q not found", op.Old)
+	}
+	return edits, nil
+}
+
+// apply applies the file's edits to its original content. Edits may not
+// overlap, though an insertion may sit at either end of a replaced range.
+// Insertions at the same point keep their script order.
+func (f *scriptFile) apply() (string, error) {
+	edits := f.edits
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].start != edits[j].start {
+			return edits[i].start < edits[j].start
+		}
+		// At the same point, insertions go before a replaced range.
+		return edits[i].start == edits[i].end && edits[j].start != edits[j].end
+	})
+
+	var sb strings.Builder
+	pos := 0
+	var prev *scriptOp
+	for _, e := range edits {
+		if e.start < pos {
+			return "", fmt.Errorf("%s: %s overlaps the %s at %s in %s",
+				e.op.where, e.op.Op, prev.Op, prev.where, f.path)
+		}
+		sb.WriteString(f.content[pos:e.start])
+		sb.WriteString(e.text)
+		pos = e.end
+		if e.end > e.start {
+			prev = e.op
+		}
+	}
+	sb.WriteString(f.content[pos:])
+	return sb.String(), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// TestApplySameFile checks that edits through different paths to one
+// file all land in it.
+func TestApplySameFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f.txt")
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink("f.txt", link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	hard := filepath.Join(dir, "hard.txt")
+	if err := os.Link(path, hard); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+
+	script := filepath.Join(dir, "script")
+	lines := "set " + strconv.Quote(path) + " 1 ONE\n" +
+		"set " + strconv.Quote(link) + " 2 TWO\n" +
+		"replace " + strconv.Quote(hard) + " three THREE\n"
+	if err := os.WriteFile(script, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdApply([]string{script}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ONE\nTWO\nTHREE\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+}
+
+func TestApplyOverlappingEdits(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "f.txt")
+	if err := os.WriteFile(path, []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink("f.txt", link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	script := filepath.Join(dir, "script")
+	lines := "set " + strconv.Quote(path) + " 1 ONE\n" +
+		"set " + strconv.Quote(link) + " 1 uno\n"
+	if err := os.WriteFile(script, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdApply([]string{script}); err == nil {
+		t.Error("apply with two edits of one line through different paths succeeded")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "one\ntwo\n" {
+		t.Errorf("file changed to %q", data)
+	}
+}
@@ -18,9 +18,26 @@
 //	e after     <file> <match> <text>         Insert text after matching line
 //	e before    <file> <match> <text>         Insert text before matching line
 //
+// Match patterns may span lines. after inserts after the last line of the
+// match and before inserts before its first line.
+//
 // Other:
 //
 //	e show      <file> [from-to]              Show file with line numbers
+//	e apply     [script|-]                    Apply a batch of edits
+//	e patch     [file] < diff                 Apply a unified diff
+//
+// An apply script lists operations as a JSON array or one command per line,
+// possibly across several files. All addresses refer to the content before
+// any edit, overlapping edits are rejected, and the files are written all
+// together or not at all.
+//
+// patch locates each hunk by its context, allowing it to have moved
+// (--offset) and ignoring up to --fuzz context lines at either end. If any
+// hunk fails, each failure is reported and nothing is written.
+//
+// Line addresses may carry the hash printed by "e show --hash", as in 42#a3f
+// or 10#c01-12#9e4. The edit fails if those lines have changed since.
 //
 // Flags:
 //
@@ -28,30 +45,54 @@
 //	--regex     Treat match strings as regex
 //	--dry-run   Preview changes without writing
 //	--diff      Show unified diff of changes
+//	--context N Lines of context in --diff output (default 3)
 //	--stdin     Read text argument from stdin (for multiline content)
+//	--hash      Show a short content hash for each line (show)
+//	--expect T  Fail unless the addressed line(s) currently read T
+//	--eol E     Write lf or crlf line endings (default: keep the file's)
+//	--fuzz N    Context lines patch may ignore at each hunk end (default 2)
+//	--offset N  Lines patch may move a hunk (default: any)
+//
+// Line endings, a UTF-8 byte order mark and a missing final newline are
+// preserved on write.
 package main
 
 import (
-	"bufio"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/c3d4r/agent-cli-tools/internal/atomicfile"
+	"github.com/c3d4r/agent-cli-tools/internal/diff"
 )
 
 // flags
 var (
-	flagAll    bool
-	flagRegex  bool
-	flagDryRun bool
-	flagDiff   bool
-	flagStdin  bool
+	flagAll     bool
+	flagRegex   bool
+	flagDryRun  bool
+	flagDiff    bool
+	flagStdin   bool
+	flagHash    bool
+	flagExpect  *string // nil unless --expect was given
+	flagEOL     string  // "lf" or "crlf" to override detected line endings
+	flagContext = diff.DefaultContext
+	flagFuzz    = 2  // context lines patch may ignore at each end of a hunk
+	flagOffset  = -1 // lines patch may move a hunk; -1 means any
 )
 
 func main() {
 	args := parseFlags(os.Args[1:])
+	if flagEOL != "" && flagEOL != "lf" && flagEOL != "crlf" {
+		fmt.Fprintf(os.Stderr, "error: --eol must be lf or crlf, not %q\n", flagEOL)
+		os.Exit(2)
+	}
 
 	if len(args) == 0 {
 		usage()
@@ -79,6 +120,10 @@ func main() {
 		err = cmdAfter(cmdArgs)
 	case "before":
 		err = cmdBefore(cmdArgs)
+	case "apply":
+		err = cmdApply(cmdArgs)
+	case "patch":
+		err = cmdPatch(cmdArgs)
 	case "show":
 		err = cmdShow(cmdArgs)
 	case "help":
@@ -112,15 +157,45 @@ Content-addressed commands:
   after     <file> <match> <text>         Insert text after matching line
   before    <file> <match> <text>         Insert text before matching line
 
+  Match patterns may span lines: after inserts after the last line of the
+  match, before inserts before its first. Regex ^ and $ match at line
+  boundaries.
+
 Other:
   show      <file> [from-to]             Show file with line numbers
+  apply     [script|-]                   Apply a batch of edits (default: stdin)
+  patch     [file] < diff                Apply a unified diff from stdin
+
+  An apply script is a JSON array of {"op","file","line","text","old","new",
+  "match","all","regex","expect"} objects, or one command per line as it
+  would be given to e:
+    set main.go 42#a3f "    return nil"
+    delete main.go 10-12
+    replace --all main.go 'oldName' 'newName'
+  Addresses refer to the files before any edit. Overlapping edits are
+  rejected, and either every file is written or none is.
+
+  patch reads file names from the diff unless a file is given, and finds
+  each hunk by its context even if it has moved. If any hunk fails, every
+  failure is reported and no file is written.
+
+Stale-line protection:
+  Line addresses may carry the hash shown by "e show --hash", as in 42#a3f
+  or 10#c01-12#9e4. The edit fails, printing the current lines, if the
+  addressed lines have changed since they were shown.
 
 Flags:
   --all       Replace/match all occurrences (not just first)
   --regex     Treat match strings as regex
   --dry-run   Preview changes without writing
-  --diff      Show unified diff of changes
+  --diff      Show unified diff of changes (git apply / patch -p1 ready)
+  --context N Lines of context in --diff output (default 3)
   --stdin     Read text argument from stdin (for multiline content)
+  --hash      Show a short content hash for each line (show)
+  --expect T  Fail unless the addressed line(s) currently read T
+  --eol E     Write lf or crlf line endings (default: keep the file's)
+  --fuzz N    Context lines patch may ignore at each hunk end (default 2)
+  --offset N  Lines patch may move a hunk (default: any)
 
 Examples:
   e set main.go 42 "    return nil"
@@ -128,8 +203,14 @@ Examples:
   e insert main.go 1 "// Copyright 2025"
   e replace main.go 'func Foo()' 'func Bar(ctx context.Context)'
   e after main.go 'import (' '    "context"'
+  e after main.go $'type Server struct {\n\tmu sync.Mutex' $'\tclosed bool'
   e show main.go 40-50
+  e show --hash main.go 40-50
+  e set main.go 42#a3f "    return err"
+  e delete --expect "	x := 1" main.go 9
   echo -e "line1\nline2" | e --stdin insert main.go 5
+  e --diff apply edits.txt
+  e patch --diff < fix.diff
   e --diff replace main.go 'oldFunc' 'newFunc'
 `)
 }
@@ -137,19 +218,48 @@ Examples:
 // parseFlags extracts flags from args and returns remaining positional args.
 func parseFlags(args []string) []string {
 	var positional []string
-	for _, arg := range args {
-		switch arg {
-		case "--all":
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--all":
 			flagAll = true
-		case "--regex":
+		case arg == "--regex":
 			flagRegex = true
-		case "--dry-run":
+		case arg == "--dry-run":
 			flagDryRun = true
-		case "--diff":
+		case arg == "--diff":
 			flagDiff = true
 			flagDryRun = true // --diff implies dry-run
-		case "--stdin":
+		case arg == "--stdin":
 			flagStdin = true
+		case arg == "--hash":
+			flagHash = true
+		case arg == "--expect" && i+1 < len(args):
+			i++
+			flagExpect = &args[i]
+		case arg == "--context" && i+1 < len(args):
+			i++
+			flagContext = parseCount("--context", args[i])
+		case strings.HasPrefix(arg, "--context="):
+			flagContext = parseCount("--context", strings.TrimPrefix(arg, "--context="))
+		case arg == "--fuzz" && i+1 < len(args):
+			i++
+			flagFuzz = parseCount("--fuzz", args[i])
+		case strings.HasPrefix(arg, "--fuzz="):
+			flagFuzz = parseCount("--fuzz", strings.TrimPrefix(arg, "--fuzz="))
+		case arg == "--offset" && i+1 < len(args):
+			i++
+			flagOffset = parseCount("--offset", args[i])
+		case strings.HasPrefix(arg, "--offset="):
+			flagOffset = parseCount("--offset", strings.TrimPrefix(arg, "--offset="))
+		case arg == "--eol" && i+1 < len(args):
+			i++
+			flagEOL = args[i]
+		case strings.HasPrefix(arg, "--eol="):
+			flagEOL = strings.TrimPrefix(arg, "--eol=")
+		case strings.HasPrefix(arg, "--expect="):
+			v := strings.TrimPrefix(arg, "--expect=")
+			flagExpect = &v
 		default:
 			positional = append(positional, arg)
 		}
@@ -157,32 +267,101 @@ func parseFlags(args []string) []string {
 	return positional
 }
 
+// parseCount parses the value of a numeric flag, exiting on a bad value.
+func parseCount(flag, s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		fmt.Fprintf(os.Stderr, "error: %s must be a non-negative number, not %q\n", flag, s)
+		os.Exit(2)
+	}
+	return n
+}
+
 // --- File I/O helpers ---
 
-func readLines(path string) ([]string, error) {
+const bom = "\ufeff"
+
+// fileFormat records how a file's lines are encoded, so an edit writes
+// them back the same way.
+type fileFormat struct {
+	eol          string // "\n" or "\r\n"
+	bom          bool   // starts with a UTF-8 byte order mark
+	finalNewline bool   // last line is terminated
+}
+
+// readLines reads path as lines without terminators, along with its format.
+// A file is CRLF only if every line break is CRLF; in a file with mixed
+// endings, lines keep their "\r" so they round-trip unchanged.
+func readLines(path string) ([]string, fileFormat, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, err
+		return nil, fileFormat{}, err
 	}
 	text := string(data)
-	// Preserve trailing newline behavior
-	if strings.HasSuffix(text, "\n") {
-		text = text[:len(text)-1]
+	format := fileFormat{eol: "\n"}
+
+	if strings.HasPrefix(text, bom) {
+		format.bom = true
+		text = text[len(bom):]
+	}
+	if n := strings.Count(text, "\r\n"); n > 0 && n == strings.Count(text, "\n") {
+		format.eol = "\r\n"
 	}
 	if text == "" {
-		return []string{}, nil
+		// Lines added to an empty file are terminated.
+		format.finalNewline = true
+		return []string{}, format, nil
 	}
-	return strings.Split(text, "\n"), nil
+	if strings.HasSuffix(text, format.eol) {
+		format.finalNewline = true
+		text = text[:len(text)-len(format.eol)]
+	}
+	return strings.Split(text, format.eol), format, nil
 }
 
-func writeLines(path string, lines []string) error {
-	content := strings.Join(lines, "\n") + "\n"
-	return os.WriteFile(path, []byte(content), 0644)
+// encode joins lines back into file content in the given format, or with
+// the line endings chosen by --eol.
+func (f fileFormat) encode(lines []string) []byte {
+	return f.join(lines, flagEOL)
 }
 
-func writeResult(path string, original, modified []string) error {
+// join joins lines in the file's format. A non-empty eol ("lf" or "crlf")
+// replaces the file's line endings, including any "\r" a line of a
+// mixed-ending file carries.
+func (f fileFormat) join(lines []string, eol string) []byte {
+	sep := f.eol
+	switch eol {
+	case "lf":
+		sep = "\n"
+	case "crlf":
+		sep = "\r\n"
+	}
+
+	var sb strings.Builder
+	if f.bom {
+		sb.WriteString(bom)
+	}
+	for i, line := range lines {
+		if eol != "" {
+			line = strings.TrimSuffix(line, "\r")
+		}
+		sb.WriteString(line)
+		if i < len(lines)-1 || f.finalNewline {
+			sb.WriteString(sep)
+		}
+	}
+	return []byte(sb.String())
+}
+
+// writeLines replaces path atomically, keeping its mode, ownership and,
+// if it is a symlink, the link.
+func writeLines(path string, lines []string, format fileFormat) error {
+	return atomicfile.WriteFile(path, format.encode(lines), 0644)
+}
+
+func writeResult(path string, original, modified []string, format fileFormat) error {
 	if flagDiff {
-		printDiff(path, original, modified)
+		printDiff(path, format, original, modified)
 		return nil
 	}
 	if flagDryRun {
@@ -192,7 +371,7 @@ func writeResult(path string, original, modified []string) error {
 		}
 		return nil
 	}
-	return writeLines(path, modified)
+	return writeLines(path, modified, format)
 }
 
 // readStdin reads all of stdin and returns it as the text argument.
@@ -201,7 +380,8 @@ func readStdin() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("read stdin: %w", err)
 	}
-	s := string(data)
+	// Lines are joined in the file's own style on write.
+	s := strings.ReplaceAll(string(data), "\r\n", "\n")
 	// Trim single trailing newline (shells add one)
 	s = strings.TrimSuffix(s, "\n")
 	return s, nil
@@ -220,26 +400,52 @@ func getText(args []string, index int) (string, error) {
 
 // --- Range parsing ---
 
+// lineAddr is a 1-indexed line number with an optional content hash.
+type lineAddr struct {
+	line int
+	hash string // empty if not given
+}
+
+// parseLineAddr parses "N" or "N#hash".
+func parseLineAddr(s string) (lineAddr, error) {
+	num, hash, _ := strings.Cut(s, "#")
+	line, err := strconv.Atoi(num)
+	if err != nil {
+		return lineAddr{}, fmt.Errorf("invalid line number %q: %w", num, err)
+	}
+	return lineAddr{line: line, hash: strings.ToLower(hash)}, nil
+}
+
 // parseRange parses "N" or "N-M" into 1-indexed start, end.
 func parseRange(s string) (start, end int, err error) {
+	from, to, err := parseRangeAddr(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	return from.line, to.line, nil
+}
+
+// parseRangeAddr parses "N" or "N-M", where each end may carry a hash
+// ("N#hash-M#hash"). A single address is both start and end.
+func parseRangeAddr(s string) (start, end lineAddr, err error) {
 	if idx := strings.Index(s, "-"); idx >= 0 {
-		start, err = strconv.Atoi(s[:idx])
+		start, err = parseLineAddr(s[:idx])
 		if err != nil {
-			return 0, 0, fmt.Errorf("invalid range start %q: %w", s[:idx], err)
+			return lineAddr{}, lineAddr{}, fmt.Errorf("invalid range start %q: %w", s[:idx], err)
 		}
-		end, err = strconv.Atoi(s[idx+1:])
+		end, err = parseLineAddr(s[idx+1:])
 		if err != nil {
-			return 0, 0, fmt.Errorf("invalid range end %q: %w", s[idx+1:], err)
+			return lineAddr{}, lineAddr{}, fmt.Errorf("invalid range end %q: %w", s[idx+1:], err)
 		}
 	} else {
-		start, err = strconv.Atoi(s)
+		start, err = parseLineAddr(s)
 		if err != nil {
-			return 0, 0, fmt.Errorf("invalid line number %q: %w", s, err)
+			return lineAddr{}, lineAddr{}, err
 		}
 		end = start
 	}
-	if start < 1 || end < start {
-		return 0, 0, fmt.Errorf("invalid range %d-%d (lines are 1-indexed)", start, end)
+	if start.line < 1 || end.line < start.line {
+		return lineAddr{}, lineAddr{}, fmt.Errorf("invalid range %d-%d (lines are 1-indexed)", start.line, end.line)
 	}
 	return start, end, nil
 }
@@ -251,104 +457,89 @@ func validateLine(line int, total int) error {
 	return nil
 }
 
-// --- Diff ---
+// --- Stale-line protection ---
 
-func printDiff(path string, old, new []string) {
-	fmt.Fprintf(os.Stdout, "--- %s\n", path)
-	fmt.Fprintf(os.Stdout, "+++ %s\n", path)
+// lineHash returns the short hash printed by "show --hash" for a line.
+func lineHash(line string) string {
+	h := fnv.New32a()
+	h.Write([]byte(line))
+	return fmt.Sprintf("%03x", h.Sum32()&0xfff)
+}
 
-	// Simple line-by-line diff with context
-	type change struct {
-		oldStart, oldEnd int // 0-indexed, exclusive end
-		newStart, newEnd int
+// checkExpected verifies the hashes carried by the addresses and the
+// expected text, if any, against lines from..to (1-indexed, inclusive), so an edit
+// addressed against an earlier view of the file fails instead of hitting
+// the wrong lines. The error shows the current content of the region.
+func checkExpected(lines []string, from, to lineAddr, expect *string) error {
+	var problem string
+	switch {
+	case from.hash != "" && (from.line > len(lines) || lineHash(lines[from.line-1]) != from.hash):
+		problem = hashMismatch(lines, from)
+	case to.hash != "" && to != from && (to.line > len(lines) || lineHash(lines[to.line-1]) != to.hash):
+		problem = hashMismatch(lines, to)
+	case expect != nil:
+		if to.line > len(lines) {
+			problem = fmt.Sprintf("line %d does not exist (file has %d lines)", to.line, len(lines))
+		} else if current := strings.Join(lines[from.line-1:to.line], "\n"); current != *expect {
+			problem = fmt.Sprintf("%s does not match the expected text", describeLines(from.line, to.line))
+		}
+	}
+	if problem == "" {
+		return nil
 	}
 
-	var changes []change
-	oi, ni := 0, 0
-	for oi < len(old) && ni < len(new) {
-		if old[oi] == new[ni] {
-			oi++
-			ni++
-			continue
-		}
-		// Found a difference — find the extent
-		cs := change{oldStart: oi, newStart: ni}
-		// Scan forward to find where they sync up again
-		found := false
-		for scanLen := 1; scanLen < len(old)-oi+len(new)-ni+1; scanLen++ {
-			for oo := 0; oo <= scanLen && oi+oo <= len(old); oo++ {
-				nn := scanLen - oo
-				if ni+nn > len(new) {
-					continue
-				}
-				if oi+oo < len(old) && ni+nn < len(new) && old[oi+oo] == new[ni+nn] {
-					cs.oldEnd = oi + oo
-					cs.newEnd = ni + nn
-					changes = append(changes, cs)
-					oi = oi + oo
-					ni = ni + nn
-					found = true
-					break
-				}
-			}
-			if found {
-				break
-			}
-		}
-		if !found {
-			cs.oldEnd = len(old)
-			cs.newEnd = len(new)
-			changes = append(changes, cs)
-			oi = len(old)
-			ni = len(new)
-		}
+	// Show the region with a little context and fresh hashes to retry with.
+	var sb strings.Builder
+	sb.WriteString(problem)
+	sb.WriteString("; current content:\n")
+	lo, hi := from.line-2, to.line+2
+	if lo < 1 {
+		lo = 1
 	}
-	// Handle trailing content
-	if oi < len(old) || ni < len(new) {
-		changes = append(changes, change{
-			oldStart: oi, oldEnd: len(old),
-			newStart: ni, newEnd: len(new),
-		})
-	}
-
-	// Print hunks with context
-	ctx := 3
-	for _, c := range changes {
-		// Context lines before
-		ctxStart := c.oldStart - ctx
-		if ctxStart < 0 {
-			ctxStart = 0
-		}
-		ctxEnd := c.oldEnd + ctx
-		if ctxEnd > len(old) {
-			ctxEnd = len(old)
-		}
-		newCtxEnd := c.newEnd + ctx
-		if newCtxEnd > len(new) {
-			newCtxEnd = len(new)
-		}
+	if hi > len(lines) {
+		hi = len(lines)
+	}
+	for n := lo; n <= hi; n++ {
+		sb.WriteString(formatLine(n, lines[n-1], true))
+	}
+	return fmt.Errorf("%s", strings.TrimSuffix(sb.String(), "\n"))
+}
+
+func hashMismatch(lines []string, addr lineAddr) string {
+	if addr.line > len(lines) {
+		return fmt.Sprintf("line %d does not exist (file has %d lines)", addr.line, len(lines))
+	}
+	return fmt.Sprintf("line %d has changed (expected #%s, now #%s)", addr.line, addr.hash, lineHash(lines[addr.line-1]))
+}
 
-		fmt.Fprintf(os.Stdout, "@@ -%d,%d +%d,%d @@\n",
-			ctxStart+1, ctxEnd-ctxStart,
-			c.newStart-(c.oldStart-ctxStart)+1, (newCtxEnd)-(c.newStart-(c.oldStart-ctxStart)))
+func describeLines(from, to int) string {
+	if from == to {
+		return fmt.Sprintf("line %d", from)
+	}
+	return fmt.Sprintf("lines %d-%d", from, to)
+}
 
-		// Context before
-		for i := ctxStart; i < c.oldStart; i++ {
-			fmt.Fprintf(os.Stdout, " %s\n", old[i])
-		}
-		// Removed lines
-		for i := c.oldStart; i < c.oldEnd; i++ {
-			fmt.Fprintf(os.Stdout, "-%s\n", old[i])
-		}
-		// Added lines
-		for i := c.newStart; i < c.newEnd; i++ {
-			fmt.Fprintf(os.Stdout, "+%s\n", new[i])
-		}
-		// Context after
-		for i := c.oldEnd; i < ctxEnd; i++ {
-			fmt.Fprintf(os.Stdout, " %s\n", old[i])
-		}
+// formatLine renders a numbered line as show does, with its hash if asked.
+func formatLine(n int, line string, withHash bool) string {
+	if withHash {
+		return fmt.Sprintf("%4d#%s\t%s\n", n, lineHash(line), line)
 	}
+	return fmt.Sprintf("%4d\t%s\n", n, line)
+}
+
+// --- Diff ---
+
+// printDiff prints a unified diff of the file's content before and after
+// the edit, in the form git apply and patch accept.
+func printDiff(path string, format fileFormat, old, new []string) {
+	oldLabel, newLabel := path, path
+	if !filepath.IsAbs(path) {
+		p := filepath.ToSlash(filepath.Clean(path))
+		oldLabel, newLabel = "a/"+p, "b/"+p
+	}
+	oldText := string(format.join(old, ""))
+	newText := string(format.encode(new))
+	fmt.Fprint(os.Stdout, diff.Unified(oldLabel, newLabel, oldText, newText, flagContext))
 }
 
 // --- Line-addressed commands ---
@@ -358,26 +549,30 @@ func cmdSet(args []string) error {
 		return fmt.Errorf("usage: e set <file> <line> <text>")
 	}
 	path := args[0]
-	lineNum, err := strconv.Atoi(args[1])
+	addr, err := parseLineAddr(args[1])
 	if err != nil {
-		return fmt.Errorf("invalid line number: %w", err)
+		return err
 	}
+	lineNum := addr.line
 	text, err := getText(args, 2)
 	if err != nil {
 		return err
 	}
 
-	lines, err := readLines(path)
+	lines, format, err := readLines(path)
 	if err != nil {
 		return err
 	}
 	if err := validateLine(lineNum, len(lines)); err != nil {
 		return err
 	}
+	if err := checkExpected(lines, addr, addr, flagExpect); err != nil {
+		return err
+	}
 
 	original := copyLines(lines)
 	lines[lineNum-1] = text
-	return writeResult(path, original, lines)
+	return writeResult(path, original, lines, format)
 }
 
 func cmdSetRange(args []string) error {
@@ -385,16 +580,17 @@ func cmdSetRange(args []string) error {
 		return fmt.Errorf("usage: e setrange <file> <from>-<to> <text>")
 	}
 	path := args[0]
-	start, end, err := parseRange(args[1])
+	from, to, err := parseRangeAddr(args[1])
 	if err != nil {
 		return err
 	}
+	start, end := from.line, to.line
 	text, err := getText(args, 2)
 	if err != nil {
 		return err
 	}
 
-	lines, err := readLines(path)
+	lines, format, err := readLines(path)
 	if err != nil {
 		return err
 	}
@@ -404,15 +600,18 @@ func cmdSetRange(args []string) error {
 	if err := validateLine(end, len(lines)); err != nil {
 		return err
 	}
+	if err := checkExpected(lines, from, to, flagExpect); err != nil {
+		return err
+	}
 
 	original := copyLines(lines)
 	newTextLines := strings.Split(text, "\n")
-	modified := make([]string, 0, len(lines)-( end-start+1)+len(newTextLines))
+	modified := make([]string, 0, len(lines)-(end-start+1)+len(newTextLines))
 	modified = append(modified, lines[:start-1]...)
 	modified = append(modified, newTextLines...)
 	modified = append(modified, lines[end:]...)
 
-	return writeResult(path, original, modified)
+	return writeResult(path, original, modified, format)
 }
 
 func cmdDelete(args []string) error {
@@ -420,12 +619,13 @@ func cmdDelete(args []string) error {
 		return fmt.Errorf("usage: e delete <file> <line|from-to>")
 	}
 	path := args[0]
-	start, end, err := parseRange(args[1])
+	from, to, err := parseRangeAddr(args[1])
 	if err != nil {
 		return err
 	}
+	start, end := from.line, to.line
 
-	lines, err := readLines(path)
+	lines, format, err := readLines(path)
 	if err != nil {
 		return err
 	}
@@ -435,13 +635,16 @@ func cmdDelete(args []string) error {
 	if err := validateLine(end, len(lines)); err != nil {
 		return err
 	}
+	if err := checkExpected(lines, from, to, flagExpect); err != nil {
+		return err
+	}
 
 	original := copyLines(lines)
 	modified := make([]string, 0, len(lines)-(end-start+1))
 	modified = append(modified, lines[:start-1]...)
 	modified = append(modified, lines[end:]...)
 
-	return writeResult(path, original, modified)
+	return writeResult(path, original, modified, format)
 }
 
 func cmdInsert(args []string) error {
@@ -449,22 +652,26 @@ func cmdInsert(args []string) error {
 		return fmt.Errorf("usage: e insert <file> <line> <text>")
 	}
 	path := args[0]
-	lineNum, err := strconv.Atoi(args[1])
+	addr, err := parseLineAddr(args[1])
 	if err != nil {
-		return fmt.Errorf("invalid line number: %w", err)
+		return err
 	}
+	lineNum := addr.line
 	text, err := getText(args, 2)
 	if err != nil {
 		return err
 	}
 
-	lines, err := readLines(path)
+	lines, format, err := readLines(path)
 	if err != nil {
 		return err
 	}
 	if lineNum < 1 || lineNum > len(lines)+1 {
 		return fmt.Errorf("line %d out of range (file has %d lines, insert accepts 1-%d)", lineNum, len(lines), len(lines)+1)
 	}
+	if err := checkExpected(lines, addr, addr, flagExpect); err != nil {
+		return err
+	}
 
 	original := copyLines(lines)
 	newTextLines := strings.Split(text, "\n")
@@ -473,7 +680,7 @@ func cmdInsert(args []string) error {
 	modified = append(modified, newTextLines...)
 	modified = append(modified, lines[lineNum-1:]...)
 
-	return writeResult(path, original, modified)
+	return writeResult(path, original, modified, format)
 }
 
 func cmdAppend(args []string) error {
@@ -481,22 +688,26 @@ func cmdAppend(args []string) error {
 		return fmt.Errorf("usage: e append <file> <line> <text>")
 	}
 	path := args[0]
-	lineNum, err := strconv.Atoi(args[1])
+	addr, err := parseLineAddr(args[1])
 	if err != nil {
-		return fmt.Errorf("invalid line number: %w", err)
+		return err
 	}
+	lineNum := addr.line
 	text, err := getText(args, 2)
 	if err != nil {
 		return err
 	}
 
-	lines, err := readLines(path)
+	lines, format, err := readLines(path)
 	if err != nil {
 		return err
 	}
 	if err := validateLine(lineNum, len(lines)); err != nil {
 		return err
 	}
+	if err := checkExpected(lines, addr, addr, flagExpect); err != nil {
+		return err
+	}
 
 	original := copyLines(lines)
 	newTextLines := strings.Split(text, "\n")
@@ -505,7 +716,7 @@ func cmdAppend(args []string) error {
 	modified = append(modified, newTextLines...)
 	modified = append(modified, lines[lineNum:]...)
 
-	return writeResult(path, original, modified)
+	return writeResult(path, original, modified, format)
 }
 
 // --- Content-addressed commands ---
@@ -530,26 +741,30 @@ func cmdReplace(args []string) error {
 		}
 	}
 
-	data, err := os.ReadFile(path)
+	lines, format, err := readLines(path)
 	if err != nil {
 		return err
 	}
-	content := string(data)
+	content := strings.Join(lines, "\n")
+	if format.finalNewline && len(lines) > 0 {
+		content += "\n"
+	}
 	original := content
 
 	if flagRegex {
-		re, err := regexp.Compile(oldText)
+		re, err := compileRegex(oldText)
 		if err != nil {
-			return fmt.Errorf("invalid regex %q: %w", oldText, err)
+			return err
 		}
 		if flagAll {
 			content = re.ReplaceAllString(content, newText)
 		} else {
-			loc := re.FindStringIndex(content)
+			loc := re.FindStringSubmatchIndex(content)
 			if loc == nil {
 				return fmt.Errorf("pattern %q not found", oldText)
 			}
-			content = content[:loc[0]] + re.ReplaceAllString(content[loc[0]:loc[1]], newText) + content[loc[1]:]
+			replacement := re.ExpandString(nil, newText, content, loc)
+			content = content[:loc[0]] + string(replacement) + content[loc[1]:]
 		}
 	} else {
 		if !strings.Contains(content, oldText) {
@@ -567,20 +782,7 @@ func cmdReplace(args []string) error {
 		return nil
 	}
 
-	originalLines := toLines(original)
-	modifiedLines := toLines(content)
-
-	if flagDiff {
-		printDiff(path, originalLines, modifiedLines)
-		return nil
-	}
-	if flagDryRun {
-		for i, line := range modifiedLines {
-			fmt.Fprintf(os.Stdout, "%4d\t%s\n", i+1, line)
-		}
-		return nil
-	}
-	return os.WriteFile(path, []byte(content), 0644)
+	return writeResult(path, toLines(original), toLines(content), format)
 }
 
 func cmdAfter(args []string) error {
@@ -594,33 +796,36 @@ func cmdAfter(args []string) error {
 		return err
 	}
 
-	lines, err := readLines(path)
+	lines, format, err := readLines(path)
 	if err != nil {
 		return err
 	}
 	original := copyLines(lines)
 
-	matcher, err := newMatcher(matchText)
+	matcher, err := newMatcher(matchText, flagRegex)
 	if err != nil {
 		return err
 	}
 
+	spans := findMatches(lines, matcher, flagAll)
+	if len(spans) == 0 {
+		return fmt.Errorf("pattern %q not found", matchText)
+	}
+	anchors := make(map[int]bool, len(spans))
+	for _, sp := range spans {
+		anchors[sp.last] = true
+	}
+
 	newTextLines := strings.Split(text, "\n")
-	modified := make([]string, 0, len(lines)+len(newTextLines))
-	matched := false
-	for _, line := range lines {
+	modified := make([]string, 0, len(lines)+len(anchors)*len(newTextLines))
+	for i, line := range lines {
 		modified = append(modified, line)
-		if matcher.matches(line) && (flagAll || !matched) {
+		if anchors[i] {
 			modified = append(modified, newTextLines...)
-			matched = true
 		}
 	}
 
-	if !matched {
-		return fmt.Errorf("pattern %q not found", matchText)
-	}
-
-	return writeResult(path, original, modified)
+	return writeResult(path, original, modified, format)
 }
 
 func cmdBefore(args []string) error {
@@ -634,72 +839,67 @@ func cmdBefore(args []string) error {
 		return err
 	}
 
-	lines, err := readLines(path)
+	lines, format, err := readLines(path)
 	if err != nil {
 		return err
 	}
 	original := copyLines(lines)
 
-	matcher, err := newMatcher(matchText)
+	matcher, err := newMatcher(matchText, flagRegex)
 	if err != nil {
 		return err
 	}
 
+	spans := findMatches(lines, matcher, flagAll)
+	if len(spans) == 0 {
+		return fmt.Errorf("pattern %q not found", matchText)
+	}
+	anchors := make(map[int]bool, len(spans))
+	for _, sp := range spans {
+		anchors[sp.first] = true
+	}
+
 	newTextLines := strings.Split(text, "\n")
-	modified := make([]string, 0, len(lines)+len(newTextLines))
-	matched := false
-	for _, line := range lines {
-		if matcher.matches(line) && (flagAll || !matched) {
+	modified := make([]string, 0, len(lines)+len(anchors)*len(newTextLines))
+	for i, line := range lines {
+		if anchors[i] {
 			modified = append(modified, newTextLines...)
-			matched = true
 		}
 		modified = append(modified, line)
 	}
 
-	if !matched {
-		return fmt.Errorf("pattern %q not found", matchText)
-	}
-
-	return writeResult(path, original, modified)
+	return writeResult(path, original, modified, format)
 }
 
 // --- Show command ---
 
 func cmdShow(args []string) error {
 	if len(args) == 0 {
-		return fmt.Errorf("usage: e show <file> [from-to]")
+		return fmt.Errorf("usage: e show [--hash] <file> [from-to]")
 	}
 	path := args[0]
 
-	f, err := os.Open(path)
+	lines, _, err := readLines(path)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	start, end := 1, -1 // -1 means "to EOF"
+	start, end := 1, len(lines)
 	if len(args) >= 2 {
 		s, e, err := parseRange(args[1])
 		if err != nil {
 			return err
 		}
 		start = s
-		end = e
+		if e < end {
+			end = e
+		}
 	}
 
-	scanner := bufio.NewScanner(f)
-	lineNum := 0
-	for scanner.Scan() {
-		lineNum++
-		if lineNum < start {
-			continue
-		}
-		if end > 0 && lineNum > end {
-			break
-		}
-		fmt.Fprintf(os.Stdout, "%4d\t%s\n", lineNum, scanner.Text())
+	for n := start; n <= end; n++ {
+		fmt.Fprint(os.Stdout, formatLine(n, lines[n-1], flagHash))
 	}
-	return scanner.Err()
+	return nil
 }
 
 // --- Helpers ---
@@ -719,14 +919,17 @@ func toLines(s string) []string {
 }
 
 // matcher wraps either a literal string match or a regex match.
+// Either may span several lines.
 type matcher struct {
 	literal string
 	re      *regexp.Regexp
 }
 
-func newMatcher(pattern string) (*matcher, error) {
-	if flagRegex {
-		re, err := regexp.Compile(pattern)
+func newMatcher(pattern string, regex bool) (*matcher, error) {
+	if regex {
+		// after and before used to match one line at a time; multi-line
+		// mode keeps ^ and $ at line boundaries now they match the file.
+		re, err := regexp.Compile("(?m)" + pattern)
 		if err != nil {
 			return nil, fmt.Errorf("invalid regex %q: %w", pattern, err)
 		}
@@ -735,9 +938,93 @@ func newMatcher(pattern string) (*matcher, error) {
 	return &matcher{literal: pattern}, nil
 }
 
-func (m *matcher) matches(line string) bool {
+// compileRegex compiles a --regex pattern.
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", pattern, err)
+	}
+	return re, nil
+}
+
+// findAll returns the byte spans of non-overlapping matches in content.
+func (m *matcher) findAll(content string) [][]int {
 	if m.re != nil {
-		return m.re.MatchString(line)
+		return m.re.FindAllStringIndex(content, -1)
+	}
+
+	var spans [][]int
+	for pos := 0; pos <= len(content); {
+		idx := strings.Index(content[pos:], m.literal)
+		if idx < 0 {
+			break
+		}
+		start := pos + idx
+		end := start + len(m.literal)
+		spans = append(spans, []int{start, end})
+		if end > start {
+			pos = end
+		} else if nl := strings.IndexByte(content[start:], '\n'); nl >= 0 {
+			pos = start + nl + 1 // empty pattern: once per line
+		} else {
+			break
+		}
 	}
-	return strings.Contains(line, m.literal)
+	return spans
+}
+
+// lineSpan is the first and last line (0-indexed) covered by a match.
+type lineSpan struct {
+	first, last int
+}
+
+// findMatches returns the lines covered by each match of m in lines, in
+// order. Whitespace (including newlines) at either end of a match does not
+// count towards its lines, so `foo\s*` still anchors on the line of foo.
+// Unless all is set only the first match is returned.
+func findMatches(lines []string, m *matcher, all bool) []lineSpan {
+	if len(lines) == 0 {
+		return nil
+	}
+	content := strings.Join(lines, "\n")
+
+	// starts[i] is the byte offset at which line i begins.
+	starts := make([]int, len(lines))
+	off := 0
+	for i, line := range lines {
+		starts[i] = off
+		off += len(line) + 1
+	}
+	lineOf := func(pos int) int {
+		return sort.Search(len(starts), func(i int) bool { return starts[i] > pos }) - 1
+	}
+
+	var spans []lineSpan
+	for _, loc := range m.findAll(content) {
+		start, end := loc[0], loc[1]
+		for start < end && isSpace(content[start]) {
+			start++
+		}
+		for end > start && isSpace(content[end-1]) {
+			end--
+		}
+		if start == end {
+			// Empty or all-whitespace match: anchor where it began.
+			start, end = loc[0], loc[0]
+		}
+
+		last := start
+		if end > start {
+			last = end - 1
+		}
+		spans = append(spans, lineSpan{first: lineOf(start), last: lineOf(last)})
+		if !all {
+			break
+		}
+	}
+	return spans
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
 }
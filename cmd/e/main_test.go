@@ -0,0 +1,249 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadLinesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		lines   []string
+		format  fileFormat
+	}{
+		{"lf", "a\nb\n", []string{"a", "b"}, fileFormat{eol: "\n", finalNewline: true}},
+		{"crlf", "a\r\nb\r\n", []string{"a", "b"}, fileFormat{eol: "\r\n", finalNewline: true}},
+		{"bom", bom + "a\nb\n", []string{"a", "b"}, fileFormat{eol: "\n", bom: true, finalNewline: true}},
+		{"bom crlf", bom + "a\r\nb\r\n", []string{"a", "b"}, fileFormat{eol: "\r\n", bom: true, finalNewline: true}},
+		{"no final newline", "a\r\nb", []string{"a", "b"}, fileFormat{eol: "\r\n"}},
+		{"mixed", "a\r\nb\nc\r\n", []string{"a\r", "b", "c\r"}, fileFormat{eol: "\n", finalNewline: true}},
+		{"empty", "", []string{}, fileFormat{eol: "\n", finalNewline: true}},
+		{"bom only", bom, []string{}, fileFormat{eol: "\n", bom: true, finalNewline: true}},
+		{"blank line", "\n", []string{""}, fileFormat{eol: "\n", finalNewline: true}},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, "f")
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		lines, format, err := readLines(path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if strings.Join(lines, "|") != strings.Join(tt.lines, "|") || len(lines) != len(tt.lines) {
+			t.Errorf("%s: lines = %q, want %q", tt.name, lines, tt.lines)
+		}
+		if format != tt.format {
+			t.Errorf("%s: format = %+v, want %+v", tt.name, format, tt.format)
+		}
+		if got := string(format.encode(lines)); got != tt.content {
+			t.Errorf("%s: encoded as %q, want %q", tt.name, got, tt.content)
+		}
+	}
+}
+
+func TestJoinEOL(t *testing.T) {
+	lines := []string{"a\r", "b"}
+	format := fileFormat{eol: "\n", bom: true, finalNewline: true}
+	tests := []struct {
+		eol, want string
+	}{
+		{"", bom + "a\r\nb\n"},
+		{"lf", bom + "a\nb\n"},
+		{"crlf", bom + "a\r\nb\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(format.join(lines, tt.eol)); got != tt.want {
+			t.Errorf("join with eol %q = %q, want %q", tt.eol, got, tt.want)
+		}
+	}
+}
+
+// TestEditKeepsFormat checks that commands write back the line endings,
+// byte order mark and final newline they read.
+func TestEditKeepsFormat(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, content string
+		run           func(path string) error
+		want          string
+	}{
+		{
+			name:    "set in a crlf file with a bom",
+			content: bom + "one\r\ntwo\r\n",
+			run:     func(path string) error { return cmdSet([]string{path, "2", "TWO"}) },
+			want:    bom + "one\r\nTWO\r\n",
+		},
+		{
+			name:    "append to a crlf file without a final newline",
+			content: "one\r\ntwo",
+			run:     func(path string) error { return cmdAppend([]string{path, "2", "three"}) },
+			want:    "one\r\ntwo\r\nthree",
+		},
+		{
+			name:    "replace across lines in a crlf file",
+			content: "one\r\ntwo\r\nthree\r\n",
+			run:     func(path string) error { return cmdReplace([]string{path, "one\ntwo", "1\n2"}) },
+			want:    "1\r\n2\r\nthree\r\n",
+		},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, "f")
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := tt.run(path); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, data, tt.want)
+		}
+	}
+}
+
+func TestParseRangeAddr(t *testing.T) {
+	tests := []struct {
+		s        string
+		from, to lineAddr
+		err      string
+	}{
+		{s: "3", from: lineAddr{line: 3}, to: lineAddr{line: 3}},
+		{s: "3#A3F", from: lineAddr{3, "a3f"}, to: lineAddr{3, "a3f"}},
+		{s: "2#abc-4#def", from: lineAddr{2, "abc"}, to: lineAddr{4, "def"}},
+		{s: "2-4#def", from: lineAddr{line: 2}, to: lineAddr{4, "def"}},
+		{s: "4#abc-2", err: "invalid range 4-2"},
+		{s: "0#abc", err: "invalid range 0-0"},
+		{s: "x#abc", err: `invalid line number "x"`},
+		{s: "2-y#abc", err: `invalid range end "y#abc"`},
+	}
+	for _, tt := range tests {
+		from, to, err := parseRangeAddr(tt.s)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.s, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || from != tt.from || to != tt.to {
+			t.Errorf("%s = %+v, %+v, %v; want %+v, %+v", tt.s, from, to, err, tt.from, tt.to)
+		}
+	}
+}
+
+func TestCheckExpected(t *testing.T) {
+	lines := []string{"one", "two", "three", "four"}
+	addr := func(line int, hash string) lineAddr { return lineAddr{line: line, hash: hash} }
+	h := func(line int) string { return lineHash(lines[line-1]) }
+	text := func(s string) *string { return &s }
+
+	tests := []struct {
+		name     string
+		from, to lineAddr
+		expect   *string
+		err      string
+	}{
+		{name: "no hash", from: addr(2, ""), to: addr(2, "")},
+		{name: "current hash", from: addr(2, h(2)), to: addr(2, h(2))},
+		{name: "current hashes", from: addr(1, h(1)), to: addr(3, h(3))},
+		{name: "stale hash", from: addr(2, "zzz"), to: addr(2, "zzz"),
+			err: "line 2 has changed (expected #zzz, now #" + h(2) + ")"},
+		{name: "stale end hash", from: addr(1, h(1)), to: addr(3, "zzz"),
+			err: "line 3 has changed (expected #zzz, now #" + h(3) + ")"},
+		{name: "hash past the end", from: addr(9, "abc"), to: addr(9, "abc"),
+			err: "line 9 does not exist (file has 4 lines)"},
+		{name: "expected text", from: addr(2, ""), to: addr(3, ""), expect: text("two\nthree")},
+		{name: "unexpected text", from: addr(2, ""), to: addr(3, ""), expect: text("two\n3"),
+			err: "lines 2-3 does not match the expected text"},
+		{name: "hash checked before text", from: addr(2, "zzz"), to: addr(2, "zzz"), expect: text("two"),
+			err: "line 2 has changed"},
+	}
+	for _, tt := range tests {
+		err := checkExpected(lines, tt.from, tt.to, tt.expect)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			continue
+		}
+		// The error shows the region with fresh hashes.
+		if n := tt.from.line; n <= len(lines) && !strings.Contains(err.Error(), "#"+h(n)+"\t"+lines[n-1]) {
+			t.Errorf("%s: error %q does not show line %d with its hash", tt.name, err, n)
+		}
+	}
+}
+
+// TestStaleHashLeavesFile checks that an edit addressed with a stale hash
+// fails without touching the file, and succeeds with the fresh one.
+func TestStaleHashLeavesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(path, []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdSet([]string{path, "2#zzz", "TWO"}); err == nil {
+		t.Error("set with a stale hash succeeded")
+	}
+	if err := cmdSet([]string{path, "2#" + lineHash("two"), "TWO"}); err != nil {
+		t.Error(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "one\nTWO\n" {
+		t.Errorf("got %q, want only the second edit applied", data)
+	}
+}
+
+func TestFindMatches(t *testing.T) {
+	lines := []string{"func a() {", "\tx := 1", "}", "", "func b() {", "\treturn", "}"}
+	tests := []struct {
+		pattern string
+		regex   bool
+		all     bool
+		want    []lineSpan
+	}{
+		{pattern: "x := 1", want: []lineSpan{{1, 1}}},
+		{pattern: "{\n\tx", want: []lineSpan{{0, 1}}},
+		{pattern: "func", want: []lineSpan{{0, 0}}},
+		{pattern: "func", all: true, want: []lineSpan{{0, 0}, {4, 4}}},
+		{pattern: "nowhere", all: true},
+		{pattern: `^func \w+`, regex: true, all: true, want: []lineSpan{{0, 0}, {4, 4}}},
+		{pattern: `\}$`, regex: true, all: true, want: []lineSpan{{2, 2}, {6, 6}}},
+		{pattern: `^$`, regex: true, all: true, want: []lineSpan{{3, 3}}},
+		{pattern: `\{\n\s*return`, regex: true, want: []lineSpan{{4, 5}}},
+		// Whitespace at either end of a match does not move it to other lines.
+		{pattern: `x := 1\s*`, regex: true, want: []lineSpan{{1, 1}}},
+		{pattern: `\s*return\s*`, regex: true, want: []lineSpan{{5, 5}}},
+		{pattern: `(?s)func a.*?\n\}`, regex: true, want: []lineSpan{{0, 2}}},
+	}
+	for _, tt := range tests {
+		m, err := newMatcher(tt.pattern, tt.regex)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.pattern, err)
+		}
+		got := findMatches(lines, m, tt.all)
+		if len(got) != len(tt.want) {
+			t.Errorf("%q (regex %v, all %v) = %v, want %v", tt.pattern, tt.regex, tt.all, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q (regex %v, all %v) = %v, want %v", tt.pattern, tt.regex, tt.all, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/c3d4r/agent-cli-tools/internal/diff"
+)
+
+func cmdPatch(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: e patch [file] < diff")
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("read patch: %w", err)
+	}
+	patches, err := diff.Parse(string(data))
+	if err != nil {
+		return err
+	}
+	if len(patches) == 0 {
+		return fmt.Errorf("no hunks found in patch")
+	}
+	if len(args) == 1 && len(patches) > 1 {
+		return fmt.Errorf("patch touches %d files; omit the file argument to use the names in the patch", len(patches))
+	}
+
+	opts := diff.Options{Fuzz: flagFuzz, MaxOffset: flagOffset}
+	var results []fileResult
+	byPath := make(map[string]int) // index in results
+	var failures []string
+	hunks := 0
+	for _, fp := range patches {
+		hunks += len(fp.Hunks)
+
+		var path string
+		create := false
+		if len(args) == 1 {
+			path = args[0]
+		} else if path, create, err = patchTarget(fp); err != nil {
+			return err
+		}
+
+		// A file may appear more than once; later parts apply on top.
+		idx, seen := byPath[filepath.Clean(path)]
+		if !seen {
+			r := fileResult{path: path}
+			if create {
+				if _, err := os.Lstat(path); err == nil {
+					return fmt.Errorf("%s: patch creates it, but it already exists", path)
+				}
+				r.format = fileFormat{eol: "\n", finalNewline: true}
+			} else if r.original, r.format, err = readLines(path); err != nil {
+				return err
+			}
+			r.modified = r.original
+			idx = len(results)
+			byPath[filepath.Clean(path)] = idx
+			results = append(results, r)
+		}
+		r := &results[idx]
+
+		fileHunks := fp.Hunks
+		if r.format.eol == "\r\n" {
+			// Lines of a CRLF file are read without their "\r".
+			fileHunks = trimCR(fileHunks)
+		}
+		modified, applied, err := diff.ApplyHunks(r.modified, fileHunks, opts)
+		for _, a := range applied {
+			if a.Offset != 0 || a.Fuzz != 0 {
+				fmt.Fprintf(os.Stderr, "%s: hunk %d applied at line %d (offset %+d, fuzz %d)\n",
+					path, a.Hunk, a.Line, a.Offset, a.Fuzz)
+			}
+		}
+		if err != nil {
+			for _, e := range unwrapAll(err) {
+				failures = append(failures, fmt.Sprintf("%s: %v", path, e))
+			}
+			continue
+		}
+		r.modified = modified
+
+		for _, h := range fp.Hunks {
+			if h.NewNoNewline {
+				r.format.finalNewline = false
+			} else if h.OldNoNewline {
+				r.format.finalNewline = true
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d hunks failed, no files changed:\n%s",
+			len(failures), hunks, strings.Join(failures, "\n"))
+	}
+	return writeResults(results)
+}
+
+// patchTarget returns the file a file patch applies to and whether the
+// patch creates it. Names with git's a/ and b/ prefixes are used as is if
+// such a file exists and without the prefix otherwise.
+func patchTarget(fp diff.FilePatch) (path string, create bool, err error) {
+	switch {
+	case fp.OldPath == "" && fp.NewPath == "":
+		return "", false, fmt.Errorf("patch has no file names; give the file to patch")
+	case fp.NewPath == diff.DevNull:
+		return "", false, fmt.Errorf("%s: deleting files is not supported", stripPrefix(fp.OldPath))
+	case fp.OldPath == diff.DevNull:
+		return stripPrefix(fp.NewPath), true, nil
+	}
+
+	for _, name := range []string{fp.NewPath, fp.OldPath} {
+		if _, err := os.Stat(name); err == nil {
+			return name, false, nil
+		}
+		if stripped := stripPrefix(name); stripped != name {
+			if _, err := os.Stat(stripped); err == nil {
+				return stripped, false, nil
+			}
+		}
+	}
+	return "", false, fmt.Errorf("%s: no such file", stripPrefix(fp.NewPath))
+}
+
+// stripPrefix removes a leading a/ or b/, as patch -p1 does for git diffs.
+func stripPrefix(name string) string {
+	if strings.HasPrefix(name, "a/") || strings.HasPrefix(name, "b/") {
+		return name[2:]
+	}
+	return name
+}
+
+// trimCR returns hunks with the "\r" removed from the end of each line.
+func trimCR(hunks []diff.Hunk) []diff.Hunk {
+	out := make([]diff.Hunk, len(hunks))
+	for i, h := range hunks {
+		h.Lines = append([]string(nil), h.Lines...)
+		for j, l := range h.Lines {
+			h.Lines[j] = strings.TrimSuffix(l, "\r")
+		}
+		out[i] = h
+	}
+	return out
+}
+
+// unwrapAll returns the errors joined in err, or err itself.
+func unwrapAll(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
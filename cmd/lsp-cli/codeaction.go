@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+	"github.com/c3d4r/agent-cli-tools/internal/textedit"
+)
+
+// cmdCodeActions lists the code actions for a position or range, numbered
+// from 1 in the order the server returns them, or applies one with
+// --apply N. The same location and --kind give the same numbering.
+func cmdCodeActions(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("code-actions", flag.ContinueOnError)
+	apply := fs.Int("apply", 0, "apply the code action with this number")
+	dryRun := fs.Bool("dry-run", false, "with --apply, print the diff instead of writing it")
+	kind := fs.String("kind", "", "only actions of this kind (quickfix, refactor, source.organizeImports, ...)")
+	args, err := parseCmdFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *apply < 0 || (*dryRun && *apply == 0) {
+		return fmt.Errorf("usage: lsp-cli code-actions <file:line:col[-line:col]> [--kind K] [--apply N [--dry-run]]")
+	}
+
+	file, rng, err := parseRangeLocation(args[0])
+	if err != nil {
+		return err
+	}
+
+	client, err := startClient(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	actions, err := codeActions(ctx, client, file, rng, *kind)
+	if err != nil {
+		return err
+	}
+	if len(actions) == 0 {
+		fmt.Fprintln(os.Stderr, "no code actions found")
+		os.Exit(2)
+	}
+
+	f := formatter()
+	if *apply == 0 {
+		return f.CodeActions(actions)
+	}
+	if *apply > len(actions) {
+		return fmt.Errorf("no code action %d; there are %d", *apply, len(actions))
+	}
+	action := actions[*apply-1]
+	if action.Disabled != nil {
+		return fmt.Errorf("code action %d is disabled: %s", *apply, action.Disabled.Reason)
+	}
+
+	action, changes, err := actionChanges(ctx, client, action)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		if action.Command != nil {
+			fmt.Fprintf(os.Stderr, "not running command %s (dry run)\n", action.Command.Command)
+		}
+		return f.FileChanges(changes)
+	}
+	if changes, err = runCodeAction(ctx, client, action, changes); err != nil {
+		return err
+	}
+	if len(changes) == 0 && action.Command == nil {
+		fmt.Fprintln(os.Stderr, "code action made no changes")
+		os.Exit(2)
+	}
+	return f.AppliedChanges(changes)
+}
+
+// codeActions returns the code actions for rng of file, of kind if it is
+// set, passing the server the diagnostics there for it to offer fixes of.
+func codeActions(ctx context.Context, client *lsp.Client, file string, rng lsp.Range, kind string) ([]lsp.CodeAction, error) {
+	// Quick fixes are offered for the diagnostics passed in the request,
+	// so wait for the server to publish them, leaving time to ask.
+	diagCtx, cancel := softContext(ctx)
+	uris, diags, missing, err := collectDiagnostics(diagCtx, client, []string{file})
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	uri := uris[0]
+	if len(missing) > 0 && flagVerbose {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", missingDiagnosticsError(missing))
+	}
+
+	var only []string
+	if kind != "" {
+		only = []string{kind}
+	}
+	actions, err := client.CodeActions(ctx, uri, rng, overlapping(diags[uri], rng), only)
+	if err != nil {
+		return nil, fmt.Errorf("code actions: %w", err)
+	}
+	return actions, nil
+}
+
+// actionChanges resolves an action if it is lazily computed and returns it
+// with the changes of its edit.
+func actionChanges(ctx context.Context, client *lsp.Client, action lsp.CodeAction) (lsp.CodeAction, []textedit.FileChange, error) {
+	// An action with neither an edit nor a command is lazily computed.
+	if action.Edit == nil && action.Command == nil && client.Capabilities().SupportsCodeActionResolve() {
+		resolved, err := client.ResolveCodeAction(ctx, action)
+		if err != nil {
+			return action, nil, fmt.Errorf("resolve code action: %w", err)
+		}
+		action = resolved
+	}
+
+	var changes []textedit.FileChange
+	if action.Edit != nil {
+		fileEdits, err := action.Edit.FileEdits()
+		if err != nil {
+			return action, nil, fmt.Errorf("code action: %w", err)
+		}
+		if changes, err = textedit.Compute(fileEdits); err != nil {
+			return action, nil, fmt.Errorf("code action: %w", err)
+		}
+	}
+	return action, changes, nil
+}
+
+// runCodeAction writes the changes of an action's edit and then runs its
+// command, applying the edits the server asks for meanwhile. Returns every
+// change made.
+func runCodeAction(ctx context.Context, client *lsp.Client, action lsp.CodeAction, changes []textedit.FileChange) ([]textedit.FileChange, error) {
+	// The edit is applied before the command is run, as the
+	// specification asks.
+	if len(changes) > 0 {
+		if err := textedit.Write(changes); err != nil {
+			return nil, fmt.Errorf("apply code action: %w", err)
+		}
+	}
+	if action.Command == nil {
+		return changes, nil
+	}
+
+	// Let the server see the edited files before the command.
+	for _, c := range changes {
+		if _, err := client.OpenFile(ctx, c.Path); err != nil {
+			return nil, err
+		}
+	}
+	// Commands usually change files by asking the client to apply an
+	// edit before they return.
+	var (
+		mu     sync.Mutex
+		edited []textedit.FileChange
+	)
+	remove, err := client.HandleApplyEdit(ctx, func(p lsp.ApplyWorkspaceEditParams) error {
+		fileEdits, err := p.Edit.FileEdits()
+		if err != nil {
+			return err
+		}
+		applied, err := textedit.Compute(fileEdits)
+		if err != nil {
+			return err
+		}
+		if err := textedit.Write(applied); err != nil {
+			return err
+		}
+		for _, c := range applied {
+			if _, err := client.OpenFile(ctx, c.Path); err != nil {
+				return err
+			}
+		}
+		mu.Lock()
+		edited = append(edited, applied...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = client.ExecuteCommand(ctx, *action.Command)
+	remove()
+	if err != nil {
+		return nil, fmt.Errorf("execute command %s: %w", action.Command.Command, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return append(changes, edited...), nil
+}
+
+// overlapping returns the diagnostics whose range touches rng.
+func overlapping(diags []lsp.Diagnostic, rng lsp.Range) []lsp.Diagnostic {
+	var out []lsp.Diagnostic
+	for _, d := range diags {
+		if !before(d.Range.End, rng.Start) && !before(rng.End, d.Range.Start) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func before(a, b lsp.Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
+
+// parseRangeLocation parses "file.go:line:col" or "file.go:line:col-line:col"
+// (1-indexed) into a file and a 0-indexed range. A single position gives an
+// empty range.
+func parseRangeLocation(s string) (string, lsp.Range, error) {
+	if i := strings.LastIndex(s, "-"); i >= 0 {
+		if end := strings.Split(s[i+1:], ":"); len(end) == 2 {
+			endLine, errLine := strconv.Atoi(end[0])
+			endCol, errCol := strconv.Atoi(end[1])
+			if errLine == nil && errCol == nil {
+				file, line, col, err := parseLocation(s[:i])
+				if err != nil {
+					return "", lsp.Range{}, err
+				}
+				rng := lsp.Range{
+					Start: lsp.Position{Line: line, Character: col},
+					End:   lsp.Position{Line: endLine - 1, Character: endCol - 1},
+				}
+				if endLine < 1 || endCol < 1 || before(rng.End, rng.Start) {
+					return "", lsp.Range{}, fmt.Errorf("invalid range end %q", s[i+1:])
+				}
+				return file, rng, nil
+			}
+		}
+	}
+
+	file, line, col, err := parseLocation(s)
+	if err != nil {
+		return "", lsp.Range{}, err
+	}
+	pos := lsp.Position{Line: line, Character: col}
+	return file, lsp.Range{Start: pos, End: pos}, nil
+}
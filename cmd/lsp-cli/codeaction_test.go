@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCodeActions(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "a.go")
+	const content = "package a\n// TODO: later\n"
+	read := func() string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		out   string // wanted in stdout
+		err   string // wanted in stderr
+		code  int
+		after string // the file afterwards
+	}{
+		{
+			name:  "list",
+			args:  []string{"a.go:2:4"},
+			out:   "1. [quickfix] Mark done (preferred)\n2. [source] Sign the file\n3. [refactor.extract] Extract (disabled: nothing to extract)\n",
+			after: content,
+		},
+		{name: "kind", args: []string{"a.go:2:4", "--kind", "source"}, out: "1. [source] Sign the file\n", after: content},
+		{name: "none", args: []string{"a.go:1:1", "--kind", "quickfix"}, err: "no code actions found", code: 2, after: content},
+		{
+			name:  "dry run",
+			args:  []string{"a.go:2:4", "--apply", "1", "--dry-run"},
+			out:   "-// TODO: later\n+// DONE: later\n",
+			after: content,
+		},
+		{name: "apply edit", args: []string{"a.go:2:4", "--apply", "1"}, out: "a.go: 1 edit", after: "package a\n// DONE: later\n"},
+		{name: "apply command", args: []string{"a.go:2:4", "--apply", "2"}, out: "a.go: 1 edit", after: "// signed\n" + content},
+		{name: "disabled", args: []string{"a.go:2:4", "--apply", "3"}, err: "code action 3 is disabled: nothing to extract", code: 1, after: content},
+		{name: "out of range", args: []string{"a.go:2:4", "--apply", "9"}, err: "no code action 9; there are 3", code: 1, after: content},
+	}
+	for _, tt := range tests {
+		writeFile(t, path, content)
+		out, errOut, code := runCLI(t, root, append([]string{"code-actions"}, tt.args...)...)
+		if code != tt.code || !strings.Contains(out, tt.out) || !strings.Contains(errOut, tt.err) {
+			t.Errorf("%s: exit %d, stdout %q, stderr %q; want exit %d, %q, %q", tt.name, code, out, errOut, tt.code, tt.out, tt.err)
+		}
+		if got := read(); got != tt.after {
+			t.Errorf("%s: file = %q, want %q", tt.name, got, tt.after)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+)
+
+// cmdComplete lists the completions the server offers at a position,
+// in the server's sort order, filtered by prefix and kind.
+func cmdComplete(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("complete", flag.ContinueOnError)
+	prefix := fs.String("prefix", "", "only completions starting with this text (case-insensitive)")
+	kinds := fs.String("kind", "", "only completions of these kinds, comma-separated (method,field,...)")
+	limit := fs.Int("limit", 50, "maximum number of completions to show (0 for all)")
+	args, err := parseCmdFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *limit < 0 {
+		return fmt.Errorf("usage: lsp-cli complete <file:line:col> [--prefix P] [--kind K,...] [--limit N]")
+	}
+
+	file, line, col, err := parseLocation(args[0])
+	if err != nil {
+		return err
+	}
+
+	client, err := startClient(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	uri, err := openAndWait(ctx, client, file)
+	if err != nil {
+		return err
+	}
+
+	items, total, err := completions(ctx, client, uri, line, col, *prefix, *kinds, *limit)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		fmt.Fprintln(os.Stderr, "no completions found")
+		os.Exit(2)
+	}
+	if len(items) < total && flagVerbose {
+		fmt.Fprintf(os.Stderr, "showing %d of %d completions\n", len(items), total)
+	}
+
+	return formatter().Completions(items)
+}
+
+// completions returns the completions at a position starting with prefix
+// and of one of kinds, a comma-separated list, in the server's sort order,
+// the first limit of them (all if limit is 0) with their documentation.
+// Returns the number there were before the limit too.
+func completions(ctx context.Context, client *lsp.Client, uri string, line, col int, prefix, kinds string, limit int) ([]lsp.CompletionItem, int, error) {
+	wantKind := make(map[string]bool)
+	for _, k := range strings.Split(kinds, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			wantKind[strings.ToLower(k)] = true
+		}
+	}
+
+	list, err := client.Completion(ctx, uri, line, col)
+	if err != nil {
+		return nil, 0, fmt.Errorf("completion: %w", err)
+	}
+
+	var items []lsp.CompletionItem
+	for _, item := range list.Items {
+		text := item.FilterText
+		if text == "" {
+			text = item.Label
+		}
+		if !strings.HasPrefix(strings.ToLower(text), strings.ToLower(prefix)) {
+			continue
+		}
+		if len(wantKind) > 0 && !wantKind[item.Kind.String()] {
+			continue
+		}
+		items = append(items, item)
+	}
+	total := len(items)
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return sortKey(items[i]) < sortKey(items[j])
+	})
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+
+	if client.Capabilities().SupportsCompletionResolve() {
+		for i, item := range items {
+			if len(item.Documentation) > 0 {
+				continue
+			}
+			resolved, err := client.ResolveCompletionItem(ctx, item)
+			if err != nil {
+				return nil, 0, fmt.Errorf("resolve completion: %w", err)
+			}
+			items[i] = resolved
+		}
+	}
+	return items, total, nil
+}
+
+// sortKey orders completion items as the server intends: by sort text,
+// which defaults to the label.
+func sortKey(item lsp.CompletionItem) string {
+	if item.SortText != "" {
+		return item.SortText
+	}
+	return item.Label
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestComplete(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.go"), "package a\ntype Pet\nfunc main()\nfunc Max()\nfunc min()\n")
+
+	pet := "class Pet\n    Documentation of Pet.\n"
+	maxFunc := "function Max func Max()\n    Documentation of Max.\n"
+	mainFunc := "function main func main()\n    Documentation of main.\n"
+	minFunc := "function min func min()\n    Documentation of min.\n"
+	tests := []struct {
+		name string
+		args []string
+		out  string
+		err  string // wanted in stderr
+		code int
+	}{
+		{name: "all", args: nil, out: pet + maxFunc + mainFunc + minFunc},
+		{name: "prefix", args: []string{"--prefix", "m"}, out: maxFunc + mainFunc + minFunc},
+		{name: "limit", args: []string{"--prefix", "m", "--limit", "2"}, out: maxFunc + mainFunc},
+		{name: "kind", args: []string{"--kind", "class"}, out: pet},
+		{name: "kinds", args: []string{"--kind", "class, function", "--prefix", "P"}, out: pet},
+		{name: "none", args: []string{"--prefix", "zzz"}, err: "no completions found", code: 2},
+	}
+	for _, tt := range tests {
+		out, errOut, code := runCLI(t, root, append([]string{"complete", "a.go:3:1"}, tt.args...)...)
+		if code != tt.code || out != tt.out || !strings.Contains(errOut, tt.err) {
+			t.Errorf("%s: exit %d, stdout %q, stderr %q; want exit %d, %q, %q", tt.name, code, out, errOut, tt.code, tt.out, tt.err)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/c3d4r/agent-cli-tools/internal/config"
+	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+	"github.com/c3d4r/agent-cli-tools/internal/output"
+)
+
+// exitDiagnostics is the exit status when diagnostics at or above the
+// --fail-on severity are reported.
+const exitDiagnostics = 3
+
+// cmdDiagnostics reports the diagnostics of files, directories (dir for
+// its files, dir/... for its whole tree) and glob patterns, or with
+// --workspace of every file the server has diagnostics for.
+func cmdDiagnostics(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("diagnostics", flag.ContinueOnError)
+	workspace := flags.Bool("workspace", false, "report every file the server has diagnostics for, not only those given")
+	severity := flags.String("severity", "hint", "least severe diagnostics to report: error, warning, info or hint")
+	failOn := flags.String("fail-on", "", "exit 3 if a reported diagnostic is at least this severe: error, warning, info or hint")
+	sources := flags.String("source", "", "only diagnostics from these sources, comma-separated")
+	codes := flags.String("code", "", "only diagnostics with these codes, comma-separated")
+	args, err := parseCmdFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		if !*workspace {
+			return fmt.Errorf("usage: lsp-cli diagnostics <file|dir|dir/...|glob> [...] [--workspace] [--severity S] [--fail-on S] [--source S,...] [--code C,...]")
+		}
+		args = []string{"./..."}
+	}
+
+	filter := diagFilter{sources: splitList(*sources), codes: splitList(*codes)}
+	if filter.severity, err = parseSeverity(*severity); err != nil {
+		return err
+	}
+	var failSeverity lsp.DiagnosticSeverity
+	if *failOn != "" {
+		if failSeverity, err = parseSeverity(*failOn); err != nil {
+			return err
+		}
+	}
+
+	files, err := expandFiles(args)
+	if err != nil {
+		return err
+	}
+
+	client, err := startClient(ctx, files[0])
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	uris, allDiags, missing, err := collectDiagnostics(ctx, client, files)
+	if err != nil {
+		return err
+	}
+
+	if *workspace {
+		if allDiags, err = workspaceDiagnostics(ctx, client); err != nil {
+			return err
+		}
+		for uri := range allDiags {
+			uris = append(uris, uri)
+		}
+	}
+
+	report := groupDiagnostics(uris, allDiags, filter)
+	if err := formatter().DiagnosticReport(report); err != nil {
+		return err
+	}
+
+	// A file the server has not diagnosed is not a clean file.
+	if len(missing) > 0 {
+		return missingDiagnosticsError(missing)
+	}
+	if failSeverity != 0 {
+		for _, fd := range report {
+			for _, d := range fd.Diagnostics {
+				if severityOf(d) <= failSeverity {
+					os.Exit(exitDiagnostics)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// diagFilter selects the diagnostics to report: those at least as severe
+// as severity and, if set, with one of the sources and one of the codes.
+type diagFilter struct {
+	severity lsp.DiagnosticSeverity
+	sources  map[string]bool
+	codes    map[string]bool
+}
+
+func (f diagFilter) keep(d lsp.Diagnostic) bool {
+	if severityOf(d) > f.severity {
+		return false
+	}
+	if len(f.sources) > 0 && !f.sources[d.Source] {
+		return false
+	}
+	if len(f.codes) > 0 && (d.Code == nil || !f.codes[fmt.Sprint(d.Code)]) {
+		return false
+	}
+	return true
+}
+
+// severityOf returns the severity of a diagnostic. One without a severity
+// is treated as an error, as editors do.
+func severityOf(d lsp.Diagnostic) lsp.DiagnosticSeverity {
+	if d.Severity == 0 {
+		return lsp.DiagnosticSeverityError
+	}
+	return d.Severity
+}
+
+func parseSeverity(s string) (lsp.DiagnosticSeverity, error) {
+	switch strings.ToLower(s) {
+	case "error":
+		return lsp.DiagnosticSeverityError, nil
+	case "warning":
+		return lsp.DiagnosticSeverityWarning, nil
+	case "info", "information":
+		return lsp.DiagnosticSeverityInformation, nil
+	case "hint":
+		return lsp.DiagnosticSeverityHint, nil
+	}
+	return 0, fmt.Errorf("unknown severity %q: want error, warning, info or hint", s)
+}
+
+// splitList splits a comma-separated flag value into a set.
+func splitList(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+// groupDiagnostics returns the diagnostics of each URI that pass the
+// filter, once per URI, sorted by path and each file's by position.
+func groupDiagnostics(uris []string, allDiags map[string][]lsp.Diagnostic, filter diagFilter) []output.FileDiagnostics {
+	seen := make(map[string]bool)
+	var files []output.FileDiagnostics
+	for _, uri := range uris {
+		if seen[uri] {
+			continue
+		}
+		seen[uri] = true
+		var diags []lsp.Diagnostic
+		for _, d := range allDiags[uri] {
+			if filter.keep(d) {
+				diags = append(diags, d)
+			}
+		}
+		sort.SliceStable(diags, func(i, j int) bool {
+			a, b := diags[i].Range.Start, diags[j].Range.Start
+			return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+		})
+		files = append(files, output.FileDiagnostics{URI: uri, Diagnostics: diags})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return lsp.URIToPath(files[i].URI) < lsp.URIToPath(files[j].URI)
+	})
+	return files
+}
+
+// expandFiles turns file, directory, dir/... and glob arguments into a
+// list of files. Files named explicitly are kept whatever their type; those
+// found by expansion are kept only if the server of the first file handles
+// them, since one server answers for all of them.
+func expandFiles(args []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	key := ""
+	add := func(path string, explicit bool) {
+		path = filepath.Clean(path)
+		if seen[path] {
+			return
+		}
+		k := config.ServerKey(path)
+		if key == "" {
+			key = k
+		}
+		if !explicit && (k == "" || k != key) {
+			return
+		}
+		seen[path] = true
+		files = append(files, path)
+	}
+
+	for _, arg := range args {
+		var matches []string
+		switch {
+		case arg == "..." || strings.HasSuffix(arg, "/..."):
+			root := strings.TrimSuffix(strings.TrimSuffix(arg, "..."), "/")
+			if root == "" {
+				root = "."
+			}
+			err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() {
+					if path != root && skipDir(d.Name()) {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if d.Type().IsRegular() {
+					matches = append(matches, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+
+		case strings.ContainsAny(arg, "*?["):
+			globbed, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, fmt.Errorf("bad pattern %q: %w", arg, err)
+			}
+			for _, path := range globbed {
+				if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+					matches = append(matches, path)
+				}
+			}
+
+		default:
+			info, err := os.Stat(arg)
+			if err != nil || !info.IsDir() {
+				add(arg, true)
+				continue
+			}
+			entries, err := os.ReadDir(arg)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if e.Type().IsRegular() {
+					matches = append(matches, filepath.Join(arg, e.Name()))
+				}
+			}
+		}
+
+		n := len(files)
+		for _, path := range matches {
+			add(path, false)
+		}
+		if len(files) == n && flagVerbose {
+			fmt.Fprintf(os.Stderr, "warning: %s matches no files\n", arg)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no source files found in %s", strings.Join(args, " "))
+	}
+	return files, nil
+}
+
+// skipDir reports whether a directory is left out of dir/..., as the go
+// command does for hidden, _-prefixed and testdata directories, along with
+// vendored and installed dependencies.
+func skipDir(name string) bool {
+	switch name {
+	case "testdata", "vendor", "node_modules":
+		return true
+	}
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// collectDiagnostics opens files and returns their diagnostics keyed by
+// URI, pulled from the server or waited for until ctx is done. Returns the
+// URIs of the opened files and, separately, those the server did not
+// diagnose in time.
+func collectDiagnostics(ctx context.Context, client *lsp.Client, files []string) (uris []string, diags map[string][]lsp.Diagnostic, missing []string, err error) {
+	uris = make([]string, len(files))
+	for i, file := range files {
+		uri, err := client.OpenFile(ctx, file)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		uris[i] = uri
+	}
+
+	// Pull requests made while the server is still loading may come back
+	// empty, so wait for it to be ready either way, but not for so long
+	// that there is no time left to ask: a server that only answers pull
+	// requests may never say it is ready.
+	waitCtx, cancel := softContext(ctx)
+	client.WaitReady(waitCtx)
+	cancel()
+
+	diags, missing, err = client.Diagnostics(ctx, uris)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("diagnostics: %w", err)
+	}
+	return uris, diags, missing, nil
+}
+
+// workspaceDiagnostics returns the diagnostics of every file the server
+// has diagnosed, pulling those of the workspace first if it can.
+func workspaceDiagnostics(ctx context.Context, client *lsp.Client) (map[string][]lsp.Diagnostic, error) {
+	if client.Capabilities().SupportsWorkspaceDiagnostics() {
+		if _, err := client.WorkspaceDiagnostics(ctx); err != nil {
+			return nil, fmt.Errorf("workspace diagnostics: %w", err)
+		}
+	}
+	return client.AllDiagnostics(), nil
+}
+
+// missingDiagnosticsError reports the files whose diagnostics did not
+// arrive before the timeout.
+func missingDiagnosticsError(missing []string) error {
+	paths := make([]string, len(missing))
+	for i, uri := range missing {
+		paths[i] = lsp.URIToPath(uri)
+	}
+	return fmt.Errorf("timed out after %ds waiting for diagnostics of %s; results are incomplete",
+		flagTimeout, strings.Join(paths, ", "))
+}
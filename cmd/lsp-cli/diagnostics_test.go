@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c3d4r/agent-cli-tools/internal/daemon"
+	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+)
+
+func TestExpandFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"a.go", "b.go", "notes.txt",
+		"sub/c.go",
+		"sub/testdata/x.go", "vendor/x.go", "node_modules/x.go", ".hidden/x.go", "_build/x.go",
+	} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		writeFile(t, path, "package x\n")
+	}
+	os.Mkdir(filepath.Join(dir, "empty"), 0755)
+
+	tests := []struct {
+		args []string
+		want string // paths relative to dir, space-separated
+		err  string
+	}{
+		{args: []string{"."}, want: "a.go b.go"},
+		{args: []string{"./..."}, want: "a.go b.go sub/c.go"},
+		{args: []string{"sub/..."}, want: "sub/c.go"},
+		{args: []string{"sub/testdata/..."}, want: "sub/testdata/x.go"},
+		{args: []string{"*.go", "a.go"}, want: "a.go b.go"},
+		{args: []string{"a.go", "./..."}, want: "a.go b.go sub/c.go"},
+		{args: []string{"empty"}, err: "no source files found in empty"},
+		{args: []string{"missing/..."}, err: "missing"},
+	}
+	t.Chdir(dir)
+	for _, tt := range tests {
+		files, err := expandFiles(tt.args)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%v: error = %v, want %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", tt.args, err)
+			continue
+		}
+		if got := filepath.ToSlash(strings.Join(files, " ")); got != tt.want {
+			t.Errorf("%v: files = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestDiagnosticsWorkspace(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.go"), "package a\n// TODO: a\n")
+	os.Mkdir(filepath.Join(root, "sub"), 0755)
+	writeFile(t, filepath.Join(root, "sub", "b.go"), "package b\n// TODO: b\n")
+	writeFile(t, filepath.Join(root, "testdata.go"), "package a\n")
+	os.Mkdir(filepath.Join(root, "testdata"), 0755)
+	writeFile(t, filepath.Join(root, "testdata", "c.go"), "package c\n// TODO: c\n")
+
+	out, errOut, code := runCLI(t, root, "diagnostics", "--workspace")
+	if code != 0 {
+		t.Fatalf("diagnostics --workspace: exit %d: %s", code, errOut)
+	}
+	for _, want := range []string{"a.go:2:4: warning", filepath.Join("sub", "b.go") + ":2:4: warning", "2 warnings in 2 files"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("diagnostics --workspace: got %q, want %q", out, want)
+		}
+	}
+	if strings.Contains(out, "c.go") {
+		t.Errorf("diagnostics --workspace reported a testdata file: %q", out)
+	}
+}
+
+// TestDiagnosticsWorkspaceDaemon checks that --workspace reports what the
+// daemon's server published for earlier sessions.
+func TestDiagnosticsWorkspaceDaemon(t *testing.T) {
+	t.Setenv(fakeServerEnv, "1")
+	socket := filepath.Join(t.TempDir(), "d.sock")
+	done := make(chan error, 1)
+	go func() { done <- daemon.Serve(socket, false, nil) }()
+	t.Cleanup(func() {
+		daemon.Stop(socket)
+		if err := <-done; err != nil {
+			t.Errorf("daemon: %v", err)
+		}
+	})
+	for deadline := time.Now().Add(5 * time.Second); !daemon.Running(socket); {
+		if time.Now().After(deadline) {
+			t.Fatal("daemon did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	root := t.TempDir()
+	a, b := filepath.Join(root, "a.go"), filepath.Join(root, "b.go")
+	writeFile(t, a, "package a\n// TODO: a\n")
+	writeFile(t, b, "package a\n")
+
+	if _, errOut, code := runCLI(t, root, "-socket", socket, "diagnostics", a); code != 0 {
+		t.Fatalf("diagnostics a.go: exit %d: %s", code, errOut)
+	}
+	out, errOut, code := runCLI(t, root, "-socket", socket, "diagnostics", "--workspace", b)
+	if code != 0 {
+		t.Fatalf("diagnostics --workspace b.go: exit %d: %s", code, errOut)
+	}
+	if !strings.Contains(out, a+":2:4: warning: unfinished work") || !strings.Contains(out, "1 warning in 1 file") {
+		t.Errorf("diagnostics --workspace b.go: got %q, want the warning a.go had in the earlier session", out)
+	}
+}
+
+func TestDiagnosticsFilters(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.go"), "package a\n// TODO: later\n// FIXME: now\n// XXX: why\n")
+	todo := "a.go:2:4: warning: unfinished work [fake todo]"
+	fixme := "a.go:3:4: error: broken [fake fixme]"
+	xxx := "a.go:4:4: hint: suspicious [lint 1]"
+
+	tests := []struct {
+		flags []string
+		want  []string // lines of output, a path ending with a.go first
+		code  int
+	}{
+		{nil, []string{todo, fixme, xxx, "1 error, 1 warning, 1 hint in 1 file"}, 0},
+		{[]string{"--severity", "warning"}, []string{todo, fixme, "1 error, 1 warning in 1 file"}, 0},
+		{[]string{"--severity", "error", "--fail-on", "error"}, []string{fixme, "1 error in 1 file"}, exitDiagnostics},
+		{[]string{"--code", "todo", "--fail-on", "error"}, []string{todo, "1 warning in 1 file"}, 0},
+		{[]string{"--code", "todo", "--fail-on", "hint"}, []string{todo, "1 warning in 1 file"}, exitDiagnostics},
+		{[]string{"--code", "fixme,1"}, []string{fixme, xxx, "1 error, 1 hint in 1 file"}, 0},
+		{[]string{"--source", "lint"}, []string{xxx, "1 hint in 1 file"}, 0},
+		{[]string{"--source", "other", "--fail-on", "hint"}, []string{"no diagnostics in 1 file"}, 0},
+	}
+	for _, tt := range tests {
+		args := append([]string{"diagnostics", "a.go"}, tt.flags...)
+		out, errOut, code := runCLI(t, root, args...)
+		if code != tt.code {
+			t.Errorf("%v: exit %d, want %d: %s", tt.flags, code, tt.code, errOut)
+		}
+		lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+		if len(lines) != len(tt.want) {
+			t.Errorf("%v: got %q, want %q", tt.flags, lines, tt.want)
+			continue
+		}
+		for i, w := range tt.want {
+			if !strings.HasSuffix(lines[i], w) {
+				t.Errorf("%v: got %q, want %q", tt.flags, lines, tt.want)
+				break
+			}
+		}
+	}
+
+	out, _, code := runCLI(t, root, "-json", "diagnostics", "a.go", "--severity", "warning", "--fail-on", "warning")
+	if code != exitDiagnostics || !strings.Contains(out, `"errors": 1,`) || !strings.Contains(out, `"warnings": 1,`) || !strings.Contains(out, `"hints": 0,`) {
+		t.Errorf("-json: exit %d, got %s", code, out)
+	}
+	if _, errOut, code := runCLI(t, root, "diagnostics", "a.go", "--severity", "bad"); code != 1 || !strings.Contains(errOut, `unknown severity "bad"`) {
+		t.Errorf("--severity bad: exit %d, %s", code, errOut)
+	}
+}
+
+// TestDiagnosticsAfterChange checks that once a document changes, the
+// diagnostics of its old text are not reported as its own: they are waited
+// for, and missing if the server does not publish them in time.
+func TestDiagnosticsAfterChange(t *testing.T) {
+	t.Setenv(fakeServerEnv, "1")
+	root := t.TempDir()
+	path := filepath.Join(root, "a.go")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := lsp.StartClient(ctx, []string{os.Args[0]}, root, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	check := func(ctx context.Context, text string, wantDiags int, wantMissing bool) {
+		t.Helper()
+		writeFile(t, path, text)
+		uri, err := client.OpenFile(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		diags, missing, err := client.Diagnostics(ctx, []string{uri})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (len(missing) > 0) != wantMissing {
+			t.Errorf("%q: missing %v, want missing %v", text, missing, wantMissing)
+		}
+		if _, current := client.CurrentDiagnostics(uri); current == wantMissing {
+			t.Errorf("%q: diagnostics current = %v, want %v", text, current, !wantMissing)
+		}
+		if !wantMissing && len(diags[uri]) != wantDiags {
+			t.Errorf("%q: %d diagnostics, want %d", text, len(diags[uri]), wantDiags)
+		}
+	}
+
+	check(ctx, "// TODO\n", 1, false)
+	check(ctx, "// SLOW\n", 0, false)
+	check(ctx, "// SLOW TODO FIXME\n", 2, false)
+	short, cancelShort := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancelShort()
+	check(short, "// QUIET\n", 0, true)
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+)
+
+// fakeServerEnv makes the test binary run as a fake language server
+// instead of running tests, so lsp-cli can spawn it like a real one.
+const fakeServerEnv = "LSP_CLI_FAKE_SERVER"
+
+// fakeServer is a language server that knows only words. A word's
+// definition is the line "func <word>", its references every occurrence,
+// and each "func" line is a symbol. A line containing TODO gets a
+// warning, one containing FIXME an error and one containing XXX a hint.
+// Diagnostics of a document containing SLOW are published late, and those
+// of one containing QUIET never.
+//
+// A TODO warning has a quick fix making it DONE, every document the source
+// action of signing it, which runs a command, and formatting removes
+// trailing whitespace.
+//
+// A function's body is the lines up to the next "func" line, and it calls
+// the functions named there. A line "type <word> extends <word>..." is a
+// class with the supertypes listed, and a line "var <word> <type>" declares
+// a variable of that type. Completion offers the document's types, then
+// its functions, and resolving an item adds its documentation. Renaming a
+// word renames every occurrence, and inside "<word>(" the signature of the
+// function is "func <word>()".
+type fakeServer struct {
+	t    *lsp.Transport
+	docs map[string]string // URI -> text
+
+	nextID   int64
+	commands map[int64]*int64 // applyEdit request id -> executeCommand id
+}
+
+func runFakeServer() {
+	s := &fakeServer{
+		t:        lsp.NewTransport(os.Stdin, os.Stdout),
+		docs:     make(map[string]string),
+		commands: make(map[int64]*int64),
+	}
+	for {
+		data, err := s.t.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg struct {
+			ID     *int64          `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		switch msg.Method {
+		case "":
+			// The client has applied the edit of a command, which is
+			// now done.
+			if msg.ID != nil {
+				if id, ok := s.commands[*msg.ID]; ok {
+					delete(s.commands, *msg.ID)
+					s.reply(id, nil)
+				}
+			}
+			continue
+		case "exit":
+			return
+		case "workspace/executeCommand":
+			s.executeCommand(msg.ID, msg.Params)
+			continue
+		}
+		result := s.handle(msg.Method, msg.Params)
+		if msg.ID != nil {
+			s.reply(msg.ID, result)
+		}
+	}
+}
+
+func (s *fakeServer) reply(id *int64, result interface{}) {
+	resultJSON, _ := json.Marshal(result)
+	resp, _ := json.Marshal(lsp.Response{JSONRPC: "2.0", ID: id, Result: resultJSON})
+	s.t.WriteMessage(resp)
+}
+
+// executeCommand runs fake.sign, which asks the client to insert a line
+// at the start of the document given, and answers once it has.
+func (s *fakeServer) executeCommand(id *int64, params json.RawMessage) {
+	var p lsp.ExecuteCommandParams
+	json.Unmarshal(params, &p)
+	var uri string
+	if p.Command != "fake.sign" || len(p.Arguments) != 1 || json.Unmarshal(p.Arguments[0], &uri) != nil {
+		resp, _ := json.Marshal(lsp.Response{JSONRPC: "2.0", ID: id, Error: &lsp.ResponseError{Code: lsp.CodeMethodNotFound, Message: "unknown command " + p.Command}})
+		s.t.WriteMessage(resp)
+		return
+	}
+	s.nextID++
+	s.commands[s.nextID] = id
+	edit := lsp.ApplyWorkspaceEditParams{Label: "sign", Edit: lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{
+		uri: {{NewText: "// signed\n"}},
+	}}}
+	paramsJSON, _ := json.Marshal(edit)
+	req, _ := json.Marshal(lsp.Request{JSONRPC: "2.0", ID: &s.nextID, Method: "workspace/applyEdit", Params: paramsJSON})
+	s.t.WriteMessage(req)
+}
+
+func (s *fakeServer) handle(method string, params json.RawMessage) interface{} {
+	switch method {
+	case "initialize":
+		return lsp.InitializeResult{Capabilities: lsp.ServerCapabilities{
+			DefinitionProvider:      true,
+			ReferencesProvider:      true,
+			HoverProvider:           true,
+			DocumentSymbolProvider:  true,
+			ImplementationProvider:  true,
+			WorkspaceSymbolProvider: true,
+			CodeActionProvider:      true,
+			ExecuteCommandProvider:  map[string]interface{}{"commands": []string{"fake.sign"}},
+
+			DocumentFormattingProvider:      true,
+			DocumentRangeFormattingProvider: true,
+			CallHierarchyProvider:           true,
+			TypeHierarchyProvider:           true,
+			TypeDefinitionProvider:          true,
+			DeclarationProvider:             true,
+			CompletionProvider:              map[string]interface{}{"resolveProvider": true},
+			SignatureHelpProvider:           map[string]interface{}{"triggerCharacters": []string{"("}},
+			RenameProvider:                  true,
+		}}
+
+	case "textDocument/didOpen":
+		var p lsp.DidOpenTextDocumentParams
+		json.Unmarshal(params, &p)
+		s.update(p.TextDocument.URI, p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p lsp.DidChangeTextDocumentParams
+		json.Unmarshal(params, &p)
+		s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var p lsp.DidCloseTextDocumentParams
+		json.Unmarshal(params, &p)
+		delete(s.docs, p.TextDocument.URI)
+
+	case "textDocument/definition":
+		uri, word := s.wordAt(params)
+		for _, loc := range s.find(uri, word) {
+			if strings.HasPrefix(s.line(loc), "func "+word) {
+				return []lsp.Location{loc}
+			}
+		}
+		return []lsp.Location{}
+	case "textDocument/references":
+		uri, word := s.wordAt(params)
+		return s.find(uri, word)
+	case "textDocument/hover":
+		_, word := s.wordAt(params)
+		contents, _ := json.Marshal(lsp.MarkupContent{Kind: "markdown", Value: fmt.Sprintf("```go\nfunc %s()\n```", word)})
+		return lsp.Hover{Contents: contents}
+	case "textDocument/typeDefinition":
+		// A single location, as some servers answer.
+		uri, word := s.wordAt(params)
+		for _, loc := range s.find(uri, word) {
+			if typ, ok := strings.CutPrefix(s.line(loc), "var "+word+" "); ok {
+				for _, ty := range s.types(uri) {
+					if ty.item.Name == typ {
+						return lsp.Location{URI: uri, Range: ty.item.SelectionRange}
+					}
+				}
+			}
+		}
+		return nil
+	case "textDocument/declaration":
+		// Location links, as some servers answer.
+		uri, word := s.wordAt(params)
+		links := []lsp.LocationLink{}
+		for _, loc := range s.find(uri, word) {
+			line := s.line(loc)
+			if strings.HasPrefix(line, "var "+word) || strings.HasPrefix(line, "type "+word) {
+				links = append(links, lsp.LocationLink{TargetURI: uri, TargetRange: loc.Range, TargetSelectionRange: loc.Range})
+			}
+		}
+		return links
+	case "textDocument/implementation":
+		return []lsp.Location{}
+	case "textDocument/documentSymbol":
+		var p lsp.DocumentSymbolParams
+		json.Unmarshal(params, &p)
+		syms := []lsp.DocumentSymbol{}
+		for _, si := range s.symbols(p.TextDocument.URI) {
+			syms = append(syms, lsp.DocumentSymbol{Name: si.Name, Kind: si.Kind, Range: si.Location.Range, SelectionRange: si.Location.Range})
+		}
+		return syms
+	case "workspace/symbol":
+		var p lsp.WorkspaceSymbolParams
+		json.Unmarshal(params, &p)
+		syms := []lsp.SymbolInformation{}
+		for uri := range s.docs {
+			for _, si := range s.symbols(uri) {
+				if strings.Contains(si.Name, p.Query) {
+					syms = append(syms, si)
+				}
+			}
+		}
+		return syms
+
+	case "textDocument/prepareCallHierarchy":
+		uri, word := s.wordAt(params)
+		items := []lsp.CallHierarchyItem{}
+		for _, si := range s.symbols(uri) {
+			if si.Name == word {
+				items = append(items, callItem(si))
+			}
+		}
+		return items
+	case "callHierarchy/incomingCalls":
+		var p lsp.CallHierarchyIncomingCallsParams
+		json.Unmarshal(params, &p)
+		calls := []lsp.CallHierarchyIncomingCall{}
+		for _, si := range s.symbols(p.Item.URI) {
+			if sites := s.callSites(si, p.Item.Name); len(sites) > 0 {
+				calls = append(calls, lsp.CallHierarchyIncomingCall{From: callItem(si), FromRanges: sites})
+			}
+		}
+		return calls
+	case "callHierarchy/outgoingCalls":
+		var p lsp.CallHierarchyOutgoingCallsParams
+		json.Unmarshal(params, &p)
+		calls := []lsp.CallHierarchyOutgoingCall{}
+		syms := s.symbols(p.Item.URI)
+		for _, from := range syms {
+			if from.Name != p.Item.Name {
+				continue
+			}
+			for _, si := range syms {
+				if sites := s.callSites(from, si.Name); len(sites) > 0 {
+					calls = append(calls, lsp.CallHierarchyOutgoingCall{To: callItem(si), FromRanges: sites})
+				}
+			}
+		}
+		return calls
+
+	case "textDocument/prepareTypeHierarchy":
+		uri, word := s.wordAt(params)
+		items := []lsp.TypeHierarchyItem{}
+		for _, ty := range s.types(uri) {
+			if ty.item.Name == word {
+				items = append(items, ty.item)
+			}
+		}
+		return items
+	case "typeHierarchy/supertypes":
+		var p lsp.TypeHierarchySupertypesParams
+		json.Unmarshal(params, &p)
+		types := s.types(p.Item.URI)
+		items := []lsp.TypeHierarchyItem{}
+		for _, ty := range types {
+			if ty.item.Name != p.Item.Name {
+				continue
+			}
+			for _, name := range ty.supers {
+				for _, super := range types {
+					if super.item.Name == name {
+						items = append(items, super.item)
+					}
+				}
+			}
+		}
+		return items
+	case "typeHierarchy/subtypes":
+		var p lsp.TypeHierarchySubtypesParams
+		json.Unmarshal(params, &p)
+		items := []lsp.TypeHierarchyItem{}
+		for _, ty := range s.types(p.Item.URI) {
+			if slices.Contains(ty.supers, p.Item.Name) {
+				items = append(items, ty.item)
+			}
+		}
+		return items
+
+	case "textDocument/completion":
+		uri, _ := s.wordAt(params)
+		list := lsp.CompletionList{Items: []lsp.CompletionItem{}}
+		for _, ty := range s.types(uri) {
+			list.Items = append(list.Items, lsp.CompletionItem{Label: ty.item.Name, Kind: 7, SortText: "1" + ty.item.Name})
+		}
+		for _, si := range s.symbols(uri) {
+			list.Items = append(list.Items, lsp.CompletionItem{Label: si.Name, Kind: 3, Detail: "func " + si.Name + "()", SortText: "2" + si.Name})
+		}
+		return list
+	case "completionItem/resolve":
+		var item lsp.CompletionItem
+		json.Unmarshal(params, &item)
+		item.Documentation, _ = json.Marshal(lsp.MarkupContent{Kind: "markdown", Value: "Documentation of " + item.Label + "."})
+		return item
+
+	case "textDocument/signatureHelp":
+		var p lsp.SignatureHelpParams
+		json.Unmarshal(params, &p)
+		lines := strings.Split(s.docs[p.TextDocument.URI], "\n")
+		if p.Position.Line >= len(lines) {
+			return nil
+		}
+		line := lines[p.Position.Line][:min(p.Position.Character, len(lines[p.Position.Line]))]
+		open := strings.LastIndexByte(line, '(')
+		if open < 0 {
+			return nil
+		}
+		start := open
+		for start > 0 && isWordByte(line[start-1]) {
+			start--
+		}
+		for _, si := range s.symbols(p.TextDocument.URI) {
+			if si.Name == line[start:open] {
+				return lsp.SignatureHelp{Signatures: []lsp.SignatureInformation{{Label: "func " + si.Name + "()"}}}
+			}
+		}
+		return nil
+	case "textDocument/rename":
+		var p lsp.RenameParams
+		json.Unmarshal(params, &p)
+		uri, word := s.wordAt(params)
+		var edits []lsp.TextEdit
+		for _, loc := range s.find(uri, word) {
+			edits = append(edits, lsp.TextEdit{Range: loc.Range, NewText: p.NewName})
+		}
+		if len(edits) == 0 {
+			return nil
+		}
+		return lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{uri: edits}}
+
+	case "textDocument/codeAction":
+		var p lsp.CodeActionParams
+		json.Unmarshal(params, &p)
+		return s.codeActions(p)
+	case "textDocument/formatting":
+		var p lsp.DocumentFormattingParams
+		json.Unmarshal(params, &p)
+		return s.format(p.TextDocument.URI, 0, -1)
+	case "textDocument/rangeFormatting":
+		var p lsp.DocumentRangeFormattingParams
+		json.Unmarshal(params, &p)
+		return s.format(p.TextDocument.URI, p.Range.Start.Line, p.Range.End.Line)
+	}
+	return nil
+}
+
+// codeActions returns a quick fix for each TODO warning given, the source
+// action of signing the document and a disabled refactoring, those of the
+// kinds asked for.
+func (s *fakeServer) codeActions(p lsp.CodeActionParams) []lsp.CodeAction {
+	uri := p.TextDocument.URI
+	var all []lsp.CodeAction
+	for _, d := range p.Context.Diagnostics {
+		if d.Code != "todo" {
+			continue
+		}
+		all = append(all, lsp.CodeAction{
+			Title:       "Mark done",
+			Kind:        "quickfix",
+			Diagnostics: []lsp.Diagnostic{d},
+			IsPreferred: true,
+			Edit:        &lsp.WorkspaceEdit{Changes: map[string][]lsp.TextEdit{uri: {{Range: d.Range, NewText: "DONE"}}}},
+		})
+	}
+	arg, _ := json.Marshal(uri)
+	all = append(all,
+		lsp.CodeAction{Title: "Sign the file", Kind: "source", Command: &lsp.Command{Title: "Sign", Command: "fake.sign", Arguments: []json.RawMessage{arg}}},
+		lsp.CodeAction{Title: "Extract", Kind: "refactor.extract", Disabled: &lsp.CodeActionDisabled{Reason: "nothing to extract"}},
+	)
+
+	actions := []lsp.CodeAction{}
+	for _, a := range all {
+		keep := len(p.Context.Only) == 0
+		for _, kind := range p.Context.Only {
+			keep = keep || a.Kind == kind || strings.HasPrefix(a.Kind, kind+".")
+		}
+		if keep {
+			actions = append(actions, a)
+		}
+	}
+	return actions
+}
+
+// format returns edits removing the trailing whitespace of the lines from
+// first up to but not including end, or to the end of the document if end
+// is negative.
+func (s *fakeServer) format(uri string, first, end int) []lsp.TextEdit {
+	edits := []lsp.TextEdit{}
+	for i, line := range strings.Split(s.docs[uri], "\n") {
+		if i < first || (end >= 0 && i >= end) {
+			continue
+		}
+		if trimmed := strings.TrimRight(line, " \t"); trimmed != line {
+			edits = append(edits, lsp.TextEdit{Range: lsp.Range{
+				Start: lsp.Position{Line: i, Character: len(trimmed)},
+				End:   lsp.Position{Line: i, Character: len(line)},
+			}})
+		}
+	}
+	return edits
+}
+
+// fakeDiagnostics are the diagnostics of the lines containing each word.
+var fakeDiagnostics = []struct {
+	word string
+	diag lsp.Diagnostic
+}{
+	{"TODO", lsp.Diagnostic{Severity: lsp.DiagnosticSeverityWarning, Message: "unfinished work", Source: "fake", Code: "todo"}},
+	{"FIXME", lsp.Diagnostic{Severity: lsp.DiagnosticSeverityError, Message: "broken", Source: "fake", Code: "fixme"}},
+	{"XXX", lsp.Diagnostic{Severity: lsp.DiagnosticSeverityHint, Message: "suspicious", Source: "lint", Code: 1}},
+}
+
+// update records a document's text and publishes its diagnostics.
+func (s *fakeServer) update(uri, text string) {
+	s.docs[uri] = text
+	if strings.Contains(text, "QUIET") {
+		return
+	}
+	if strings.Contains(text, "SLOW") {
+		time.Sleep(100 * time.Millisecond)
+	}
+	diags := []lsp.Diagnostic{}
+	for i, line := range strings.Split(text, "\n") {
+		for _, m := range fakeDiagnostics {
+			if col := strings.Index(line, m.word); col >= 0 {
+				d := m.diag
+				d.Range = lsp.Range{Start: lsp.Position{Line: i, Character: col}, End: lsp.Position{Line: i, Character: col + len(m.word)}}
+				diags = append(diags, d)
+			}
+		}
+	}
+	s.notify("textDocument/publishDiagnostics", lsp.PublishDiagnosticsParams{URI: uri, Diagnostics: diags})
+}
+
+func (s *fakeServer) notify(method string, params interface{}) {
+	paramsJSON, _ := json.Marshal(params)
+	data, _ := json.Marshal(lsp.Request{JSONRPC: "2.0", Method: method, Params: paramsJSON})
+	s.t.WriteMessage(data)
+}
+
+// wordAt returns the URI and the identifier at a text document position.
+func (s *fakeServer) wordAt(params json.RawMessage) (string, string) {
+	var p lsp.TextDocumentPositionParams
+	json.Unmarshal(params, &p)
+	lines := strings.Split(s.docs[p.TextDocument.URI], "\n")
+	if p.Position.Line >= len(lines) {
+		return p.TextDocument.URI, ""
+	}
+	line := lines[p.Position.Line]
+	start := min(p.Position.Character, len(line))
+	end := start
+	for start > 0 && isWordByte(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isWordByte(line[end]) {
+		end++
+	}
+	return p.TextDocument.URI, line[start:end]
+}
+
+// find returns every whole-word occurrence of word in a document.
+func (s *fakeServer) find(uri, word string) []lsp.Location {
+	locs := []lsp.Location{}
+	if word == "" {
+		return locs
+	}
+	for i, line := range strings.Split(s.docs[uri], "\n") {
+		for col := 0; ; {
+			j := strings.Index(line[col:], word)
+			if j < 0 {
+				break
+			}
+			start, end := col+j, col+j+len(word)
+			if (start == 0 || !isWordByte(line[start-1])) && (end == len(line) || !isWordByte(line[end])) {
+				locs = append(locs, lsp.Location{URI: uri, Range: lsp.Range{
+					Start: lsp.Position{Line: i, Character: start},
+					End:   lsp.Position{Line: i, Character: end},
+				}})
+			}
+			col = end
+		}
+	}
+	return locs
+}
+
+func (s *fakeServer) line(loc lsp.Location) string {
+	return strings.Split(s.docs[loc.URI], "\n")[loc.Range.Start.Line]
+}
+
+// symbols returns a function symbol for every "func" line of a document.
+func (s *fakeServer) symbols(uri string) []lsp.SymbolInformation {
+	var syms []lsp.SymbolInformation
+	for i, line := range strings.Split(s.docs[uri], "\n") {
+		name, ok := strings.CutPrefix(line, "func ")
+		if !ok {
+			continue
+		}
+		if j := strings.IndexByte(name, '('); j >= 0 {
+			name = name[:j]
+		}
+		syms = append(syms, lsp.SymbolInformation{Name: name, Kind: lsp.SymbolKindFunction, Location: lsp.Location{
+			URI: uri,
+			Range: lsp.Range{
+				Start: lsp.Position{Line: i, Character: 5},
+				End:   lsp.Position{Line: i, Character: 5 + len(name)},
+			},
+		}})
+	}
+	return syms
+}
+
+// callSites returns where the body of function from calls name.
+func (s *fakeServer) callSites(from lsp.SymbolInformation, name string) []lsp.Range {
+	lines := strings.Split(s.docs[from.Location.URI], "\n")
+	first := from.Location.Range.Start.Line + 1
+	end := first
+	for end < len(lines) && !strings.HasPrefix(lines[end], "func ") {
+		end++
+	}
+	var sites []lsp.Range
+	for _, loc := range s.find(from.Location.URI, name) {
+		if line := loc.Range.Start.Line; line >= first && line < end {
+			sites = append(sites, loc.Range)
+		}
+	}
+	return sites
+}
+
+func callItem(si lsp.SymbolInformation) lsp.CallHierarchyItem {
+	return lsp.CallHierarchyItem{Name: si.Name, Kind: si.Kind, URI: si.Location.URI, Range: si.Location.Range, SelectionRange: si.Location.Range}
+}
+
+type fakeType struct {
+	item   lsp.TypeHierarchyItem
+	supers []string
+}
+
+// types returns a class for every "type" line of a document.
+func (s *fakeServer) types(uri string) []fakeType {
+	var types []fakeType
+	for i, line := range strings.Split(s.docs[uri], "\n") {
+		decl, ok := strings.CutPrefix(line, "type ")
+		if !ok {
+			continue
+		}
+		name, supers, _ := strings.Cut(decl, " extends ")
+		r := lsp.Range{
+			Start: lsp.Position{Line: i, Character: 5},
+			End:   lsp.Position{Line: i, Character: 5 + len(name)},
+		}
+		types = append(types, fakeType{
+			item:   lsp.TypeHierarchyItem{Name: name, Kind: lsp.SymbolKindClass, URI: uri, Range: r, SelectionRange: r},
+			supers: strings.Fields(supers),
+		})
+	}
+	return types
+}
+
+func isWordByte(b byte) bool {
+	return b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
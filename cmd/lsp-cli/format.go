@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+	"github.com/c3d4r/agent-cli-tools/internal/textedit"
+)
+
+// cmdFormat formats files with the language server. By default the result
+// is written; --diff prints it instead and --check lists the files that
+// are not formatted and exits 1.
+func cmdFormat(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("format", flag.ContinueOnError)
+	lineRange := fs.String("range", "", "format only lines L1-L2 (1-indexed, inclusive)")
+	showDiff := fs.Bool("diff", false, "print the changes as a diff instead of writing them")
+	check := fs.Bool("check", false, "list files that are not formatted and exit 1, writing nothing")
+	tabSize := fs.Int("tab-size", 4, "width of an indentation level")
+	spaces := fs.Bool("spaces", false, "indent with spaces (default: as the file already does)")
+	args, err := parseCmdFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || (*showDiff && *check) || (*lineRange != "" && len(args) > 1) || *tabSize < 1 {
+		return fmt.Errorf("usage: lsp-cli format <file> [file...] [--range L1-L2] [--diff|--check] [--tab-size N] [--spaces]")
+	}
+	spacesSet := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "spaces" {
+			spacesSet = true
+		}
+	})
+
+	var rng *lsp.Range
+	if *lineRange != "" {
+		r, err := parseLineRange(*lineRange)
+		if err != nil {
+			return err
+		}
+		rng = &r
+	}
+
+	client, err := startClient(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if _, err := openAndWait(ctx, client, args[0]); err != nil {
+		return err
+	}
+	var insertSpaces *bool
+	if spacesSet {
+		insertSpaces = spaces
+	}
+	changes, err := formatChanges(ctx, client, args, rng, *tabSize, insertSpaces)
+	if err != nil {
+		return err
+	}
+
+	f := formatter()
+	switch {
+	case *check:
+		for _, c := range changes {
+			fmt.Println(c.Path)
+		}
+		if len(changes) > 0 {
+			os.Exit(1)
+		}
+		return nil
+	case *showDiff:
+		return f.FileChanges(changes)
+	}
+	if err := textedit.Write(changes); err != nil {
+		return fmt.Errorf("format: %w", err)
+	}
+	return f.AppliedChanges(changes)
+}
+
+// formatChanges returns the changes formatting files would make, or only
+// the lines of rng if it is set. Files are indented with spaces if
+// insertSpaces says so, or else as they already are.
+func formatChanges(ctx context.Context, client *lsp.Client, files []string, rng *lsp.Range, tabSize int, insertSpaces *bool) ([]textedit.FileChange, error) {
+	caps := client.Capabilities()
+	if rng != nil && !caps.SupportsRangeFormatting() {
+		return nil, fmt.Errorf("the language server does not support range formatting")
+	}
+	if rng == nil && !caps.SupportsFormatting() {
+		return nil, fmt.Errorf("the language server does not support formatting")
+	}
+
+	edits := make(map[string][]lsp.TextEdit)
+	for _, file := range files {
+		uri, err := client.OpenFile(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+
+		opts := lsp.FormattingOptions{TabSize: tabSize}
+		if insertSpaces != nil {
+			opts.InsertSpaces = *insertSpaces
+		} else {
+			opts.InsertSpaces = indentsWithSpaces(file)
+		}
+
+		var fileEdits []lsp.TextEdit
+		if rng != nil {
+			fileEdits, err = client.RangeFormatting(ctx, uri, *rng, opts)
+		} else {
+			fileEdits, err = client.Formatting(ctx, uri, opts)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("format %s: %w", file, err)
+		}
+		if len(fileEdits) > 0 {
+			edits[uri] = fileEdits
+		}
+	}
+
+	changes, err := textedit.Compute(edits)
+	if err != nil {
+		return nil, fmt.Errorf("format: %w", err)
+	}
+	return changes, nil
+}
+
+// parseLineRange parses "L1-L2" or "L" (1-indexed, inclusive) into a range
+// covering those lines.
+func parseLineRange(s string) (lsp.Range, error) {
+	from, to, found := strings.Cut(s, "-")
+	start, err := strconv.Atoi(from)
+	if err != nil {
+		return lsp.Range{}, fmt.Errorf("invalid range %q: want L1-L2", s)
+	}
+	end := start
+	if found {
+		if end, err = strconv.Atoi(to); err != nil {
+			return lsp.Range{}, fmt.Errorf("invalid range %q: want L1-L2", s)
+		}
+	}
+	if start < 1 || end < start {
+		return lsp.Range{}, fmt.Errorf("invalid range %q: lines must be >= 1 and in order", s)
+	}
+	return lsp.Range{
+		Start: lsp.Position{Line: start - 1},
+		End:   lsp.Position{Line: end},
+	}, nil
+}
+
+// indentsWithSpaces reports whether the first indented line of a file is
+// indented with spaces rather than a tab.
+func indentsWithSpaces(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "\t") {
+			return false
+		}
+		if strings.HasPrefix(line, " ") {
+			return true
+		}
+	}
+	return false
+}
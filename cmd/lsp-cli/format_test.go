@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	root := t.TempDir()
+	a, b := filepath.Join(root, "a.go"), filepath.Join(root, "b.go")
+	const messy = "package a \nfunc f() {\t\n}\n"
+	const clean = "package a\nfunc f() {\n}\n"
+	read := func(path string) string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	tests := []struct {
+		name       string
+		args       []string
+		out        string // wanted in stdout
+		code       int
+		afterA     string
+		afterB     string
+		cleanFirst bool // whether a.go is formatted beforehand
+	}{
+		{name: "check", args: []string{"--check", "a.go", "b.go"}, out: a + "\n" + b + "\n", code: 1, afterA: messy, afterB: messy},
+		{name: "check formatted", args: []string{"--check", "a.go"}, code: 0, afterA: clean, afterB: messy, cleanFirst: true},
+		{name: "check some", args: []string{"--check", "a.go", "b.go"}, out: b + "\n", code: 1, afterA: clean, afterB: messy, cleanFirst: true},
+		{name: "diff", args: []string{"--diff", "a.go"}, out: "--- a/a.go\n+++ b/a.go\n@@ -1,3 +1,3 @@\n-package a \n-func f() {\t\n+package a\n+func f() {\n }\n", afterA: messy, afterB: messy},
+		{name: "write", args: []string{"a.go", "b.go"}, out: a + ": 2 edits\n" + b + ": 2 edits\n", afterA: clean, afterB: clean},
+		{name: "range", args: []string{"--range", "2-2", "a.go"}, out: a + ": 1 edit\n", afterA: "package a \nfunc f() {\n}\n", afterB: messy},
+	}
+	for _, tt := range tests {
+		writeFile(t, a, messy)
+		writeFile(t, b, messy)
+		if tt.cleanFirst {
+			writeFile(t, a, clean)
+		}
+		out, errOut, code := runCLI(t, root, append([]string{"format"}, tt.args...)...)
+		if code != tt.code || !strings.Contains(out, tt.out) {
+			t.Errorf("%s: exit %d, stdout %q, stderr %q; want exit %d, %q", tt.name, code, out, errOut, tt.code, tt.out)
+		}
+		if got := read(a); got != tt.afterA {
+			t.Errorf("%s: a.go = %q, want %q", tt.name, got, tt.afterA)
+		}
+		if got := read(b); got != tt.afterB {
+			t.Errorf("%s: b.go = %q, want %q", tt.name, got, tt.afterB)
+		}
+	}
+}
+
+func TestParseLineRange(t *testing.T) {
+	tests := []struct {
+		s          string
+		start, end int
+		err        bool
+	}{
+		{s: "3", start: 2, end: 3},
+		{s: "2-5", start: 1, end: 5},
+		{s: "5-2", err: true},
+		{s: "0-2", err: true},
+		{s: "a-b", err: true},
+	}
+	for _, tt := range tests {
+		r, err := parseLineRange(tt.s)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: no error", tt.s)
+			}
+			continue
+		}
+		if err != nil || r.Start.Line != tt.start || r.End.Line != tt.end || r.Start.Character != 0 || r.End.Character != 0 {
+			t.Errorf("%s = %+v, %v; want lines %d-%d", tt.s, r, err, tt.start, tt.end)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCallHierarchy(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "a.go")
+	writeFile(t, path, "package a\nfunc main()\n\thelper()\n\tloop()\nfunc helper()\nfunc loop()\n\tloop()\n\thelper()\n")
+
+	tests := []struct {
+		name string
+		args []string
+		out  string // stdout, with @ for the file's path
+		err  string // wanted in stderr
+		code int
+	}{
+		{
+			name: "callees",
+			args: []string{"callees", "a.go:2:6"},
+			out:  "@:2:6 function main\n  @:5:6 function helper (called at @:3:2)\n  @:6:6 function loop (called at @:4:2)\n",
+		},
+		{
+			name: "callers",
+			args: []string{"callers", "a.go:3:2"},
+			out:  "@:5:6 function helper\n  @:2:6 function main (called at @:3:2)\n  @:6:6 function loop (called at @:8:2)\n",
+		},
+		{
+			name: "recursion",
+			args: []string{"callers", "a.go:6:6", "--depth", "3"},
+			out:  "@:6:6 function loop\n  @:2:6 function main (called at @:4:2)\n  @:6:6 function loop (called at @:7:2) (see above)\n",
+		},
+		{
+			name: "seen",
+			args: []string{"callees", "a.go:2:6", "--depth", "3"},
+			out: "@:2:6 function main\n  @:5:6 function helper (called at @:3:2)\n  @:6:6 function loop (called at @:4:2)\n" +
+				"    @:5:6 function helper (called at @:8:2) (see above)\n    @:6:6 function loop (called at @:7:2) (see above)\n",
+		},
+		{
+			name: "depth",
+			args: []string{"callees", "a.go:2:6", "--depth", "2"},
+			out: "@:2:6 function main\n  @:5:6 function helper (called at @:3:2)\n  @:6:6 function loop (called at @:4:2)\n" +
+				"    @:5:6 function helper (called at @:8:2)\n    @:6:6 function loop (called at @:7:2)\n",
+		},
+		{name: "no callers", args: []string{"callers", "a.go:2:6"}, err: "no callers found", code: 2},
+		{name: "no callees", args: []string{"callees", "a.go:5:6"}, err: "no callees found", code: 2},
+		{name: "no function", args: []string{"callers", "a.go:1:1"}, err: "no function found at position", code: 2},
+	}
+	for _, tt := range tests {
+		out, errOut, code := runCLI(t, root, tt.args...)
+		want := strings.ReplaceAll(tt.out, "@", path)
+		if code != tt.code || out != want || !strings.Contains(errOut, tt.err) {
+			t.Errorf("%s: exit %d, stdout %q, stderr %q; want exit %d, %q, %q", tt.name, code, out, errOut, tt.code, want, tt.err)
+		}
+	}
+}
+
+func TestTypeHierarchy(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "a.go")
+	writeFile(t, path, "package a\ntype Animal\ntype Pet extends Animal\ntype Dog extends Pet Animal\ntype Cat extends Pet\n")
+
+	tests := []struct {
+		name string
+		args []string
+		out  string // stdout, with @ for the file's path
+		err  string // wanted in stderr
+		code int
+	}{
+		{
+			name: "up",
+			args: []string{"a.go:4:6", "--up"},
+			out:  "@:4:6 class Dog\n  @:3:6 class Pet\n    @:2:6 class Animal\n  @:2:6 class Animal (see above)\n",
+		},
+		{
+			name: "down",
+			args: []string{"a.go:2:6", "--down"},
+			out:  "@:2:6 class Animal\n  @:3:6 class Pet\n    @:4:6 class Dog\n    @:5:6 class Cat\n  @:4:6 class Dog (see above)\n",
+		},
+		{
+			name: "depth",
+			args: []string{"a.go:2:6", "--down", "--depth", "1"},
+			out:  "@:2:6 class Animal\n  @:3:6 class Pet\n  @:4:6 class Dog\n",
+		},
+		{
+			name: "from a use",
+			args: []string{"a.go:5:19", "--up"},
+			out:  "@:3:6 class Pet\n  @:2:6 class Animal\n",
+		},
+		{name: "no supertypes", args: []string{"a.go:2:6", "--up"}, err: "no supertypes found", code: 2},
+		{name: "no subtypes", args: []string{"a.go:5:6", "--down"}, err: "no subtypes found", code: 2},
+		{name: "no type", args: []string{"a.go:1:1", "--up"}, err: "no type found at position", code: 2},
+		{name: "no direction", args: []string{"a.go:2:6"}, err: "usage: lsp-cli type-hierarchy", code: 1},
+	}
+	for _, tt := range tests {
+		out, errOut, code := runCLI(t, root, append([]string{"type-hierarchy"}, tt.args...)...)
+		want := strings.ReplaceAll(tt.out, "@", path)
+		if code != tt.code || out != want || !strings.Contains(errOut, tt.err) {
+			t.Errorf("%s: exit %d, stdout %q, stderr %q; want exit %d, %q, %q", tt.name, code, out, errOut, tt.code, want, tt.err)
+		}
+	}
+}
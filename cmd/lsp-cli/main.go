@@ -10,31 +10,54 @@
 //	references  <file:line:col>           Find all references to symbol
 //	hover       <file:line:col>           Show type/docs for symbol
 //	symbols     <file>                    List symbols in file
-//	diagnostics <file> [file...]          Show diagnostics (errors/warnings)
+//	diagnostics <file|dir|dir/...|glob>... Show diagnostics (--severity, --fail-on, --workspace)
 //	implementations <file:line:col>       Find implementations of interface
+//	type-definition <file:line:col>       Find definition of the symbol's type
+//	declaration <file:line:col>           Find declaration of symbol
 //	workspace-symbols <query>             Search symbols across workspace
+//	callers     <file:line:col> [--depth N] Show who calls a function, as a tree
+//	callees     <file:line:col> [--depth N] Show what a function calls, as a tree
+//	type-hierarchy <file:line:col> --up|--down Show supertypes or subtypes, as a tree
+//	signature   <file:line:col>           Show the signatures of the call at a position
+//	complete    <file:line:col>           List completions (--prefix, --kind, --limit)
+//	code-actions <file:line:col[-line:col]> List code actions (--apply N to apply one)
+//	format      <file> [--range L1-L2]    Format with the server (--diff, --check)
+//	rename      <file:line:col> <newName> Rename symbol (diff, or --apply)
+//	daemon start|stop|status|run          Manage the server-keeping daemon
+//	mcp-serve                             Serve the commands as MCP tools over stdio
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/c3d4r/agent-cli-tools/internal/config"
+	"github.com/c3d4r/agent-cli-tools/internal/daemon"
 	"github.com/c3d4r/agent-cli-tools/internal/lsp"
 	"github.com/c3d4r/agent-cli-tools/internal/output"
+	"github.com/c3d4r/agent-cli-tools/internal/textedit"
 )
 
 var (
-	flagJSON    bool
-	flagServer  string
-	flagRoot    string
-	flagVerbose bool
-	flagTimeout int
+	flagJSON      bool
+	flagServer    string
+	flagRoot      string
+	flagVerbose   bool
+	flagTimeout   int
+	flagSocket    string
+	flagNoDaemon  bool
+	flagServerLog string
 )
 
 func init() {
@@ -42,7 +65,10 @@ func init() {
 	flag.StringVar(&flagServer, "server", "", "language server command (overrides auto-detect)")
 	flag.StringVar(&flagRoot, "root", "", "workspace root directory (default: auto-detect from file)")
 	flag.BoolVar(&flagVerbose, "v", false, "verbose output (show server stderr)")
-	flag.IntVar(&flagTimeout, "timeout", 30, "timeout in seconds for server operations")
+	flag.IntVar(&flagTimeout, "timeout", 30, "timeout in seconds for the whole command")
+	flag.StringVar(&flagSocket, "socket", daemon.DefaultSocket(), "daemon socket path")
+	flag.BoolVar(&flagNoDaemon, "no-daemon", false, "always spawn a fresh server, even if a daemon is running")
+	flag.StringVar(&flagServerLog, "server-log", "", "append the stderr of servers lsp-cli starts to this file")
 }
 
 func main() {
@@ -57,22 +83,51 @@ func main() {
 	command := args[0]
 	cmdArgs := args[1:]
 
+	// -timeout bounds the whole command, from starting the server to the
+	// last response, so a hung server cannot hang lsp-cli.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(flagTimeout)*time.Second)
+	defer cancel()
+
 	var err error
 	switch command {
 	case "definition", "def":
-		err = cmdDefinition(cmdArgs)
+		err = cmdDefinition(ctx, cmdArgs)
 	case "references", "refs":
-		err = cmdReferences(cmdArgs)
+		err = cmdReferences(ctx, cmdArgs)
 	case "hover":
-		err = cmdHover(cmdArgs)
+		err = cmdHover(ctx, cmdArgs)
 	case "symbols", "syms":
-		err = cmdSymbols(cmdArgs)
+		err = cmdSymbols(ctx, cmdArgs)
 	case "diagnostics", "diag":
-		err = cmdDiagnostics(cmdArgs)
+		err = cmdDiagnostics(ctx, cmdArgs)
 	case "implementations", "impl":
-		err = cmdImplementations(cmdArgs)
+		err = cmdImplementations(ctx, cmdArgs)
+	case "type-definition", "typedef":
+		err = cmdTypeDefinition(ctx, cmdArgs)
+	case "declaration", "decl":
+		err = cmdDeclaration(ctx, cmdArgs)
 	case "workspace-symbols", "wsyms":
-		err = cmdWorkspaceSymbols(cmdArgs)
+		err = cmdWorkspaceSymbols(ctx, cmdArgs)
+	case "callers", "incoming-calls":
+		err = cmdCallers(ctx, cmdArgs)
+	case "callees", "outgoing-calls":
+		err = cmdCallees(ctx, cmdArgs)
+	case "type-hierarchy", "types":
+		err = cmdTypeHierarchy(ctx, cmdArgs)
+	case "signature", "sig":
+		err = cmdSignature(ctx, cmdArgs)
+	case "complete", "completion":
+		err = cmdComplete(ctx, cmdArgs)
+	case "code-actions", "code-action":
+		err = cmdCodeActions(ctx, cmdArgs)
+	case "format", "fmt":
+		err = cmdFormat(ctx, cmdArgs)
+	case "rename":
+		err = cmdRename(ctx, cmdArgs)
+	case "daemon":
+		err = cmdDaemon(cmdArgs)
+	case "mcp-serve":
+		err = cmdMCPServe(cmdArgs)
 	case "help":
 		usage()
 	default:
@@ -82,7 +137,17 @@ func main() {
 	}
 
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		// The server's stderr may follow on the next lines, so the timeout
+		// goes at the end of the first.
+		msg := err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			first, rest, found := strings.Cut(msg, "\n")
+			msg = fmt.Sprintf("%s (-timeout %ds)", first, flagTimeout)
+			if found {
+				msg += "\n" + rest
+			}
+		}
+		fmt.Fprintf(os.Stderr, "error: %s\n", msg)
 		os.Exit(1)
 	}
 }
@@ -97,9 +162,21 @@ Commands:
   references  <file:line:col>           Find all references to symbol
   hover       <file:line:col>           Show type/docs for symbol
   symbols     <file>                    List symbols in file
-  diagnostics <file> [file...]          Show diagnostics (errors/warnings)
+  diagnostics <file|dir|dir/...|glob>... Show diagnostics (--severity, --fail-on, --workspace)
   implementations <file:line:col>       Find implementations of interface
+  type-definition <file:line:col>       Find definition of the symbol's type
+  declaration <file:line:col>           Find declaration of symbol
   workspace-symbols <query>             Search symbols across workspace
+  callers     <file:line:col> [--depth N] Show who calls a function, as a tree
+  callees     <file:line:col> [--depth N] Show what a function calls, as a tree
+  type-hierarchy <file:line:col> --up|--down Show supertypes or subtypes, as a tree
+  signature   <file:line:col>           Show the signatures of the call at a position
+  complete    <file:line:col>           List completions (--prefix, --kind, --limit)
+  code-actions <file:line:col[-line:col]> List code actions (--apply N to apply one)
+  format      <file> [--range L1-L2]    Format with the server (--diff, --check)
+  rename      <file:line:col> <newName> Rename symbol (diff, or --apply)
+  daemon start|stop|status|run          Manage the server-keeping daemon
+  mcp-serve                             Serve the commands as MCP tools over stdio
 
 Flags:
 `)
@@ -111,9 +188,20 @@ Examples:
   lsp-cli definition ./server/handler.go:42:15
   lsp-cli references ./pkg/auth/token.go:28:6
   lsp-cli hover ./server/handler.go:42:15
+  lsp-cli type-definition ./server/handler.go:42:15
   lsp-cli symbols ./server/handler.go
   lsp-cli diagnostics ./server/handler.go
+  lsp-cli diagnostics ./pkg/... --workspace
+  lsp-cli diagnostics ./... --severity warning --fail-on error
   lsp-cli --json definition ./server/handler.go:42:15
+  lsp-cli callers --depth 3 ./pkg/auth/token.go:28:6
+  lsp-cli type-hierarchy --down ./pkg/store/store.go:14:6
+  lsp-cli complete ./server/handler.go:42:9 --kind method --prefix Get
+  lsp-cli code-actions ./server/handler.go:42:15-48:2 --kind refactor.extract
+  lsp-cli code-actions ./server/handler.go:42:15 --apply 1
+  lsp-cli format --check ./server/handler.go ./server/routes.go
+  lsp-cli rename ./server/handler.go:42:15 ValidateJWT --apply
+  lsp-cli daemon start    # later commands reuse warm servers
 `)
 }
 
@@ -146,6 +234,23 @@ func parseLocation(s string) (file string, line, col int, err error) {
 	return file, line, col, nil
 }
 
+// parseCmdFlags parses subcommand flags, which may appear before, between
+// or after positional arguments. Returns the positional arguments.
+func parseCmdFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
 // resolveRoot determines the workspace root directory.
 func resolveRoot(filePath string) string {
 	if flagRoot != "" {
@@ -181,18 +286,23 @@ func resolveRoot(filePath string) string {
 	return abs
 }
 
-// startClient creates an LSP client for the given file.
-func startClient(filePath string) (*lsp.Client, error) {
-	var serverCmd []string
-
+// serverCommand returns the language server command for the given file.
+func serverCommand(filePath string) ([]string, error) {
 	if flagServer != "" {
-		serverCmd = config.ParseServerFlag(flagServer)
-	} else {
-		cfg, err := config.DetectServer(filePath)
-		if err != nil {
-			return nil, err
-		}
-		serverCmd = cfg.Command
+		return config.ParseServerFlag(flagServer), nil
+	}
+	cfg, err := config.DetectServer(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Command, nil
+}
+
+// startClient creates an LSP client for the given file.
+func startClient(ctx context.Context, filePath string) (*lsp.Client, error) {
+	serverCmd, err := serverCommand(filePath)
+	if err != nil {
+		return nil, err
 	}
 
 	root := resolveRoot(filePath)
@@ -202,7 +312,19 @@ func startClient(filePath string) (*lsp.Client, error) {
 		fmt.Fprintf(os.Stderr, "root: %s\n", root)
 	}
 
-	client, err := lsp.StartClient(serverCmd, root, flagVerbose)
+	if !flagNoDaemon {
+		if client, err := attachDaemon(ctx, serverCmd, root); err == nil {
+			return client, nil
+		} else if flagVerbose {
+			fmt.Fprintf(os.Stderr, "daemon: %v (spawning server)\n", err)
+		}
+	}
+
+	serverLog, err := openServerLog()
+	if err != nil {
+		return nil, err
+	}
+	client, err := lsp.StartClient(ctx, serverCmd, root, flagVerbose, serverLog)
 	if err != nil {
 		return nil, fmt.Errorf("start LSP server: %w", err)
 	}
@@ -210,16 +332,48 @@ func startClient(filePath string) (*lsp.Client, error) {
 	return client, nil
 }
 
+// openServerLog opens the -server-log file, or returns nil if there is
+// none. It is left open until lsp-cli exits, as the server may write to it
+// until then.
+func openServerLog() (io.Writer, error) {
+	if flagServerLog == "" {
+		return nil, nil
+	}
+	f, err := os.OpenFile(flagServerLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open server log: %w", err)
+	}
+	return f, nil
+}
+
+// attachDaemon connects to a running daemon's warm server for serverCmd and root.
+func attachDaemon(ctx context.Context, serverCmd []string, root string) (*lsp.Client, error) {
+	conn, err := daemon.Dial(ctx, flagSocket, serverCmd, root)
+	if err != nil {
+		return nil, err
+	}
+	client, err := lsp.NewClient(ctx, conn, root, flagVerbose)
+	if err != nil {
+		return nil, err
+	}
+	if flagVerbose {
+		fmt.Fprintf(os.Stderr, "daemon: attached via %s\n", flagSocket)
+	}
+	return client, nil
+}
+
 // openAndWait opens a file and waits for the server to be ready.
-func openAndWait(client *lsp.Client, file string) (string, error) {
-	uri, err := client.OpenFile(file)
+func openAndWait(ctx context.Context, client *lsp.Client, file string) (string, error) {
+	uri, err := client.OpenFile(ctx, file)
 	if err != nil {
 		return "", err
 	}
 
-	// Wait for server to finish loading (progress end), with timeout fallback.
-	timeout := time.Duration(flagTimeout) * time.Second
-	if !client.WaitReady(timeout) {
+	// Wait for server to finish loading (progress end), but go ahead
+	// without it in time to still make the request.
+	waitCtx, cancel := softContext(ctx)
+	defer cancel()
+	if !client.WaitReady(waitCtx) {
 		if flagVerbose {
 			fmt.Fprintln(os.Stderr, "warning: timed out waiting for server ready")
 		}
@@ -228,6 +382,18 @@ func openAndWait(client *lsp.Client, file string) (string, error) {
 	return uri, nil
 }
 
+// softContext returns a context for waits that lsp-cli can give up on,
+// such as for the server to finish loading. It ends when three quarters of
+// the time left on ctx have passed, keeping the rest for the requests that
+// follow.
+func softContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)*3/4)
+}
+
 func formatter() *output.Formatter {
 	return &output.Formatter{
 		Writer: os.Stdout,
@@ -235,7 +401,7 @@ func formatter() *output.Formatter {
 	}
 }
 
-func cmdDefinition(args []string) error {
+func cmdDefinition(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return fmt.Errorf("usage: lsp-cli definition <file:line:col>")
 	}
@@ -245,18 +411,18 @@ func cmdDefinition(args []string) error {
 		return err
 	}
 
-	client, err := startClient(file)
+	client, err := startClient(ctx, file)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 
-	uri, err := openAndWait(client, file)
+	uri, err := openAndWait(ctx, client, file)
 	if err != nil {
 		return err
 	}
 
-	locs, err := client.Definition(uri, line, col)
+	locs, err := client.Definition(ctx, uri, line, col)
 	if err != nil {
 		return fmt.Errorf("definition: %w", err)
 	}
@@ -269,7 +435,75 @@ func cmdDefinition(args []string) error {
 	return formatter().Locations(locs)
 }
 
-func cmdReferences(args []string) error {
+func cmdTypeDefinition(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: lsp-cli type-definition <file:line:col>")
+	}
+
+	file, line, col, err := parseLocation(args[0])
+	if err != nil {
+		return err
+	}
+
+	client, err := startClient(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	uri, err := openAndWait(ctx, client, file)
+	if err != nil {
+		return err
+	}
+
+	locs, err := client.TypeDefinition(ctx, uri, line, col)
+	if err != nil {
+		return fmt.Errorf("type definition: %w", err)
+	}
+
+	if len(locs) == 0 {
+		fmt.Fprintln(os.Stderr, "no type definition found")
+		os.Exit(2)
+	}
+
+	return formatter().Locations(locs)
+}
+
+func cmdDeclaration(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: lsp-cli declaration <file:line:col>")
+	}
+
+	file, line, col, err := parseLocation(args[0])
+	if err != nil {
+		return err
+	}
+
+	client, err := startClient(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	uri, err := openAndWait(ctx, client, file)
+	if err != nil {
+		return err
+	}
+
+	locs, err := client.Declaration(ctx, uri, line, col)
+	if err != nil {
+		return fmt.Errorf("declaration: %w", err)
+	}
+
+	if len(locs) == 0 {
+		fmt.Fprintln(os.Stderr, "no declaration found")
+		os.Exit(2)
+	}
+
+	return formatter().Locations(locs)
+}
+
+func cmdReferences(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return fmt.Errorf("usage: lsp-cli references <file:line:col>")
 	}
@@ -279,18 +513,18 @@ func cmdReferences(args []string) error {
 		return err
 	}
 
-	client, err := startClient(file)
+	client, err := startClient(ctx, file)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 
-	uri, err := openAndWait(client, file)
+	uri, err := openAndWait(ctx, client, file)
 	if err != nil {
 		return err
 	}
 
-	locs, err := client.References(uri, line, col, true)
+	locs, err := client.References(ctx, uri, line, col, true)
 	if err != nil {
 		return fmt.Errorf("references: %w", err)
 	}
@@ -303,7 +537,7 @@ func cmdReferences(args []string) error {
 	return formatter().Locations(locs)
 }
 
-func cmdHover(args []string) error {
+func cmdHover(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return fmt.Errorf("usage: lsp-cli hover <file:line:col>")
 	}
@@ -313,18 +547,18 @@ func cmdHover(args []string) error {
 		return err
 	}
 
-	client, err := startClient(file)
+	client, err := startClient(ctx, file)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 
-	uri, err := openAndWait(client, file)
+	uri, err := openAndWait(ctx, client, file)
 	if err != nil {
 		return err
 	}
 
-	hover, err := client.Hover(uri, line, col)
+	hover, err := client.Hover(ctx, uri, line, col)
 	if err != nil {
 		return fmt.Errorf("hover: %w", err)
 	}
@@ -337,25 +571,25 @@ func cmdHover(args []string) error {
 	return formatter().Hover(hover)
 }
 
-func cmdSymbols(args []string) error {
+func cmdSymbols(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return fmt.Errorf("usage: lsp-cli symbols <file>")
 	}
 
 	file := args[0]
 
-	client, err := startClient(file)
+	client, err := startClient(ctx, file)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 
-	uri, err := openAndWait(client, file)
+	uri, err := openAndWait(ctx, client, file)
 	if err != nil {
 		return err
 	}
 
-	docSyms, symInfos, err := client.DocumentSymbols(uri)
+	docSyms, symInfos, err := client.DocumentSymbols(ctx, uri)
 	if err != nil {
 		return fmt.Errorf("symbols: %w", err)
 	}
@@ -367,55 +601,119 @@ func cmdSymbols(args []string) error {
 	return f.SymbolInformations(symInfos)
 }
 
-func cmdDiagnostics(args []string) error {
-	if len(args) == 0 {
-		return fmt.Errorf("usage: lsp-cli diagnostics <file> [file...]")
+func cmdRename(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rename", flag.ContinueOnError)
+	apply := fs.Bool("apply", false, "write the edits to disk instead of printing a diff")
+	args, err := parseCmdFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: lsp-cli rename <file:line:col> <newName> [--apply]")
+	}
+
+	file, line, col, err := parseLocation(args[0])
+	if err != nil {
+		return err
 	}
+	newName := args[1]
 
-	client, err := startClient(args[0])
+	client, err := startClient(ctx, file)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 
-	// Open all files
-	uris := make([]string, len(args))
-	for i, file := range args {
-		uri, err := client.OpenFile(file)
+	uri, err := openAndWait(ctx, client, file)
+	if err != nil {
+		return err
+	}
+
+	changes, err := renameChanges(ctx, client, uri, line, col, newName)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(os.Stderr, "nothing to rename")
+		os.Exit(2)
+	}
+
+	f := formatter()
+	if !*apply {
+		return f.FileChanges(changes)
+	}
+	if err := textedit.Write(changes); err != nil {
+		return fmt.Errorf("apply rename: %w", err)
+	}
+	return f.AppliedChanges(changes)
+}
+
+// renameChanges returns the changes renaming the symbol at a position
+// would make, none if there is nothing to rename.
+func renameChanges(ctx context.Context, client *lsp.Client, uri string, line, col int, newName string) ([]textedit.FileChange, error) {
+	if client.Capabilities().SupportsPrepareRename() {
+		prep, err := client.PrepareRename(ctx, uri, line, col)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("prepare rename: %w", err)
+		}
+		if prep == nil {
+			return nil, fmt.Errorf("cannot rename the symbol at %s:%d:%d", lsp.URIToPath(uri), line+1, col+1)
 		}
-		uris[i] = uri
 	}
 
-	// Wait for server to be ready, then give extra time for diagnostics
-	timeout := time.Duration(flagTimeout) * time.Second
-	client.WaitReady(timeout)
+	edit, err := client.Rename(ctx, uri, line, col, newName)
+	if err != nil {
+		return nil, fmt.Errorf("rename: %w", err)
+	}
+	if edit == nil {
+		return nil, nil
+	}
+	fileEdits, err := edit.FileEdits()
+	if err != nil {
+		return nil, fmt.Errorf("rename: %w", err)
+	}
+	changes, err := textedit.Compute(fileEdits)
+	if err != nil {
+		return nil, fmt.Errorf("rename: %w", err)
+	}
+	return changes, nil
+}
 
-	// Give a bit more time for diagnostics to arrive after loading
-	time.Sleep(500 * time.Millisecond)
+func cmdSignature(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: lsp-cli signature <file:line:col>")
+	}
 
-	f := formatter()
-	allDiags := client.AllDiagnostics()
+	file, line, col, err := parseLocation(args[0])
+	if err != nil {
+		return err
+	}
 
-	hasOutput := false
-	for _, uri := range uris {
-		if diags, ok := allDiags[uri]; ok && len(diags) > 0 {
-			f.Diagnostics(uri, diags)
-			hasOutput = true
-		}
+	client, err := startClient(ctx, file)
+	if err != nil {
+		return err
 	}
+	defer client.Close()
 
-	if !hasOutput {
-		if flagJSON {
-			fmt.Fprintln(os.Stdout, "[]")
-		}
+	uri, err := openAndWait(ctx, client, file)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	help, err := client.SignatureHelp(ctx, uri, line, col)
+	if err != nil {
+		return fmt.Errorf("signature help: %w", err)
+	}
+
+	if help == nil || len(help.Signatures) == 0 {
+		fmt.Fprintln(os.Stderr, "no signature found")
+		os.Exit(2)
+	}
+
+	return formatter().Signatures(help)
 }
 
-func cmdImplementations(args []string) error {
+func cmdImplementations(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return fmt.Errorf("usage: lsp-cli implementations <file:line:col>")
 	}
@@ -425,18 +723,18 @@ func cmdImplementations(args []string) error {
 		return err
 	}
 
-	client, err := startClient(file)
+	client, err := startClient(ctx, file)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 
-	uri, err := openAndWait(client, file)
+	uri, err := openAndWait(ctx, client, file)
 	if err != nil {
 		return err
 	}
 
-	locs, err := client.Implementations(uri, line, col)
+	locs, err := client.Implementations(ctx, uri, line, col)
 	if err != nil {
 		return fmt.Errorf("implementations: %w", err)
 	}
@@ -449,7 +747,263 @@ func cmdImplementations(args []string) error {
 	return formatter().Locations(locs)
 }
 
-func cmdWorkspaceSymbols(args []string) error {
+func cmdCallers(ctx context.Context, args []string) error {
+	return callHierarchy(ctx, "callers", args, true)
+}
+
+func cmdCallees(ctx context.Context, args []string) error {
+	return callHierarchy(ctx, "callees", args, false)
+}
+
+// callHierarchy prints the callers (incoming) or callees of the function
+// at a position, following calls to --depth levels.
+func callHierarchy(ctx context.Context, name string, args []string, incoming bool) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	depth := fs.Int("depth", 1, "levels of calls to follow")
+	args, err := parseCmdFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *depth < 1 {
+		return fmt.Errorf("usage: lsp-cli %s <file:line:col> [--depth N]", name)
+	}
+
+	file, line, col, err := parseLocation(args[0])
+	if err != nil {
+		return err
+	}
+
+	client, err := startClient(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	uri, err := openAndWait(ctx, client, file)
+	if err != nil {
+		return err
+	}
+
+	roots, err := callTree(ctx, client, uri, line, col, incoming, *depth)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if len(roots) == 0 {
+		fmt.Fprintln(os.Stderr, "no function found at position")
+		os.Exit(2)
+	}
+	if !hasChildren(roots) {
+		fmt.Fprintf(os.Stderr, "no %s found\n", name)
+		os.Exit(2)
+	}
+	return formatter().Hierarchy(roots)
+}
+
+// callTree returns the callers (incoming) or callees of the function at a
+// position to depth levels, a tree for each item the position resolves to.
+// There are none if the position is not on a function.
+func callTree(ctx context.Context, client *lsp.Client, uri string, line, col int, incoming bool, depth int) ([]output.HierarchyNode, error) {
+	items, err := client.PrepareCallHierarchy(ctx, uri, line, col)
+	if err != nil {
+		return nil, err
+	}
+	w := &callWalker{client: client, incoming: incoming, seen: make(map[string]bool)}
+	roots := make([]output.HierarchyNode, len(items))
+	for i, item := range items {
+		if roots[i], err = w.walk(ctx, item, depth); err != nil {
+			return nil, err
+		}
+	}
+	return roots, nil
+}
+
+// hasChildren reports whether any of the trees goes past its root.
+func hasChildren(roots []output.HierarchyNode) bool {
+	for _, n := range roots {
+		if len(n.Children) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// callWalker expands a call hierarchy depth-first. Each item is expanded
+// once; later occurrences, including recursive calls, are marked Seen.
+type callWalker struct {
+	client   *lsp.Client
+	incoming bool
+	seen     map[string]bool
+}
+
+func (w *callWalker) walk(ctx context.Context, item lsp.CallHierarchyItem, depth int) (output.HierarchyNode, error) {
+	node := output.HierarchyNode{
+		Name:           item.Name,
+		Kind:           item.Kind,
+		Detail:         item.Detail,
+		URI:            item.URI,
+		Range:          item.Range,
+		SelectionRange: item.SelectionRange,
+	}
+	if depth == 0 {
+		return node, nil
+	}
+	key := fmt.Sprintf("%s:%d:%d", item.URI, item.SelectionRange.Start.Line, item.SelectionRange.Start.Character)
+	if w.seen[key] {
+		node.Seen = true
+		return node, nil
+	}
+	w.seen[key] = true
+
+	if w.incoming {
+		calls, err := w.client.IncomingCalls(ctx, item)
+		if err != nil {
+			return node, err
+		}
+		for _, call := range calls {
+			child, err := w.walk(ctx, call.From, depth-1)
+			if err != nil {
+				return node, err
+			}
+			child.FromURI, child.FromRanges = call.From.URI, call.FromRanges
+			node.Children = append(node.Children, child)
+		}
+		return node, nil
+	}
+
+	calls, err := w.client.OutgoingCalls(ctx, item)
+	if err != nil {
+		return node, err
+	}
+	for _, call := range calls {
+		child, err := w.walk(ctx, call.To, depth-1)
+		if err != nil {
+			return node, err
+		}
+		child.FromURI, child.FromRanges = item.URI, call.FromRanges
+		node.Children = append(node.Children, child)
+	}
+	return node, nil
+}
+
+// cmdTypeHierarchy prints the supertypes (--up) or subtypes (--down) of
+// the type at a position as a tree.
+func cmdTypeHierarchy(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("type-hierarchy", flag.ContinueOnError)
+	up := fs.Bool("up", false, "show supertypes")
+	down := fs.Bool("down", false, "show subtypes")
+	depth := fs.Int("depth", 0, "levels to follow (0 for all)")
+	args, err := parseCmdFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 || *up == *down || *depth < 0 {
+		return fmt.Errorf("usage: lsp-cli type-hierarchy <file:line:col> --up|--down [--depth N]")
+	}
+
+	file, line, col, err := parseLocation(args[0])
+	if err != nil {
+		return err
+	}
+
+	client, err := startClient(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	uri, err := openAndWait(ctx, client, file)
+	if err != nil {
+		return err
+	}
+
+	roots, err := typeTree(ctx, client, uri, line, col, *up, *depth)
+	if err != nil {
+		return fmt.Errorf("type hierarchy: %w", err)
+	}
+	if len(roots) == 0 {
+		fmt.Fprintln(os.Stderr, "no type found at position")
+		os.Exit(2)
+	}
+	if !hasChildren(roots) {
+		if *up {
+			fmt.Fprintln(os.Stderr, "no supertypes found")
+		} else {
+			fmt.Fprintln(os.Stderr, "no subtypes found")
+		}
+		os.Exit(2)
+	}
+	return formatter().Hierarchy(roots)
+}
+
+// typeTree returns the supertypes (up) or subtypes of the type at a
+// position to depth levels, or to the ends of the hierarchy if depth is 0,
+// like callTree.
+func typeTree(ctx context.Context, client *lsp.Client, uri string, line, col int, up bool, depth int) ([]output.HierarchyNode, error) {
+	items, err := client.PrepareTypeHierarchy(ctx, uri, line, col)
+	if err != nil {
+		return nil, err
+	}
+	if depth == 0 {
+		depth = -1
+	}
+	w := &typeWalker{client: client, up: up, seen: make(map[string]bool)}
+	roots := make([]output.HierarchyNode, len(items))
+	for i, item := range items {
+		if roots[i], err = w.walk(ctx, item, depth); err != nil {
+			return nil, err
+		}
+	}
+	return roots, nil
+}
+
+// typeWalker expands a type hierarchy depth-first, like callWalker. A
+// negative depth follows the hierarchy to its ends.
+type typeWalker struct {
+	client *lsp.Client
+	up     bool
+	seen   map[string]bool
+}
+
+func (w *typeWalker) walk(ctx context.Context, item lsp.TypeHierarchyItem, depth int) (output.HierarchyNode, error) {
+	node := output.HierarchyNode{
+		Name:           item.Name,
+		Kind:           item.Kind,
+		Detail:         item.Detail,
+		URI:            item.URI,
+		Range:          item.Range,
+		SelectionRange: item.SelectionRange,
+	}
+	if depth == 0 {
+		return node, nil
+	}
+	key := fmt.Sprintf("%s:%d:%d", item.URI, item.SelectionRange.Start.Line, item.SelectionRange.Start.Character)
+	if w.seen[key] {
+		node.Seen = true
+		return node, nil
+	}
+	w.seen[key] = true
+
+	var related []lsp.TypeHierarchyItem
+	var err error
+	if w.up {
+		related, err = w.client.Supertypes(ctx, item)
+	} else {
+		related, err = w.client.Subtypes(ctx, item)
+	}
+	if err != nil {
+		return node, err
+	}
+	for _, r := range related {
+		child, err := w.walk(ctx, r, depth-1)
+		if err != nil {
+			return node, err
+		}
+		node.Children = append(node.Children, child)
+	}
+	return node, nil
+}
+
+func cmdWorkspaceSymbols(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return fmt.Errorf("usage: lsp-cli workspace-symbols <query>")
 	}
@@ -467,18 +1021,18 @@ func cmdWorkspaceSymbols(args []string) error {
 		return fmt.Errorf("cannot find source file for server detection: %w", err)
 	}
 
-	client, err := startClient(refFile)
+	client, err := startClient(ctx, refFile)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 
-	_, err = openAndWait(client, refFile)
+	_, err = openAndWait(ctx, client, refFile)
 	if err != nil {
 		return err
 	}
 
-	syms, err := client.WorkspaceSymbols(query)
+	syms, err := client.WorkspaceSymbols(ctx, query)
 	if err != nil {
 		return fmt.Errorf("workspace symbols: %w", err)
 	}
@@ -518,3 +1072,127 @@ func findAnySourceFile(dir string) (string, error) {
 	}
 	return found, nil
 }
+
+func cmdDaemon(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: lsp-cli daemon start|stop|status|run")
+	}
+
+	switch args[0] {
+	case "start":
+		return daemonStart()
+	case "stop":
+		if err := daemon.Stop(flagSocket); err != nil {
+			return fmt.Errorf("daemon not running on %s", flagSocket)
+		}
+		fmt.Fprintln(os.Stderr, "daemon stopped")
+		return nil
+	case "status":
+		return daemonStatus()
+	case "run":
+		// Foreground mode; "start" re-executes this in the background.
+		serverLog, err := openServerLog()
+		if err != nil {
+			return err
+		}
+		return daemon.Serve(flagSocket, flagVerbose, serverLog)
+	default:
+		return fmt.Errorf("unknown daemon command %q (want start, stop, status or run)", args[0])
+	}
+}
+
+// daemonStart launches "lsp-cli daemon run" detached from the terminal,
+// logging to <socket>.log, and waits until it accepts connections.
+func daemonStart() error {
+	if daemon.Running(flagSocket) {
+		fmt.Fprintf(os.Stderr, "daemon already running on %s\n", flagSocket)
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locate executable: %w", err)
+	}
+
+	logPath := flagSocket + ".log"
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("open daemon log: %w", err)
+	}
+	defer logFile.Close()
+
+	daemonArgs := []string{"-socket", flagSocket}
+	if flagVerbose {
+		daemonArgs = append(daemonArgs, "-v")
+	}
+	if flagServerLog != "" {
+		daemonArgs = append(daemonArgs, "-server-log", flagServerLog)
+	}
+	daemonArgs = append(daemonArgs, "daemon", "run")
+
+	cmd := exec.Command(exe, daemonArgs...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start daemon: %w", err)
+	}
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if daemon.Running(flagSocket) {
+			fmt.Fprintf(os.Stderr, "daemon started (pid %d, socket %s)\n", pid, flagSocket)
+			return nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Errorf("daemon did not start within 5s (see %s)", logPath)
+}
+
+func daemonStatus() error {
+	st, err := daemon.GetStatus(flagSocket)
+	if err != nil {
+		if flagJSON {
+			fmt.Fprintln(os.Stdout, "null")
+		} else {
+			fmt.Fprintf(os.Stdout, "not running (%s)\n", flagSocket)
+		}
+		os.Exit(2)
+	}
+
+	if flagJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+
+	fmt.Fprintf(os.Stdout, "running (pid %d, socket %s, up %s, %d sessions)\n",
+		st.PID, st.Socket, time.Since(st.Started).Round(time.Second), st.Sessions)
+	for _, srv := range st.Servers {
+		state := "loading"
+		switch {
+		case srv.Error != "":
+			state = "crashed"
+		case srv.Ready:
+			state = "ready"
+		}
+		switch srv.Restarts {
+		case 0:
+		case 1:
+			state += ", restarted once"
+		default:
+			state += fmt.Sprintf(", restarted %d times", srv.Restarts)
+		}
+		if n := srv.Notifications.Queued; n > 0 {
+			state += fmt.Sprintf(", %d notifications queued", n)
+		}
+		if n := srv.Notifications.Coalesced; n > 0 {
+			state += fmt.Sprintf(", %d stale diagnostics replaced", n)
+		}
+		fmt.Fprintf(os.Stdout, "  %s %s (%s, up %s)\n",
+			strings.Join(srv.Command, " "), srv.Root, state, time.Since(srv.Started).Round(time.Second))
+	}
+	return nil
+}
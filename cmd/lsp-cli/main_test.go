@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// cliEnv makes the test binary run as lsp-cli, with its arguments, and
+// with the fake language server for the servers it starts.
+const cliEnv = "LSP_CLI_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(cliEnv) != "" {
+		os.Unsetenv(cliEnv)
+		os.Setenv(fakeServerEnv, "1")
+		main()
+		os.Exit(0)
+	}
+	if os.Getenv(fakeServerEnv) != "" {
+		runFakeServer()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCLI runs lsp-cli in root, with the fake server and root as the
+// workspace. It does not use a daemon unless args give a -socket.
+func runCLI(t *testing.T, root string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	flags := []string{
+		"-server", os.Args[0],
+		"-root", root,
+		"-timeout", "10",
+		"-socket", filepath.Join(t.TempDir(), "none.sock"),
+	}
+	cmd := exec.Command(os.Args[0], append(flags, args...)...)
+	cmd.Dir = root
+	cmd.Env = append(os.Environ(), cliEnv+"=1")
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err := cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit):
+		code = exit.ExitCode()
+	case err != nil:
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), code
+}
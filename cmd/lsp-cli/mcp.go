@@ -0,0 +1,731 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+	"github.com/c3d4r/agent-cli-tools/internal/mcp"
+	"github.com/c3d4r/agent-cli-tools/internal/output"
+	"github.com/c3d4r/agent-cli-tools/internal/textedit"
+)
+
+// mcpVersion is reported to MCP clients as the server version.
+const mcpVersion = "0.1.0"
+
+// positionArgs are the arguments of the position-based tools. Line and
+// column are 1-indexed, as on the command line.
+type positionArgs struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+var positionSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"file":   map[string]string{"type": "string", "description": "Path to the source file"},
+		"line":   map[string]interface{}{"type": "integer", "minimum": 1, "description": "Line number (1-indexed)"},
+		"column": map[string]interface{}{"type": "integer", "minimum": 1, "description": "Column number (1-indexed)"},
+	},
+	"required": []string{"file", "line", "column"},
+}
+
+// positionSchemaWith returns the schema of a position-based tool with
+// more arguments, those in required required too.
+func positionSchemaWith(props map[string]interface{}, required ...string) map[string]interface{} {
+	all := make(map[string]interface{})
+	for name, prop := range positionSchema["properties"].(map[string]interface{}) {
+		all[name] = prop
+	}
+	for name, prop := range props {
+		all[name] = prop
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": all,
+		"required":   append([]string{"file", "line", "column"}, required...),
+	}
+}
+
+// mcpServer holds the warm language servers for one MCP session.
+type mcpServer struct {
+	pool *lsp.Pool
+}
+
+func cmdMCPServe(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: lsp-cli mcp-serve")
+	}
+	return serveMCP(os.Stdin, os.Stdout)
+}
+
+// serveMCP serves the MCP tools over r and w until r is exhausted.
+func serveMCP(r io.Reader, w io.Writer) error {
+	serverLog, err := openServerLog()
+	if err != nil {
+		return err
+	}
+	m := &mcpServer{pool: lsp.NewPool(flagVerbose)}
+	m.pool.ServerLog = serverLog
+	defer m.pool.Close()
+
+	s := mcp.NewServer("lsp-cli", mcpVersion)
+	s.AddTool(mcp.Tool{
+		Name:        "definition",
+		Description: "Find the definition of the symbol at a position. Returns file:line:col locations.",
+		InputSchema: positionSchema,
+	}, m.tool(m.definition))
+	s.AddTool(mcp.Tool{
+		Name:        "references",
+		Description: "Find all references to the symbol at a position, including its declaration.",
+		InputSchema: positionSchema,
+	}, m.tool(m.references))
+	s.AddTool(mcp.Tool{
+		Name:        "hover",
+		Description: "Show the type signature and documentation of the symbol at a position.",
+		InputSchema: positionSchema,
+	}, m.tool(m.hover))
+	s.AddTool(mcp.Tool{
+		Name:        "symbols",
+		Description: "List the symbols (functions, types, fields, methods) defined in a file.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"file": map[string]string{"type": "string", "description": "Path to the source file"},
+			},
+			"required": []string{"file"},
+		},
+	}, m.tool(m.symbols))
+	s.AddTool(mcp.Tool{
+		Name:        "diagnostics",
+		Description: "Report errors and warnings for files, directories (dir/... for a whole tree) or globs without building, or for the whole workspace.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"files": map[string]interface{}{
+					"type":        "array",
+					"items":       map[string]string{"type": "string"},
+					"description": "Paths to the source files, directories or glob patterns",
+				},
+				"workspace": map[string]interface{}{"type": "boolean", "description": "Also report every other file the server has diagnostics for"},
+			},
+		},
+	}, m.tool(m.diagnostics))
+	s.AddTool(mcp.Tool{
+		Name:        "implementations",
+		Description: "Find the implementations of the interface or abstract symbol at a position.",
+		InputSchema: positionSchema,
+	}, m.tool(m.implementations))
+	s.AddTool(mcp.Tool{
+		Name:        "workspace-symbols",
+		Description: "Search symbols by name across the workspace.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]string{"type": "string", "description": "Symbol name or fragment"},
+				"root":  map[string]string{"type": "string", "description": "Workspace root (default: -root flag or current directory)"},
+			},
+			"required": []string{"query"},
+		},
+	}, m.tool(m.workspaceSymbols))
+	s.AddTool(mcp.Tool{
+		Name:        "type-definition",
+		Description: "Find the definition of the type of the symbol at a position, such as a variable's type.",
+		InputSchema: positionSchema,
+	}, m.tool(m.typeDefinition))
+	s.AddTool(mcp.Tool{
+		Name:        "declaration",
+		Description: "Find the declaration of the symbol at a position, such as a C or C++ header declaration.",
+		InputSchema: positionSchema,
+	}, m.tool(m.declaration))
+	depthSchema := map[string]interface{}{"type": "integer", "minimum": 1, "description": "Levels of calls to follow (default 1)"}
+	s.AddTool(mcp.Tool{
+		Name:        "callers",
+		Description: "Show the functions calling the function at a position, as a tree with call sites.",
+		InputSchema: positionSchemaWith(map[string]interface{}{"depth": depthSchema}),
+	}, m.tool(m.callHierarchy(true)))
+	s.AddTool(mcp.Tool{
+		Name:        "callees",
+		Description: "Show the functions the function at a position calls, as a tree with call sites.",
+		InputSchema: positionSchemaWith(map[string]interface{}{"depth": depthSchema}),
+	}, m.tool(m.callHierarchy(false)))
+	s.AddTool(mcp.Tool{
+		Name:        "type-hierarchy",
+		Description: "Show the supertypes or subtypes of the type at a position, as a tree.",
+		InputSchema: positionSchemaWith(map[string]interface{}{
+			"direction": map[string]interface{}{"type": "string", "enum": []string{"up", "down"}, "description": "up for supertypes, down for subtypes"},
+			"depth":     map[string]interface{}{"type": "integer", "minimum": 0, "description": "Levels to follow (default 0, for all)"},
+		}, "direction"),
+	}, m.tool(m.typeHierarchy))
+	s.AddTool(mcp.Tool{
+		Name:        "signature",
+		Description: "Show the signatures and parameters of the call at a position.",
+		InputSchema: positionSchema,
+	}, m.tool(m.signature))
+	s.AddTool(mcp.Tool{
+		Name:        "complete",
+		Description: "List the completions at a position, with their documentation, to discover members and identifiers.",
+		InputSchema: positionSchemaWith(map[string]interface{}{
+			"prefix": map[string]string{"type": "string", "description": "Only completions starting with this text (case-insensitive)"},
+			"kind":   map[string]string{"type": "string", "description": "Only completions of these kinds, comma-separated (method,field,...)"},
+			"limit":  map[string]interface{}{"type": "integer", "minimum": 0, "description": "Maximum number of completions (default 50, 0 for all)"},
+		}),
+	}, m.tool(m.complete))
+	s.AddTool(mcp.Tool{
+		Name:        "code-actions",
+		Description: "List the code actions (quick fixes, refactorings) for a position or range, numbered from 1, or apply one.",
+		InputSchema: positionSchemaWith(map[string]interface{}{
+			"endLine":   map[string]interface{}{"type": "integer", "minimum": 1, "description": "Line of the end of the range (1-indexed)"},
+			"endColumn": map[string]interface{}{"type": "integer", "minimum": 1, "description": "Column of the end of the range (1-indexed)"},
+			"kind":      map[string]string{"type": "string", "description": "Only actions of this kind (quickfix, refactor, source.organizeImports, ...)"},
+			"apply":     map[string]interface{}{"type": "integer", "minimum": 1, "description": "Apply the code action with this number, as listed"},
+			"dryRun":    map[string]interface{}{"type": "boolean", "description": "With apply, return the diff instead of writing it"},
+		}),
+	}, m.tool(m.codeActions))
+	s.AddTool(mcp.Tool{
+		Name:        "format",
+		Description: "Format files with the language server, writing them, or return the diff.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"files": map[string]interface{}{
+					"type":        "array",
+					"items":       map[string]string{"type": "string"},
+					"minItems":    1,
+					"description": "Paths to the source files",
+				},
+				"range": map[string]string{"type": "string", "description": "Format only lines L1-L2 (1-indexed, inclusive) of a single file"},
+				"diff":  map[string]interface{}{"type": "boolean", "description": "Return the changes as a diff instead of writing them"},
+			},
+			"required": []string{"files"},
+		},
+	}, m.tool(m.format))
+	s.AddTool(mcp.Tool{
+		Name:        "rename",
+		Description: "Rename the symbol at a position across the workspace. Returns a diff unless apply is set.",
+		InputSchema: positionSchemaWith(map[string]interface{}{
+			"newName": map[string]string{"type": "string", "description": "The new name"},
+			"apply":   map[string]interface{}{"type": "boolean", "description": "Write the edits instead of returning a diff"},
+		}, "newName"),
+	}, m.tool(m.rename))
+
+	return s.Serve(r, w)
+}
+
+// tool adapts a tool implementation to an MCP handler. Each call is
+// bounded by -timeout, as a command is.
+func (m *mcpServer) tool(fn func(ctx context.Context, args json.RawMessage) (string, error)) mcp.ToolHandler {
+	return func(args json.RawMessage) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(flagTimeout)*time.Second)
+		defer cancel()
+		return fn(ctx, args)
+	}
+}
+
+// client returns the warm client for file's server and workspace, with
+// file opened and every open document refreshed from disk, since results
+// can come from other files too. Returns the file's URI.
+func (m *mcpServer) client(ctx context.Context, file string) (*lsp.Client, string, error) {
+	serverCmd, err := serverCommand(file)
+	if err != nil {
+		return nil, "", err
+	}
+	client, err := m.pool.Get(ctx, serverCmd, resolveRoot(file))
+	if err != nil {
+		return nil, "", fmt.Errorf("start LSP server: %w", err)
+	}
+
+	if err := client.SyncOpenDocuments(ctx); err != nil {
+		return nil, "", err
+	}
+	uri, err := client.OpenFile(ctx, file)
+	if err != nil {
+		return nil, "", err
+	}
+	waitCtx, cancel := softContext(ctx)
+	defer cancel()
+	client.WaitReady(waitCtx)
+	return client, uri, nil
+}
+
+// position decodes position arguments and converts them to 0-indexed.
+func (m *mcpServer) position(ctx context.Context, args json.RawMessage) (*lsp.Client, string, int, int, error) {
+	var p positionArgs
+	if err := json.Unmarshal(args, &p); err != nil {
+		return nil, "", 0, 0, fmt.Errorf("invalid arguments: %w", err)
+	}
+	if p.File == "" || p.Line < 1 || p.Column < 1 {
+		return nil, "", 0, 0, fmt.Errorf("file, line and column (1-indexed) are required")
+	}
+
+	client, uri, err := m.client(ctx, p.File)
+	if err != nil {
+		return nil, "", 0, 0, err
+	}
+	return client, uri, p.Line - 1, p.Column - 1, nil
+}
+
+// render runs fn against a formatter writing to a buffer and returns the text.
+func render(fn func(f *output.Formatter) error) (string, error) {
+	var buf bytes.Buffer
+	f := &output.Formatter{Writer: &buf, JSON: flagJSON}
+	if err := fn(f); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (m *mcpServer) definition(ctx context.Context, args json.RawMessage) (string, error) {
+	client, uri, line, col, err := m.position(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	locs, err := client.Definition(ctx, uri, line, col)
+	if err != nil {
+		return "", fmt.Errorf("definition: %w", err)
+	}
+	if len(locs) == 0 {
+		return "no definition found", nil
+	}
+	return render(func(f *output.Formatter) error { return f.Locations(locs) })
+}
+
+func (m *mcpServer) references(ctx context.Context, args json.RawMessage) (string, error) {
+	client, uri, line, col, err := m.position(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	locs, err := client.References(ctx, uri, line, col, true)
+	if err != nil {
+		return "", fmt.Errorf("references: %w", err)
+	}
+	if len(locs) == 0 {
+		return "no references found", nil
+	}
+	return render(func(f *output.Formatter) error { return f.Locations(locs) })
+}
+
+func (m *mcpServer) hover(ctx context.Context, args json.RawMessage) (string, error) {
+	client, uri, line, col, err := m.position(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	hover, err := client.Hover(ctx, uri, line, col)
+	if err != nil {
+		return "", fmt.Errorf("hover: %w", err)
+	}
+	if hover == nil {
+		return "no hover information", nil
+	}
+	return render(func(f *output.Formatter) error { return f.Hover(hover) })
+}
+
+func (m *mcpServer) symbols(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		File string `json:"file"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil || p.File == "" {
+		return "", fmt.Errorf("file is required")
+	}
+
+	client, uri, err := m.client(ctx, p.File)
+	if err != nil {
+		return "", err
+	}
+	docSyms, symInfos, err := client.DocumentSymbols(ctx, uri)
+	if err != nil {
+		return "", fmt.Errorf("symbols: %w", err)
+	}
+	return render(func(f *output.Formatter) error {
+		if docSyms != nil {
+			return f.DocumentSymbols(docSyms)
+		}
+		return f.SymbolInformations(symInfos)
+	})
+}
+
+func (m *mcpServer) diagnostics(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		Files     []string `json:"files"`
+		Workspace bool     `json:"workspace"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	if len(p.Files) == 0 {
+		if !p.Workspace {
+			return "", fmt.Errorf("files is required unless workspace is set")
+		}
+		root := flagRoot
+		if root == "" {
+			root = "."
+		}
+		p.Files = []string{filepath.Join(root, "...")}
+	}
+	files, err := expandFiles(p.Files)
+	if err != nil {
+		return "", err
+	}
+
+	client, _, err := m.client(ctx, files[0])
+	if err != nil {
+		return "", err
+	}
+	uris, allDiags, missing, err := collectDiagnostics(ctx, client, files)
+	if err != nil {
+		return "", err
+	}
+	if p.Workspace {
+		if allDiags, err = workspaceDiagnostics(ctx, client); err != nil {
+			return "", err
+		}
+		for uri := range allDiags {
+			uris = append(uris, uri)
+		}
+	}
+
+	text, err := render(func(f *output.Formatter) error {
+		for _, fd := range groupDiagnostics(uris, allDiags, diagFilter{severity: lsp.DiagnosticSeverityHint}) {
+			if len(fd.Diagnostics) > 0 {
+				if err := f.Diagnostics(fd.URI, fd.Diagnostics); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(missing) > 0 {
+		// Never let a file the server has not diagnosed look clean.
+		return text + missingDiagnosticsError(missing).Error(), nil
+	}
+	if text == "" {
+		text = "no diagnostics"
+	}
+	return text, nil
+}
+
+func (m *mcpServer) implementations(ctx context.Context, args json.RawMessage) (string, error) {
+	client, uri, line, col, err := m.position(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	locs, err := client.Implementations(ctx, uri, line, col)
+	if err != nil {
+		return "", fmt.Errorf("implementations: %w", err)
+	}
+	if len(locs) == 0 {
+		return "no implementations found", nil
+	}
+	return render(func(f *output.Formatter) error { return f.Locations(locs) })
+}
+
+func (m *mcpServer) workspaceSymbols(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		Query string `json:"query"`
+		Root  string `json:"root"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	root := p.Root
+	if root == "" {
+		root = flagRoot
+	}
+	if root == "" {
+		root = "."
+	}
+	refFile, err := findAnySourceFile(root)
+	if err != nil {
+		return "", fmt.Errorf("cannot find source file for server detection: %w", err)
+	}
+
+	client, _, err := m.client(ctx, refFile)
+	if err != nil {
+		return "", err
+	}
+	syms, err := client.WorkspaceSymbols(ctx, p.Query)
+	if err != nil {
+		return "", fmt.Errorf("workspace symbols: %w", err)
+	}
+	if len(syms) == 0 {
+		return "no symbols found", nil
+	}
+	return render(func(f *output.Formatter) error { return f.SymbolInformations(syms) })
+}
+
+func (m *mcpServer) typeDefinition(ctx context.Context, args json.RawMessage) (string, error) {
+	client, uri, line, col, err := m.position(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	locs, err := client.TypeDefinition(ctx, uri, line, col)
+	if err != nil {
+		return "", fmt.Errorf("type definition: %w", err)
+	}
+	if len(locs) == 0 {
+		return "no type definition found", nil
+	}
+	return render(func(f *output.Formatter) error { return f.Locations(locs) })
+}
+
+func (m *mcpServer) declaration(ctx context.Context, args json.RawMessage) (string, error) {
+	client, uri, line, col, err := m.position(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	locs, err := client.Declaration(ctx, uri, line, col)
+	if err != nil {
+		return "", fmt.Errorf("declaration: %w", err)
+	}
+	if len(locs) == 0 {
+		return "no declaration found", nil
+	}
+	return render(func(f *output.Formatter) error { return f.Locations(locs) })
+}
+
+// callHierarchy returns the callers tool if incoming is set, or else the
+// callees tool.
+func (m *mcpServer) callHierarchy(incoming bool) func(ctx context.Context, args json.RawMessage) (string, error) {
+	name := "callees"
+	if incoming {
+		name = "callers"
+	}
+	return func(ctx context.Context, args json.RawMessage) (string, error) {
+		p := struct {
+			Depth int `json:"depth"`
+		}{Depth: 1}
+		if err := json.Unmarshal(args, &p); err != nil || p.Depth < 1 {
+			return "", fmt.Errorf("depth must be at least 1")
+		}
+		client, uri, line, col, err := m.position(ctx, args)
+		if err != nil {
+			return "", err
+		}
+		roots, err := callTree(ctx, client, uri, line, col, incoming, p.Depth)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", name, err)
+		}
+		if len(roots) == 0 {
+			return "no function found at position", nil
+		}
+		if !hasChildren(roots) {
+			return fmt.Sprintf("no %s found", name), nil
+		}
+		return render(func(f *output.Formatter) error { return f.Hierarchy(roots) })
+	}
+}
+
+func (m *mcpServer) typeHierarchy(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		Direction string `json:"direction"`
+		Depth     int    `json:"depth"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil || (p.Direction != "up" && p.Direction != "down") || p.Depth < 0 {
+		return "", fmt.Errorf("direction (up or down) is required and depth must not be negative")
+	}
+	client, uri, line, col, err := m.position(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	up := p.Direction == "up"
+	roots, err := typeTree(ctx, client, uri, line, col, up, p.Depth)
+	if err != nil {
+		return "", fmt.Errorf("type hierarchy: %w", err)
+	}
+	switch {
+	case len(roots) == 0:
+		return "no type found at position", nil
+	case !hasChildren(roots) && up:
+		return "no supertypes found", nil
+	case !hasChildren(roots):
+		return "no subtypes found", nil
+	}
+	return render(func(f *output.Formatter) error { return f.Hierarchy(roots) })
+}
+
+func (m *mcpServer) signature(ctx context.Context, args json.RawMessage) (string, error) {
+	client, uri, line, col, err := m.position(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	help, err := client.SignatureHelp(ctx, uri, line, col)
+	if err != nil {
+		return "", fmt.Errorf("signature help: %w", err)
+	}
+	if help == nil || len(help.Signatures) == 0 {
+		return "no signature found", nil
+	}
+	return render(func(f *output.Formatter) error { return f.Signatures(help) })
+}
+
+func (m *mcpServer) complete(ctx context.Context, args json.RawMessage) (string, error) {
+	p := struct {
+		Prefix string `json:"prefix"`
+		Kind   string `json:"kind"`
+		Limit  int    `json:"limit"`
+	}{Limit: 50}
+	if err := json.Unmarshal(args, &p); err != nil || p.Limit < 0 {
+		return "", fmt.Errorf("limit must not be negative")
+	}
+	client, uri, line, col, err := m.position(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	items, total, err := completions(ctx, client, uri, line, col, p.Prefix, p.Kind, p.Limit)
+	if err != nil {
+		return "", err
+	}
+	if len(items) == 0 {
+		return "no completions found", nil
+	}
+	text, err := render(func(f *output.Formatter) error { return f.Completions(items) })
+	if err == nil && len(items) < total && !flagJSON {
+		text += fmt.Sprintf("(showing %d of %d completions)\n", len(items), total)
+	}
+	return text, err
+}
+
+func (m *mcpServer) codeActions(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		positionArgs
+		EndLine   int    `json:"endLine"`
+		EndColumn int    `json:"endColumn"`
+		Kind      string `json:"kind"`
+		Apply     int    `json:"apply"`
+		DryRun    bool   `json:"dryRun"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	client, _, line, col, err := m.position(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	rng := lsp.Range{Start: lsp.Position{Line: line, Character: col}, End: lsp.Position{Line: line, Character: col}}
+	if p.EndLine != 0 || p.EndColumn != 0 {
+		rng.End = lsp.Position{Line: p.EndLine - 1, Character: p.EndColumn - 1}
+		if p.EndLine < 1 || p.EndColumn < 1 || before(rng.End, rng.Start) {
+			return "", fmt.Errorf("endLine and endColumn must be 1-indexed and not before the start")
+		}
+	}
+
+	actions, err := codeActions(ctx, client, p.File, rng, p.Kind)
+	if err != nil {
+		return "", err
+	}
+	if len(actions) == 0 {
+		return "no code actions found", nil
+	}
+	if p.Apply == 0 {
+		return render(func(f *output.Formatter) error { return f.CodeActions(actions) })
+	}
+	if p.Apply < 0 || p.Apply > len(actions) {
+		return "", fmt.Errorf("no code action %d; there are %d", p.Apply, len(actions))
+	}
+	action := actions[p.Apply-1]
+	if action.Disabled != nil {
+		return "", fmt.Errorf("code action %d is disabled: %s", p.Apply, action.Disabled.Reason)
+	}
+
+	action, changes, err := actionChanges(ctx, client, action)
+	if err != nil {
+		return "", err
+	}
+	if p.DryRun {
+		text, err := render(func(f *output.Formatter) error { return f.FileChanges(changes) })
+		if err == nil && action.Command != nil {
+			text += fmt.Sprintf("not running command %s (dry run)\n", action.Command.Command)
+		}
+		return text, err
+	}
+	if changes, err = runCodeAction(ctx, client, action, changes); err != nil {
+		return "", err
+	}
+	if len(changes) == 0 && action.Command != nil {
+		return fmt.Sprintf("ran command %s, which changed no files", action.Command.Command), nil
+	}
+	if len(changes) == 0 {
+		return "code action made no changes", nil
+	}
+	return render(func(f *output.Formatter) error { return f.AppliedChanges(changes) })
+}
+
+func (m *mcpServer) format(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		Files []string `json:"files"`
+		Range string   `json:"range"`
+		Diff  bool     `json:"diff"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil || len(p.Files) == 0 {
+		return "", fmt.Errorf("files is required")
+	}
+	var rng *lsp.Range
+	if p.Range != "" {
+		if len(p.Files) > 1 {
+			return "", fmt.Errorf("range needs a single file")
+		}
+		r, err := parseLineRange(p.Range)
+		if err != nil {
+			return "", err
+		}
+		rng = &r
+	}
+
+	client, _, err := m.client(ctx, p.Files[0])
+	if err != nil {
+		return "", err
+	}
+	changes, err := formatChanges(ctx, client, p.Files, rng, 4, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(changes) == 0 {
+		return "already formatted", nil
+	}
+	if p.Diff {
+		return render(func(f *output.Formatter) error { return f.FileChanges(changes) })
+	}
+	if err := textedit.Write(changes); err != nil {
+		return "", fmt.Errorf("format: %w", err)
+	}
+	return render(func(f *output.Formatter) error { return f.AppliedChanges(changes) })
+}
+
+func (m *mcpServer) rename(ctx context.Context, args json.RawMessage) (string, error) {
+	var p struct {
+		NewName string `json:"newName"`
+		Apply   bool   `json:"apply"`
+	}
+	if err := json.Unmarshal(args, &p); err != nil || p.NewName == "" {
+		return "", fmt.Errorf("newName is required")
+	}
+	client, uri, line, col, err := m.position(ctx, args)
+	if err != nil {
+		return "", err
+	}
+	changes, err := renameChanges(ctx, client, uri, line, col, p.NewName)
+	if err != nil {
+		return "", err
+	}
+	if len(changes) == 0 {
+		return "nothing to rename", nil
+	}
+	if !p.Apply {
+		return render(func(f *output.Formatter) error { return f.FileChanges(changes) })
+	}
+	if err := textedit.Write(changes); err != nil {
+		return "", fmt.Errorf("apply rename: %w", err)
+	}
+	return render(func(f *output.Formatter) error { return f.AppliedChanges(changes) })
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mcpClient drives serveMCP the way an MCP client does: one JSON-RPC
+// message per line, each request waiting for its response.
+type mcpClient struct {
+	t      *testing.T
+	w      io.WriteCloser
+	r      *bufio.Scanner
+	nextID int
+}
+
+func (c *mcpClient) request(method string, params interface{}) json.RawMessage {
+	c.t.Helper()
+	c.nextID++
+	data, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      c.nextID,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.w.Write(append(data, '\n')); err != nil {
+		c.t.Fatalf("write %s: %v", method, err)
+	}
+	if !c.r.Scan() {
+		c.t.Fatalf("%s: no response: %v", method, c.r.Err())
+	}
+	var resp struct {
+		ID     int             `json:"id"`
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(c.r.Bytes(), &resp); err != nil {
+		c.t.Fatalf("%s: bad response %s: %v", method, c.r.Bytes(), err)
+	}
+	if resp.ID != c.nextID {
+		c.t.Fatalf("%s: response id %d, want %d", method, resp.ID, c.nextID)
+	}
+	if resp.Error != nil {
+		c.t.Fatalf("%s: %s", method, resp.Error.Message)
+	}
+	return resp.Result
+}
+
+// callTool calls a tool and returns its text, failing if it reports an error.
+func (c *mcpClient) callTool(name string, args map[string]interface{}) string {
+	c.t.Helper()
+	result := c.request("tools/call", map[string]interface{}{"name": name, "arguments": args})
+	var r struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+		IsError bool `json:"isError"`
+	}
+	if err := json.Unmarshal(result, &r); err != nil || len(r.Content) != 1 {
+		c.t.Fatalf("%s: bad result %s", name, result)
+	}
+	if r.IsError {
+		c.t.Fatalf("%s: %s", name, r.Content[0].Text)
+	}
+	return r.Content[0].Text
+}
+
+// startMCP serves the MCP tools, with the test binary as the language
+// server, and returns a client for them.
+func startMCP(t *testing.T, root string) *mcpClient {
+	t.Setenv(fakeServerEnv, "1")
+	oldServer, oldRoot, oldTimeout := flagServer, flagRoot, flagTimeout
+	flagServer, flagRoot, flagTimeout = os.Args[0], root, 10
+	t.Cleanup(func() { flagServer, flagRoot, flagTimeout = oldServer, oldRoot, oldTimeout })
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- serveMCP(inR, outW)
+		outW.Close()
+	}()
+	t.Cleanup(func() {
+		inW.Close()
+		if err := <-done; err != nil {
+			t.Errorf("serveMCP: %v", err)
+		}
+	})
+
+	r := bufio.NewScanner(outR)
+	r.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &mcpClient{t: t, w: inW, r: r}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMCPServeTools(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "a.go")
+	b := filepath.Join(root, "b.go")
+	writeFile(t, a, "package a\n\n// TODO: document\nfunc Hello() {}\n\nfunc main() { Hello() }\n")
+	writeFile(t, b, "package a\n\nfunc World() {}\n")
+
+	c := startMCP(t, root)
+
+	var init struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	json.Unmarshal(c.request("initialize", map[string]interface{}{"protocolVersion": "2025-03-26"}), &init)
+	if init.ProtocolVersion != "2025-03-26" {
+		t.Errorf("protocolVersion = %q, want the client's 2025-03-26", init.ProtocolVersion)
+	}
+
+	var list struct {
+		Tools []struct {
+			Name string `json:"name"`
+		} `json:"tools"`
+	}
+	json.Unmarshal(c.request("tools/list", struct{}{}), &list)
+	var names []string
+	for _, tool := range list.Tools {
+		names = append(names, tool.Name)
+	}
+	want := "definition references hover symbols diagnostics implementations workspace-symbols " +
+		"type-definition declaration callers callees type-hierarchy signature complete code-actions format rename"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("tools = %s, want %s", got, want)
+	}
+
+	pos := func(file string, line, col int) map[string]interface{} {
+		return map[string]interface{}{"file": file, "line": line, "column": col}
+	}
+	tests := []struct {
+		tool string
+		args map[string]interface{}
+		want []string
+	}{
+		{"definition", pos(a, 6, 16), []string{a + ":4:6"}},
+		{"references", pos(a, 4, 6), []string{a + ":4:6", a + ":6:15"}},
+		{"hover", pos(a, 4, 6), []string{"func Hello()"}},
+		{"symbols", map[string]interface{}{"file": a}, []string{"Hello (line 4)", "main (line 6)"}},
+		{"diagnostics", map[string]interface{}{"files": []string{a, b}}, []string{a + ":3:4: warning: unfinished work"}},
+		{"implementations", pos(a, 4, 6), []string{"no implementations found"}},
+		{"workspace-symbols", map[string]interface{}{"query": "o"}, []string{"function Hello", "function World"}},
+	}
+	for _, tt := range tests {
+		got := c.callTool(tt.tool, tt.args)
+		for _, w := range tt.want {
+			if !strings.Contains(got, w) {
+				t.Errorf("%s: got %q, want it to contain %q", tt.tool, got, w)
+			}
+		}
+	}
+
+	// Documents stay open between calls; one changed on disk since must
+	// not be answered from its old text, even when another file is asked
+	// about.
+	writeFile(t, b, "package a\n\nfunc Planet() {}\n")
+	if got := c.callTool("workspace-symbols", map[string]interface{}{"query": "o"}); strings.Contains(got, "World") {
+		t.Errorf("workspace-symbols after b.go changed: got %q, want no World", got)
+	}
+	if got := c.callTool("definition", pos(a, 6, 16)); !strings.Contains(got, a+":4:6") {
+		t.Errorf("definition after b.go changed: got %q", got)
+	}
+	if got := c.callTool("workspace-symbols", map[string]interface{}{"query": "Planet"}); !strings.Contains(got, "Planet") {
+		t.Errorf("workspace-symbols Planet: got %q", got)
+	}
+}
+
+func TestMCPServeMoreTools(t *testing.T) {
+	root := t.TempDir()
+	a := filepath.Join(root, "a.go")
+	b := filepath.Join(root, "b.go")
+	const content = "package a\ntype Pet\ntype Dog extends Pet\nvar rex Dog\nfunc main()\n\thelper(rex)\nfunc helper()\n"
+	writeFile(t, a, content)
+	writeFile(t, b, "package a\n// FIXME: broken\n")
+	c := startMCP(t, root)
+	c.request("initialize", map[string]interface{}{"protocolVersion": "2025-06-18"})
+
+	pos := func(line, col int, more ...interface{}) map[string]interface{} {
+		args := map[string]interface{}{"file": a, "line": line, "column": col}
+		for i := 0; i < len(more); i += 2 {
+			args[more[i].(string)] = more[i+1]
+		}
+		return args
+	}
+	tests := []struct {
+		tool string
+		args map[string]interface{}
+		want string
+	}{
+		{"type-definition", pos(4, 5), a + ":3:6\n"},
+		{"declaration", pos(6, 9), a + ":4:5\n"},
+		{"callees", pos(5, 6), a + ":5:6 function main\n  " + a + ":7:6 function helper (called at " + a + ":6:2)\n"},
+		{"callers", pos(5, 6), "no callers found"},
+		{"callers", pos(1, 1), "no function found at position"},
+		{"type-hierarchy", pos(3, 6, "direction", "up"), a + ":3:6 class Dog\n  " + a + ":2:6 class Pet\n"},
+		{"type-hierarchy", pos(3, 6, "direction", "down"), "no subtypes found"},
+		{"signature", pos(6, 9), "* func helper()\n"},
+		{"signature", pos(1, 1), "no signature found"},
+		{"complete", pos(6, 2, "prefix", "h"), "function helper func helper()\n    Documentation of helper.\n"},
+		{"complete", pos(6, 2, "limit", 1), "class Dog\n    Documentation of Dog.\n(showing 1 of 4 completions)\n"},
+		{"code-actions", map[string]interface{}{"file": b, "line": 2, "column": 4, "kind": "source"}, "1. [source] Sign the file\n"},
+		{"diagnostics", map[string]interface{}{"files": []string{root + "/..."}}, b + ":2:4: error: broken [fake fixme]\n"},
+		{"diagnostics", map[string]interface{}{"files": []string{a}, "workspace": true}, b + ":2:4: error: broken [fake fixme]\n"},
+		{"rename", pos(7, 6, "newName", "assist"), "-\thelper(rex)\n-func helper()\n+\tassist(rex)\n+func assist()\n"},
+		// The test runs outside root, so the diff names files by their
+		// absolute paths.
+		{"rename", pos(7, 6, "newName", "assist"), "--- " + a + "\n+++ " + a + "\n@@ "},
+		{"format", map[string]interface{}{"files": []string{a}, "diff": true}, "already formatted"},
+	}
+	for _, tt := range tests {
+		if got := c.callTool(tt.tool, tt.args); got != tt.want && !strings.Contains(got, tt.want) {
+			t.Errorf("%s %v: got %q, want %q", tt.tool, tt.args, got, tt.want)
+		}
+	}
+	if got := readFile(t, a); got != content {
+		t.Fatalf("a.go changed without being asked to: %q", got)
+	}
+
+	// The tools that edit write only when asked to.
+	c.callTool("rename", pos(7, 6, "newName", "assist", "apply", true))
+	if got, want := readFile(t, a), strings.ReplaceAll(content, "helper", "assist"); got != want {
+		t.Errorf("after rename: a.go = %q, want %q", got, want)
+	}
+	writeFile(t, b, "package a  \n// TODO: later\n")
+	if got := c.callTool("format", map[string]interface{}{"files": []string{b}}); !strings.Contains(got, b+": 1 edit") {
+		t.Errorf("format: got %q", got)
+	}
+	c.callTool("code-actions", map[string]interface{}{"file": b, "line": 2, "column": 4, "apply": 1})
+	c.callTool("code-actions", map[string]interface{}{"file": b, "line": 2, "column": 4, "kind": "source", "apply": 1})
+	if got, want := readFile(t, b), "// signed\npackage a\n// DONE: later\n"; got != want {
+		t.Errorf("after format and code actions: b.go = %q, want %q", got, want)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestMCPServeToolErrors(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.go"), "package a\n")
+	c := startMCP(t, root)
+	c.request("initialize", map[string]interface{}{"protocolVersion": "2025-06-18"})
+
+	result := c.request("tools/call", map[string]interface{}{
+		"name":      "definition",
+		"arguments": map[string]interface{}{"file": filepath.Join(root, "a.go")},
+	})
+	var r struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+		IsError bool `json:"isError"`
+	}
+	json.Unmarshal(result, &r)
+	if !r.IsError || len(r.Content) != 1 || !strings.Contains(r.Content[0].Text, "line and column") {
+		t.Errorf("definition without a position: got %s, want a tool error", result)
+	}
+}
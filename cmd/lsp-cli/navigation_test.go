@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTypeDefinitionAndDeclaration(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "a.go")
+	writeFile(t, path, "package a\ntype Pet\nvar dog Pet\nfunc main()\n\tdog\n")
+
+	tests := []struct {
+		name string
+		args []string
+		out  string // stdout, with @ for the file's path
+		err  string // wanted in stderr
+		code int
+	}{
+		{name: "type definition", args: []string{"type-definition", "a.go:5:2"}, out: "@:2:6\n"},
+		{name: "no type definition", args: []string{"type-definition", "a.go:4:6"}, err: "no type definition found", code: 2},
+		{name: "declaration", args: []string{"declaration", "a.go:5:2"}, out: "@:3:5\n"},
+		{name: "no declaration", args: []string{"declaration", "a.go:4:6"}, err: "no declaration found", code: 2},
+	}
+	for _, tt := range tests {
+		out, errOut, code := runCLI(t, root, tt.args...)
+		want := strings.ReplaceAll(tt.out, "@", path)
+		if code != tt.code || out != want || !strings.Contains(errOut, tt.err) {
+			t.Errorf("%s: exit %d, stdout %q, stderr %q; want exit %d, %q, %q", tt.name, code, out, errOut, tt.code, want, tt.err)
+		}
+	}
+}
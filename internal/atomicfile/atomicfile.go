@@ -0,0 +1,233 @@
+// Package atomicfile replaces file contents without leaving partially
+// written files behind.
+//
+// New content is written to a temporary file in the target's directory,
+// synced to disk, and renamed over the target, so readers see either the
+// old or the new file. The replacement keeps the original's permission
+// bits and, where the platform allows, its owner and group. A symlink is
+// followed and its target replaced, leaving the link itself intact.
+package atomicfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// maxLinks bounds symlink resolution, as the kernel's ELOOP limit does.
+const maxLinks = 255
+
+// rename moves a staged file into place; tests replace it to make renames
+// fail.
+var rename = os.Rename
+
+// File is one entry of a WriteFiles batch.
+type File struct {
+	Path string
+	Data []byte
+}
+
+// WriteFile atomically replaces path with data. An existing file keeps its
+// permission bits and ownership; a new file is created with perm.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	target, err := resolve(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := stage(target, data, perm)
+	if err != nil {
+		return err
+	}
+	if err := rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("replace %s: %w", path, err)
+	}
+	syncDir(target)
+	return nil
+}
+
+// WriteFiles writes every file or none. All contents are staged to
+// temporary files first; only once every file is staged are they renamed
+// into place. If a rename fails, files already replaced are restored to
+// their previous content, and the error names any that could not be. Two
+// entries for the same file, by any path, are an error, since one write
+// would silently undo the other.
+func WriteFiles(files []File, perm os.FileMode) error {
+	if err := checkDistinct(files); err != nil {
+		return err
+	}
+
+	temps := make([]string, 0, len(files))
+	cleanup := func() {
+		for _, tmp := range temps {
+			os.Remove(tmp)
+		}
+	}
+
+	targets := make([]string, len(files))
+	originals := make([][]byte, len(files))
+	for i, f := range files {
+		target, err := resolve(f.Path)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		targets[i] = target
+
+		data, err := os.ReadFile(target)
+		if err != nil && !os.IsNotExist(err) {
+			cleanup()
+			return fmt.Errorf("read %s: %w", f.Path, err)
+		}
+		originals[i] = data
+
+		tmp, err := stage(target, f.Data, perm)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		temps = append(temps, tmp)
+	}
+
+	for i, f := range files {
+		if err := rename(temps[i], targets[i]); err != nil {
+			temps = temps[i:]
+			cleanup()
+			err = fmt.Errorf("replace %s: %w", f.Path, err)
+			if i == 0 {
+				return err
+			}
+			var failed []error
+			for j := 0; j < i; j++ {
+				var rerr error
+				if originals[j] != nil {
+					rerr = WriteFile(targets[j], originals[j], perm)
+				} else {
+					rerr = os.Remove(targets[j])
+				}
+				if rerr != nil {
+					failed = append(failed, fmt.Errorf("restore %s: %w", files[j].Path, rerr))
+				}
+			}
+			if len(failed) > 0 {
+				return errors.Join(append([]error{fmt.Errorf("%w; %d of %d files already replaced could not be restored", err, len(failed), i)}, failed...)...)
+			}
+			return fmt.Errorf("%w (earlier files restored)", err)
+		}
+	}
+	for _, target := range targets {
+		syncDir(target)
+	}
+	return nil
+}
+
+// checkDistinct reports an error if two files resolve to the same file,
+// through symlinks, hard links or differently spelled paths.
+func checkDistinct(files []File) error {
+	type seen struct {
+		name, path string      // as given, and resolved
+		info       os.FileInfo // nil for a file that does not exist yet
+	}
+	var prev []seen
+	for _, f := range files {
+		target, err := resolve(f.Path)
+		if err != nil {
+			return err
+		}
+		abs, err := filepath.Abs(target)
+		if err != nil {
+			return err
+		}
+		info, _ := os.Stat(abs)
+		for _, p := range prev {
+			if p.path == abs || (info != nil && p.info != nil && os.SameFile(p.info, info)) {
+				return fmt.Errorf("%s and %s are the same file", p.name, f.Path)
+			}
+		}
+		prev = append(prev, seen{f.Path, abs, info})
+	}
+	return nil
+}
+
+// resolve follows path through any symlinks to the file that should be
+// replaced. Renaming over the link itself would turn it into a regular
+// file. A dangling link resolves to its (missing) target, which is then
+// created.
+func resolve(path string) (string, error) {
+	for i := 0; i < maxLinks; i++ {
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return path, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return path, nil
+		}
+
+		dest, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(dest) {
+			dest = filepath.Join(filepath.Dir(path), dest)
+		}
+		path = dest
+	}
+	return "", fmt.Errorf("resolve %s: too many levels of symbolic links", path)
+}
+
+// stage writes data to a temporary file next to path, with path's current
+// permission bits and ownership (or perm for a new file), syncs it, and
+// returns its name.
+func stage(path string, data []byte, perm os.FileMode) (string, error) {
+	info, err := os.Stat(path)
+	if err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return "", fmt.Errorf("create temp file for %s: %w", path, err)
+	}
+	tmp := f.Name()
+	fail := func(err error) (string, error) {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		return fail(fmt.Errorf("write %s: %w", tmp, err))
+	}
+	if err := f.Chmod(perm); err != nil {
+		return fail(fmt.Errorf("chmod %s: %w", tmp, err))
+	}
+	if info != nil {
+		if err := chown(f, info); err != nil {
+			return fail(fmt.Errorf("chown %s: %w", tmp, err))
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return fail(fmt.Errorf("sync %s: %w", tmp, err))
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("write %s: %w", tmp, err)
+	}
+	return tmp, nil
+}
+
+// syncDir flushes the directory entry of path so the rename itself
+// survives a crash. Failure is ignored: the data is already on disk, and
+// some filesystems do not support syncing directories.
+func syncDir(path string) {
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
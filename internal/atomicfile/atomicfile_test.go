@@ -0,0 +1,292 @@
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// checkNoTemps fails if a temporary file was left behind in dir.
+func checkNoTemps(t *testing.T, dir string, want int) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != want {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("%s holds %q, want %d entries", dir, names, want)
+	}
+}
+
+func TestWriteFileKeepsMode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "script.sh")
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0751); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFile(path, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "new" {
+		t.Errorf("content = %q, want new", got)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0751 {
+		t.Errorf("mode = %v, want 0751", info.Mode().Perm())
+	}
+	checkNoTemps(t, dir, 1)
+}
+
+func TestWriteFileNew(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "new.txt")
+	if err := WriteFile(path, []byte("data"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+	if got := readFile(t, path); got != "data" {
+		t.Errorf("content = %q, want data", got)
+	}
+}
+
+func TestWriteFileThroughSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(sub, "target.txt")
+	if err := os.WriteFile(target, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// link -> sub/middle -> target.txt, the second link relative to sub.
+	middle := filepath.Join(sub, "middle")
+	if err := os.Symlink("target.txt", middle); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(middle, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteFile(link, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range []string{link, middle} {
+		info, err := os.Lstat(l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("%s is no longer a symlink", l)
+		}
+	}
+	if got := readFile(t, target); got != "new" {
+		t.Errorf("target content = %q, want new", got)
+	}
+	checkNoTemps(t, sub, 2)
+}
+
+func TestWriteFileDanglingSymlink(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("missing.txt", link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	if err := WriteFile(link, []byte("created"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(dir, "missing.txt")); got != "created" {
+		t.Errorf("target content = %q, want created", got)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link was replaced: %v", err)
+	}
+}
+
+func TestWriteFileSymlinkLoop(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := os.Symlink("b", a); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	if err := os.Symlink("a", b); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(a, []byte("x"), 0644); err == nil {
+		t.Error("WriteFile through a symlink loop succeeded")
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := os.WriteFile(a, []byte("old a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := WriteFiles([]File{{a, []byte("new a")}, {b, []byte("new b")}}, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, a); got != "new a" {
+		t.Errorf("a = %q, want new a", got)
+	}
+	if got := readFile(t, b); got != "new b" {
+		t.Errorf("b = %q, want new b", got)
+	}
+	info, err := os.Stat(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("a mode = %v, want 0600", info.Mode().Perm())
+	}
+	checkNoTemps(t, dir, 2)
+}
+
+// TestWriteFilesNone checks that a file that cannot be staged leaves every
+// file unchanged.
+func TestWriteFilesNone(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	if err := os.WriteFile(a, []byte("old a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "no-such-dir", "b")
+
+	if err := WriteFiles([]File{{a, []byte("new a")}, {missing, []byte("new b")}}, 0644); err == nil {
+		t.Fatal("WriteFiles into a missing directory succeeded")
+	}
+	if got := readFile(t, a); got != "old a" {
+		t.Errorf("a = %q, want it unchanged", got)
+	}
+	checkNoTemps(t, dir, 1)
+}
+
+func TestWriteFilesSameFile(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	if err := os.WriteFile(a, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("a", link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	hard := filepath.Join(dir, "hard")
+	if err := os.Link(a, hard); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+	fresh := filepath.Join(dir, "fresh")
+
+	for _, other := range []string{a, dir + "/./a", link, hard} {
+		err := WriteFiles([]File{{a, []byte("one")}, {other, []byte("two")}}, 0644)
+		if err == nil {
+			t.Errorf("WriteFiles to %s and %s succeeded", a, other)
+		}
+	}
+	err := WriteFiles([]File{{fresh, []byte("one")}, {dir + "/sub/../fresh", []byte("two")}}, 0644)
+	if err == nil {
+		t.Error("WriteFiles to a new file twice succeeded")
+	}
+	if got := readFile(t, a); got != "old" {
+		t.Errorf("a = %q, want it unchanged", got)
+	}
+	if _, err := os.Stat(fresh); !os.IsNotExist(err) {
+		t.Errorf("fresh was created: %v", err)
+	}
+}
+
+// failRenames makes renames onto the paths given fail, and every rename
+// after the first n if n is not negative, until the test ends.
+func failRenames(t *testing.T, n int, paths ...string) {
+	t.Cleanup(func() { rename = os.Rename })
+	rename = func(from, to string) error {
+		for _, p := range paths {
+			if to == p {
+				return errors.New("injected failure")
+			}
+		}
+		if n == 0 {
+			return errors.New("injected failure")
+		}
+		n--
+		return os.Rename(from, to)
+	}
+}
+
+func TestWriteFilesRestores(t *testing.T) {
+	dir := t.TempDir()
+	a, b, c := filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "c")
+	if err := os.WriteFile(a, []byte("old a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	failRenames(t, -1, c)
+
+	err := WriteFiles([]File{{a, []byte("new a")}, {b, []byte("new b")}, {c, []byte("new c")}}, 0644)
+	if err == nil || !strings.Contains(err.Error(), "replace "+c) || !strings.Contains(err.Error(), "earlier files restored") {
+		t.Fatalf("err = %v, want replacing c to fail with the others restored", err)
+	}
+	if got := readFile(t, a); got != "old a" {
+		t.Errorf("a = %q, want it restored", got)
+	}
+	if _, err := os.Stat(b); !os.IsNotExist(err) {
+		t.Errorf("b was left behind: %v", err)
+	}
+	checkNoTemps(t, dir, 1)
+}
+
+func TestWriteFilesRestoreFails(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	if err := os.WriteFile(a, []byte("old a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Replacing a succeeds, but neither replacing b nor restoring a does.
+	failRenames(t, 1)
+
+	err := WriteFiles([]File{{a, []byte("new a")}, {b, []byte("new b")}}, 0644)
+	if err == nil {
+		t.Fatal("WriteFiles succeeded")
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "restored") && !strings.Contains(msg, "could not be restored") {
+		t.Errorf("err = %v, claims a was restored", err)
+	}
+	if !strings.Contains(msg, "replace "+b) || !strings.Contains(msg, "restore "+a) {
+		t.Errorf("err = %v, want it to report both the failed replace and the failed restore", err)
+	}
+	if got := readFile(t, a); got != "new a" {
+		t.Errorf("a = %q, want new a, as reported", got)
+	}
+	checkNoTemps(t, dir, 1)
+}
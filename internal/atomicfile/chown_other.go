@@ -0,0 +1,10 @@
+//go:build !unix
+
+package atomicfile
+
+import "os"
+
+// chown is a no-op where files have no Unix owner.
+func chown(f *os.File, info os.FileInfo) error {
+	return nil
+}
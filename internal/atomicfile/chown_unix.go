@@ -0,0 +1,29 @@
+//go:build unix
+
+package atomicfile
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// chown gives f the owner and group of the file described by info. Only
+// root may give a file away, so a permission error is ignored: the
+// replacement then belongs to the current user, as any rewrite would.
+func chown(f *os.File, info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if int(st.Uid) == os.Getuid() && int(st.Gid) == os.Getgid() {
+		return nil
+	}
+	err := f.Chown(int(st.Uid), int(st.Gid))
+	if errors.Is(err, syscall.EPERM) {
+		// Keep at least the group if we belong to it.
+		f.Chown(-1, int(st.Gid))
+		return nil
+	}
+	return err
+}
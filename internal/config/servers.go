@@ -75,6 +75,17 @@ func DetectServer(filePath string) (*ServerConfig, error) {
 		lang, serverNames(configs))
 }
 
+// ServerKey identifies the language servers that handle a file: files with
+// the same key can share one server, such as .c and .h files or .ts and .js
+// files. Returns "" for files of unknown type.
+func ServerKey(filePath string) string {
+	configs, ok := knownServers[detectLanguage(filePath)]
+	if !ok {
+		return ""
+	}
+	return serverNames(configs)
+}
+
 // ParseServerFlag parses a --server flag value into a command.
 func ParseServerFlag(server string) []string {
 	return strings.Fields(server)
@@ -0,0 +1,130 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// dialTimeout bounds how long a one-shot command waits for the daemon to
+// accept, so a wedged daemon falls back to spawning instead of hanging.
+const dialTimeout = 2 * time.Second
+
+// DefaultSocket returns the default daemon socket path:
+// $XDG_RUNTIME_DIR/lsp-cli.sock, or lsp-cli-<uid>.sock in the temp dir.
+func DefaultSocket() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "lsp-cli.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("lsp-cli-%d.sock", os.Getuid()))
+}
+
+// Dial connects to the daemon and attaches to the server for the given
+// command and workspace root, waiting for the server to start until ctx
+// is done. The returned connection speaks the LSP base protocol and can
+// be handed to lsp.NewClient.
+func Dial(ctx context.Context, socketPath string, serverCmd []string, rootDir string) (net.Conn, error) {
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, fmt.Errorf("resolve root dir: %w", err)
+	}
+
+	conn, _, err := request(ctx, socketPath, hello{Op: opAttach, Command: serverCmd, Root: absRoot})
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Running reports whether a daemon is accepting connections on socketPath.
+func Running(socketPath string) bool {
+	_, err := GetStatus(socketPath)
+	return err == nil
+}
+
+// GetStatus asks the daemon for its pid and the servers it keeps warm.
+func GetStatus(socketPath string) (*Status, error) {
+	conn, r, err := request(context.Background(), socketPath, hello{Op: opStatus})
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+	if r.Status == nil {
+		return nil, errors.New("daemon returned no status")
+	}
+	return r.Status, nil
+}
+
+// Stop asks the daemon to shut down its servers and exit.
+func Stop(socketPath string) error {
+	conn, _, err := request(context.Background(), socketPath, hello{Op: opStop})
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+// request sends the handshake line and reads the reply line.
+func request(ctx context.Context, socketPath string, h hello) (net.Conn, *reply, error) {
+	conn, err := net.DialTimeout("unix", socketPath, dialTimeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect to daemon: %w", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if err := writeLine(conn, h); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("daemon handshake: %w", err)
+	}
+
+	// Attaching may start a language server, which can take a while.
+	if h.Op == opAttach {
+		deadline, _ := ctx.Deadline()
+		conn.SetDeadline(deadline)
+	}
+
+	var r reply
+	if err := readLine(conn, &r); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("daemon handshake: %w", err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	if r.Error != "" {
+		conn.Close()
+		return nil, nil, fmt.Errorf("daemon: %s", r.Error)
+	}
+	return conn, &r, nil
+}
+
+func writeLine(conn net.Conn, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(data, '\n'))
+	return err
+}
+
+// readLine reads one newline-terminated JSON value a byte at a time, so no
+// bytes belonging to the LSP stream that follows are consumed.
+func readLine(conn net.Conn, v interface{}) error {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			return err
+		}
+		if buf[0] == '\n' {
+			break
+		}
+		line = append(line, buf[0])
+	}
+	return json.Unmarshal(line, v)
+}
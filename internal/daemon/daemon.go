@@ -0,0 +1,203 @@
+// Package daemon keeps language servers warm between lsp-cli invocations.
+//
+// The daemon listens on a Unix socket. Each connection starts with a single
+// line of JSON (the handshake) naming an operation. For "attach", the rest
+// of the connection is an LSP session that is proxied to a long-lived
+// server for the requested (command, root) pair, so one-shot commands can
+// use an ordinary lsp.Client over the socket.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+)
+
+const (
+	opAttach = "attach"
+	opStatus = "status"
+	opStop   = "stop"
+)
+
+// startTimeout bounds how long a language server may take to start and
+// answer initialize.
+const startTimeout = 2 * time.Minute
+
+// hello is the handshake sent by the client.
+type hello struct {
+	Op      string   `json:"op"`
+	Command []string `json:"command,omitempty"`
+	Root    string   `json:"root,omitempty"`
+}
+
+// reply answers the handshake. Error is empty on success.
+type reply struct {
+	Error  string  `json:"error,omitempty"`
+	Status *Status `json:"status,omitempty"`
+}
+
+// Status describes a running daemon.
+type Status struct {
+	PID      int             `json:"pid"`
+	Socket   string          `json:"socket"`
+	Started  time.Time       `json:"started"`
+	Sessions int             `json:"sessions"`
+	Servers  []lsp.PoolEntry `json:"servers"`
+}
+
+// Server owns the language server pool and the listening socket.
+type Server struct {
+	socket  string
+	verbose bool
+	pool    *lsp.Pool
+	started time.Time
+
+	ln       net.Listener
+	mu       sync.Mutex
+	sessions map[net.Conn]struct{}
+	docs     map[*lsp.Client]*documents // of each backend
+	stopping bool
+}
+
+// Serve listens on socketPath and serves until a stop request arrives.
+// A stale socket file left by a crashed daemon is removed; a live daemon
+// on the same socket is an error. The servers' stderr is written to
+// serverLog if it is not nil.
+func Serve(socketPath string, verbose bool, serverLog io.Writer) error {
+	if Running(socketPath) {
+		return fmt.Errorf("daemon already running on %s", socketPath)
+	}
+	os.Remove(socketPath)
+
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	os.Chmod(socketPath, 0600)
+
+	pool := lsp.NewPool(verbose)
+	pool.ServerLog = serverLog
+	s := &Server{
+		socket:   socketPath,
+		verbose:  verbose,
+		pool:     pool,
+		started:  time.Now(),
+		ln:       ln,
+		sessions: make(map[net.Conn]struct{}),
+		docs:     make(map[*lsp.Client]*documents),
+	}
+	return s.serve()
+}
+
+func (s *Server) serve() error {
+	defer os.Remove(s.socket)
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			stopping := s.stopping
+			s.mu.Unlock()
+			if stopping || errors.Is(err, net.ErrClosed) {
+				break
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+		go s.handle(conn)
+	}
+
+	// Drop attached sessions before shutting the servers down under them.
+	s.mu.Lock()
+	for conn := range s.sessions {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.pool.Close()
+	return nil
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var h hello
+	if err := readLine(conn, &h); err != nil {
+		s.logf("bad handshake: %v", err)
+		return
+	}
+
+	switch h.Op {
+	case opStatus:
+		writeLine(conn, reply{Status: s.status()})
+
+	case opStop:
+		s.logf("stop requested")
+		writeLine(conn, reply{})
+		s.mu.Lock()
+		s.stopping = true
+		s.mu.Unlock()
+		s.ln.Close()
+
+	case opAttach:
+		if len(h.Command) == 0 || h.Root == "" {
+			writeLine(conn, reply{Error: "attach requires command and root"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+		client, err := s.pool.Get(ctx, h.Command, h.Root)
+		cancel()
+		if err != nil {
+			writeLine(conn, reply{Error: fmt.Sprintf("start LSP server: %v", err)})
+			return
+		}
+		if err := writeLine(conn, reply{}); err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.sessions[conn] = struct{}{}
+		docs, ok := s.docs[client]
+		if !ok {
+			docs = newDocuments(client)
+			s.docs[client] = docs
+		}
+		s.mu.Unlock()
+
+		s.logf("attach: %v root=%s", h.Command, h.Root)
+		newSession(conn, client, docs, s.verbose).serve()
+
+		s.mu.Lock()
+		delete(s.sessions, conn)
+		s.mu.Unlock()
+
+	default:
+		writeLine(conn, reply{Error: fmt.Sprintf("unknown op %q", h.Op)})
+	}
+}
+
+func (s *Server) status() *Status {
+	s.mu.Lock()
+	sessions := len(s.sessions)
+	s.mu.Unlock()
+
+	return &Status{
+		PID:      os.Getpid(),
+		Socket:   s.socket,
+		Started:  s.started,
+		Sessions: sessions,
+		Servers:  s.pool.Entries(),
+	}
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.verbose {
+		fmt.Fprintf(os.Stderr, "daemon: "+format+"\n", args...)
+	}
+}
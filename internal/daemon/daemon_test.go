@@ -0,0 +1,414 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+)
+
+// fakeServerEnv makes the test binary run as a language server whose
+// workspace symbols are the words of its open documents.
+const fakeServerEnv = "DAEMON_TEST_FAKE_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeServerEnv) != "" {
+		runFakeServer()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func runFakeServer() {
+	t := lsp.NewTransport(os.Stdin, os.Stdout)
+	docs := make(map[string]string)
+	commands := make(map[int64]int64) // applyEdit id -> executeCommand id
+	var nextID int64
+	reply := func(id *int64, result interface{}) {
+		resultJSON, _ := json.Marshal(result)
+		resp, _ := json.Marshal(lsp.Response{JSONRPC: "2.0", ID: id, Result: resultJSON})
+		t.WriteMessage(resp)
+	}
+	for {
+		data, err := t.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg struct {
+			ID     *int64          `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if json.Unmarshal(data, &msg) != nil {
+			continue
+		}
+
+		if msg.Method == "" {
+			// The client applied the edit a command asked for.
+			if msg.ID != nil {
+				if id, ok := commands[*msg.ID]; ok {
+					delete(commands, *msg.ID)
+					reply(&id, nil)
+				}
+			}
+			continue
+		}
+
+		var result interface{}
+		switch msg.Method {
+		case "exit":
+			return
+		case "initialize":
+			result = lsp.InitializeResult{Capabilities: lsp.ServerCapabilities{WorkspaceSymbolProvider: true}}
+		case "textDocument/didOpen":
+			var p lsp.DidOpenTextDocumentParams
+			json.Unmarshal(msg.Params, &p)
+			docs[p.TextDocument.URI] = p.TextDocument.Text
+		case "textDocument/didChange":
+			var p lsp.DidChangeTextDocumentParams
+			json.Unmarshal(msg.Params, &p)
+			docs[p.TextDocument.URI] = p.ContentChanges[len(p.ContentChanges)-1].Text
+		case "textDocument/didClose":
+			var p lsp.DidCloseTextDocumentParams
+			json.Unmarshal(msg.Params, &p)
+			delete(docs, p.TextDocument.URI)
+		case "workspace/symbol":
+			// The query "exit N" makes the server crash with status N.
+			var p lsp.WorkspaceSymbolParams
+			json.Unmarshal(msg.Params, &p)
+			if code, ok := strings.CutPrefix(p.Query, "exit "); ok {
+				fmt.Fprintf(os.Stderr, "exiting with %s\n", code)
+				n, _ := strconv.Atoi(code)
+				os.Exit(n)
+			}
+			syms := []lsp.SymbolInformation{}
+			for uri, text := range docs {
+				for _, word := range strings.Fields(text) {
+					syms = append(syms, lsp.SymbolInformation{Name: word, Location: lsp.Location{URI: uri}})
+				}
+			}
+			result = syms
+		case "workspace/executeCommand":
+			// A command asks the client to apply an edit labelled with
+			// its argument, and returns once that is done.
+			var p lsp.ExecuteCommandParams
+			json.Unmarshal(msg.Params, &p)
+			var label string
+			if len(p.Arguments) > 0 {
+				json.Unmarshal(p.Arguments[0], &label)
+			}
+			nextID++
+			commands[nextID] = *msg.ID
+			paramsJSON, _ := json.Marshal(lsp.ApplyWorkspaceEditParams{Label: label})
+			req, _ := json.Marshal(lsp.Request{JSONRPC: "2.0", ID: &nextID, Method: "workspace/applyEdit", Params: paramsJSON})
+			t.WriteMessage(req)
+			continue
+		}
+		if msg.ID != nil {
+			reply(msg.ID, result)
+		}
+	}
+}
+
+// startDaemon serves a daemon on a socket in a temporary directory and
+// returns the socket's path. The daemon is stopped at the end of the test.
+func startDaemon(t *testing.T) string {
+	t.Helper()
+	t.Setenv(fakeServerEnv, "1")
+	socket := filepath.Join(t.TempDir(), "d.sock")
+	done := make(chan error, 1)
+	go func() { done <- Serve(socket, false, nil) }()
+	t.Cleanup(func() {
+		Stop(socket)
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+
+	for deadline := time.Now().Add(5 * time.Second); !Running(socket); {
+		if time.Now().After(deadline) {
+			t.Fatal("daemon did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return socket
+}
+
+// attach connects a client to the daemon's server for root.
+func attach(t *testing.T, socket, root string) *lsp.Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := Dial(ctx, socket, []string{os.Args[0]}, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := lsp.NewClient(ctx, conn, root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// symbols returns the names of the workspace symbols, sorted.
+func symbols(t *testing.T, client *lsp.Client) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	syms, err := client.WorkspaceSymbols(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range syms {
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
+
+func TestDaemonStatusAndStop(t *testing.T) {
+	socket := startDaemon(t)
+
+	st, err := GetStatus(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.PID != os.Getpid() || st.Socket != socket || st.Sessions != 0 || len(st.Servers) != 0 {
+		t.Errorf("status = %+v", st)
+	}
+	if err := Serve(socket, false, nil); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("second Serve on the socket: %v, want already running", err)
+	}
+
+	if err := Stop(socket); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); Running(socket); {
+		if time.Now().After(deadline) {
+			t.Fatal("daemon still running after stop")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket left behind: %v", err)
+	}
+}
+
+func TestDaemonBadHandshake(t *testing.T) {
+	socket := startDaemon(t)
+	ctx := context.Background()
+	if _, err := Dial(ctx, socket, nil, t.TempDir()); err == nil || !strings.Contains(err.Error(), "requires command") {
+		t.Errorf("attach without a command: %v", err)
+	}
+	if _, _, err := request(ctx, socket, hello{Op: "dance"}); err == nil || !strings.Contains(err.Error(), `unknown op "dance"`) {
+		t.Errorf("unknown op: %v", err)
+	}
+}
+
+// TestDaemonKeepsServerWarm checks that sessions share one server, and
+// that documents a past session opened are brought up to date with the
+// files on disk when the next one attaches.
+func TestDaemonKeepsServerWarm(t *testing.T) {
+	socket := startDaemon(t)
+	root := t.TempDir()
+	a, b := filepath.Join(root, "a.go"), filepath.Join(root, "b.go")
+	os.WriteFile(a, []byte("alpha"), 0644)
+	os.WriteFile(b, []byte("bravo"), 0644)
+
+	client := attach(t, socket, root)
+	for _, path := range []string{a, b} {
+		if _, err := client.OpenFile(context.Background(), path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := symbols(t, client); got != "alpha bravo" {
+		t.Errorf("first session symbols = %q", got)
+	}
+	client.Close()
+
+	os.WriteFile(a, []byte("apple"), 0644)
+	os.Remove(b)
+
+	client = attach(t, socket, root)
+	defer client.Close()
+	if got := symbols(t, client); got != "apple" {
+		t.Errorf("second session symbols = %q, want only the new text of a.go", got)
+	}
+
+	st, err := GetStatus(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Servers) != 1 {
+		t.Errorf("status = %+v, want one server", st)
+	}
+}
+
+// TestPoolRestartsCrashedServer checks that a crashed server is restarted
+// with its documents reopened, up to the restart limit, after which the
+// pool replaces it.
+func TestPoolRestartsCrashedServer(t *testing.T) {
+	t.Setenv(fakeServerEnv, "1")
+	root := t.TempDir()
+	a := filepath.Join(root, "a.go")
+	os.WriteFile(a, []byte("alpha"), 0644)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	pool := lsp.NewPool(false)
+	defer pool.Close()
+	command := []string{os.Args[0]}
+	client, err := pool.Get(ctx, command, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.OpenFile(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+
+	crash := func() {
+		t.Helper()
+		_, err := client.WorkspaceSymbols(ctx, "exit 3")
+		var crashed *lsp.ServerCrashedError
+		if !errors.As(err, &crashed) {
+			t.Fatalf("call that crashed the server: %v, want a ServerCrashedError", err)
+		}
+		if crashed.ExitCode != 3 || len(crashed.Stderr) == 0 || crashed.Stderr[len(crashed.Stderr)-1] != "exiting with 3" {
+			t.Errorf("crash = %+v, want exit code 3 and the server's stderr", crashed)
+		}
+	}
+	// waitFor polls until cond holds.
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); !cond(); {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	for i := 1; i <= 3; i++ {
+		crash()
+		waitFor("restart", func() bool { return client.Restarts() == i && client.Crashed() == nil })
+		if got := symbols(t, client); got != "alpha" {
+			t.Errorf("symbols after restart %d = %q, want the reopened a.go", i, got)
+		}
+	}
+
+	// A fourth crash in the restart window is not restarted, and the pool
+	// starts a new server in its place.
+	crash()
+	var next *lsp.Client
+	waitFor("a new server from the pool", func() bool {
+		next, err = pool.Get(ctx, command, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return next != client
+	})
+	if client.Restarts() != 3 || client.Crashed() == nil {
+		t.Errorf("crashed client: %d restarts, crash %v; want 3 and still crashed", client.Restarts(), client.Crashed())
+	}
+	if next.Crashed() != nil || len(pool.Entries()) != 1 {
+		t.Errorf("new client crash %v, pool entries %+v", next.Crashed(), pool.Entries())
+	}
+}
+
+// TestDaemonRoutesEditsToTheirSession checks that the edits a command asks
+// for reach the session that ran it while other sessions run commands too.
+func TestDaemonRoutesEditsToTheirSession(t *testing.T) {
+	socket := startDaemon(t)
+	root := t.TempDir()
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b", "c"} {
+		client := attach(t, socket, root)
+		defer client.Close()
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			var labels []string
+			remove, err := client.HandleApplyEdit(ctx, func(p lsp.ApplyWorkspaceEditParams) error {
+				labels = append(labels, p.Label)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer remove()
+			arg, _ := json.Marshal(name)
+			const rounds = 20
+			for i := 0; i < rounds; i++ {
+				if _, err := client.ExecuteCommand(ctx, lsp.Command{Command: "edit", Arguments: []json.RawMessage{arg}}); err != nil {
+					t.Errorf("session %s: %v", name, err)
+					return
+				}
+			}
+			if len(labels) != rounds || strings.Count(strings.Join(labels, ""), name) != rounds {
+				t.Errorf("session %s got edits %q, want %d of its own", name, labels, rounds)
+			}
+		}(name)
+	}
+	wg.Wait()
+}
+
+// TestDaemonClosesIdleDocuments checks that documents no session has open
+// stay open on the server up to the limit, the least recently used being
+// closed beyond it, and that one another session has open is kept.
+func TestDaemonClosesIdleDocuments(t *testing.T) {
+	socket := startDaemon(t)
+	root := t.TempDir()
+	ctx := context.Background()
+	open := func(client *lsp.Client, name string) string {
+		t.Helper()
+		path := filepath.Join(root, name+".go")
+		os.WriteFile(path, []byte(name), 0644)
+		uri, err := client.OpenFile(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return uri
+	}
+
+	kept := attach(t, socket, root)
+	defer kept.Close()
+	open(kept, "shared")
+
+	client := attach(t, socket, root)
+	for i := 0; i <= maxIdleDocuments; i++ {
+		uri := open(client, fmt.Sprintf("w%03d", i))
+		if err := client.CloseFile(ctx, uri); err != nil {
+			t.Fatal(err)
+		}
+	}
+	open(client, "shared")
+	open(client, "last")
+	client.Close() // leaves last idle, closing w000 and w001
+
+	// The daemon ends the session after the client has gone.
+	var words []string
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		words = strings.Fields(symbols(t, kept))
+		if len(words) == maxIdleDocuments+1 || time.Now().After(deadline) {
+			break
+		}
+	}
+	if len(words) != maxIdleDocuments+1 || words[0] != "last" || words[1] != "shared" || words[2] != "w002" {
+		t.Errorf("open documents = %d words starting %v, want %d starting last shared w002", len(words), words[:min(3, len(words))], maxIdleDocuments+1)
+	}
+}
@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"context"
+	"sync"
+
+	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+)
+
+// maxIdleDocuments is how many documents no session has open are kept
+// open on a backend, so the next sessions find them warm.
+const maxIdleDocuments = 200
+
+// documents counts the sessions that have each document of a backend open.
+// A document none has open becomes idle; once more than maxIdleDocuments
+// are, the least recently used is closed on the backend, so the cost of
+// bringing them up to date when a session attaches stays bounded.
+type documents struct {
+	backend *lsp.Client
+
+	mu   sync.Mutex
+	refs map[string]int // URI -> sessions having it open
+	idle []string       // URIs no session has open, least recently used first
+}
+
+func newDocuments(backend *lsp.Client) *documents {
+	return &documents{
+		backend: backend,
+		refs:    make(map[string]int),
+	}
+}
+
+// acquire records that a session has a document open. It is called before
+// the document is opened on the backend, so it is not closed meanwhile.
+func (d *documents) acquire(uri string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refs[uri]++
+	for i, u := range d.idle {
+		if u == uri {
+			d.idle = append(d.idle[:i], d.idle[i+1:]...)
+			break
+		}
+	}
+}
+
+// release records that a session has closed a document, and closes the
+// least recently used idle documents beyond maxIdleDocuments.
+func (d *documents) release(ctx context.Context, uri string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.refs[uri]--; d.refs[uri] > 0 {
+		return nil
+	}
+	delete(d.refs, uri)
+	d.idle = append(d.idle, uri)
+
+	var err error
+	for len(d.idle) > maxIdleDocuments {
+		oldest := d.idle[0]
+		d.idle = d.idle[1:]
+		if cerr := d.backend.CloseFile(ctx, oldest); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,392 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+)
+
+// session proxies one attached lsp-cli invocation to a shared backend client.
+//
+// The session answers initialize and shutdown itself, since the backend is
+// already initialized and must outlive the invocation. didOpen and didChange
+// become an open-or-update on the backend, and everything else is forwarded
+// as is. Documents stay open on the backend after the session closes them
+// or ends, up to a limit (see documents), so each new session first brings
+// them up to date with the files on disk. Server
+// requests are answered by the backend client, except the edits a command
+// asks for, which are passed on to the session's client since it owns the
+// files.
+//
+// Forwarded requests get the backend's own ids, so a $/cancelRequest from
+// the session's client is not forwarded but cancels the forwarded call,
+// which sends the backend's cancellation with the right id. Calls still
+// running when the session ends are cancelled the same way.
+type session struct {
+	conn      net.Conn
+	transport *lsp.Transport
+	backend   *lsp.Client
+	docs      *documents
+	verbose   bool
+
+	removeListener func()
+
+	ctx    context.Context // done when the session ends
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	inflight map[int64]context.CancelFunc // client request id -> cancel
+	opened   map[string]bool              // URIs the session has open
+
+	// requests sent to the session's client, awaiting its response
+	nextID  atomic.Int64
+	pending map[int64]chan *lsp.Response
+}
+
+func newSession(conn net.Conn, backend *lsp.Client, docs *documents, verbose bool) *session {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &session{
+		conn:      conn,
+		transport: lsp.NewTransport(conn, conn),
+		backend:   backend,
+		docs:      docs,
+		verbose:   verbose,
+		ctx:       ctx,
+		cancel:    cancel,
+		inflight:  make(map[int64]context.CancelFunc),
+		opened:    make(map[string]bool),
+		pending:   make(map[int64]chan *lsp.Response),
+	}
+}
+
+func (s *session) serve() {
+	defer func() {
+		s.cancel()
+		if s.removeListener != nil {
+			s.removeListener()
+		}
+		s.mu.Lock()
+		opened := s.opened
+		s.opened = nil
+		s.mu.Unlock()
+		for uri := range opened {
+			if err := s.docs.release(context.Background(), uri); err != nil {
+				s.logf("close documents: %v", err)
+			}
+		}
+	}()
+
+	for {
+		data, err := s.transport.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg struct {
+			ID     *int64          `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+
+		if msg.Method == "" {
+			if msg.ID != nil {
+				s.handleResponse(data)
+			}
+			continue
+		}
+
+		if msg.ID == nil {
+			if msg.Method == "exit" {
+				return
+			}
+			s.handleNotification(msg.Method, msg.Params)
+			continue
+		}
+
+		// Requests are answered on their own goroutine, so the loop goes
+		// on reading the responses to requests made while handling them.
+		ctx, cancel := context.WithCancel(s.ctx)
+		s.mu.Lock()
+		s.inflight[*msg.ID] = cancel
+		s.mu.Unlock()
+		go func(id *int64, method string, params json.RawMessage) {
+			result, err := s.handleRequest(ctx, method, params)
+			s.mu.Lock()
+			delete(s.inflight, *id)
+			s.mu.Unlock()
+			cancel()
+			s.reply(id, result, err)
+		}(msg.ID, msg.Method, msg.Params)
+	}
+}
+
+func (s *session) handleRequest(ctx context.Context, method string, params json.RawMessage) (json.RawMessage, error) {
+	switch method {
+	case "initialize":
+		return json.Marshal(lsp.InitializeResult{Capabilities: s.backend.Capabilities()})
+	case "shutdown":
+		return json.RawMessage("null"), nil
+	case "workspace/executeCommand":
+		// Commands from other sessions wait, so the edits a command asks
+		// for go to the session that ran it.
+		remove, err := s.backend.HandleApplyEdit(ctx, s.applyEdit)
+		if err != nil {
+			return nil, err
+		}
+		defer remove()
+		return s.backend.Call(ctx, method, params)
+	default:
+		return s.backend.Call(ctx, method, params)
+	}
+}
+
+func (s *session) handleNotification(method string, params json.RawMessage) {
+	switch method {
+	case "initialized":
+		if err := s.backend.SyncOpenDocuments(s.ctx); err != nil {
+			s.logf("sync open documents: %v", err)
+		}
+		s.attach()
+
+	case "textDocument/didOpen":
+		var p lsp.DidOpenTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return
+		}
+		item := p.TextDocument
+		s.open(item.URI, item.LanguageID, item.Text)
+
+	case "textDocument/didChange":
+		// lsp-cli always sends the full text, so a change is a re-open
+		// and the backend keeps track of the version.
+		var p lsp.DidChangeTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil || len(p.ContentChanges) == 0 {
+			return
+		}
+		s.open(p.TextDocument.URI, "", p.ContentChanges[len(p.ContentChanges)-1].Text)
+
+	case "textDocument/didClose":
+		// The document stays open on the backend while it is idle, so the
+		// next invocation finds it warm; it re-reads it from disk when it
+		// attaches.
+		var p lsp.DidCloseTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return
+		}
+		s.mu.Lock()
+		opened := s.opened[p.TextDocument.URI]
+		delete(s.opened, p.TextDocument.URI)
+		s.mu.Unlock()
+		if opened {
+			if err := s.docs.release(s.ctx, p.TextDocument.URI); err != nil {
+				s.logf("close %s: %v", p.TextDocument.URI, err)
+			}
+		}
+
+	case "$/cancelRequest":
+		var p lsp.CancelParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return
+		}
+		s.mu.Lock()
+		cancel, ok := s.inflight[p.ID]
+		s.mu.Unlock()
+		if ok {
+			cancel()
+		}
+
+	default:
+		if err := s.backend.Notify(s.ctx, method, params); err != nil {
+			s.logf("forward %s: %v", method, err)
+		}
+	}
+}
+
+// open opens or updates a document on the backend. A document whose text
+// is unchanged will not be diagnosed again, so the session is sent what
+// the server last published for it.
+func (s *session) open(uri, languageID, text string) {
+	s.mu.Lock()
+	opened := s.opened[uri]
+	s.opened[uri] = true
+	s.mu.Unlock()
+	if !opened {
+		s.docs.acquire(uri)
+	}
+	if err := s.backend.OpenDocument(s.ctx, uri, languageID, text); err != nil {
+		s.logf("open %s: %v", uri, err)
+		return
+	}
+	if diags, ok := s.backend.CurrentDiagnostics(uri); ok {
+		s.notify("textDocument/publishDiagnostics", lsp.PublishDiagnosticsParams{
+			URI:         uri,
+			Diagnostics: diags,
+		})
+	}
+}
+
+// attach starts forwarding backend notifications. What the server published
+// before, and will not repeat, is sent to the session first: the current
+// diagnostics of every document and, if the backend finished loading, the
+// end of its progress.
+func (s *session) attach() {
+	// Held while replaying, so newer diagnostics are not overtaken by
+	// the replayed ones.
+	var replayMu sync.Mutex
+	replayMu.Lock()
+	s.removeListener = s.backend.AddNotificationListener(func(method string, params json.RawMessage) {
+		replayMu.Lock()
+		defer replayMu.Unlock()
+		s.notify(method, params)
+	})
+	for uri := range s.backend.AllDiagnostics() {
+		if diags, ok := s.backend.CurrentDiagnostics(uri); ok {
+			s.notify("textDocument/publishDiagnostics", lsp.PublishDiagnosticsParams{
+				URI:         uri,
+				Diagnostics: diags,
+			})
+		}
+	}
+	replayMu.Unlock()
+
+	if !s.backend.Ready() {
+		return
+	}
+	s.notify("$/progress", map[string]interface{}{
+		"token": "lsp-cli/daemon",
+		"value": map[string]string{"kind": "end"},
+	})
+}
+
+// applyEdit asks the session's client to apply an edit the server sent.
+func (s *session) applyEdit(p lsp.ApplyWorkspaceEditParams) error {
+	result, err := s.call("workspace/applyEdit", p)
+	if err != nil {
+		return err
+	}
+	var r lsp.ApplyWorkspaceEditResult
+	if err := json.Unmarshal(result, &r); err != nil {
+		return fmt.Errorf("unmarshal applyEdit result: %w", err)
+	}
+	if !r.Applied {
+		if r.FailureReason == "" {
+			r.FailureReason = "edit not applied"
+		}
+		return errors.New(r.FailureReason)
+	}
+	return nil
+}
+
+// call sends a request to the session's client and waits for its response.
+func (s *session) call(method string, params interface{}) (json.RawMessage, error) {
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("marshal params: %w", err)
+	}
+	id := s.nextID.Add(1)
+	data, err := json.Marshal(lsp.Request{
+		JSONRPC: "2.0",
+		ID:      &id,
+		Method:  method,
+		Params:  paramsJSON,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	ch := make(chan *lsp.Response, 1)
+	s.mu.Lock()
+	s.pending[id] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, id)
+		s.mu.Unlock()
+	}()
+
+	if err := s.transport.WriteMessage(data); err != nil {
+		return nil, fmt.Errorf("write request: %w", err)
+	}
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		return resp.Result, nil
+	case <-s.ctx.Done():
+		return nil, errors.New("session closed")
+	}
+}
+
+// handleResponse hands a response from the session's client to the call
+// waiting for it.
+func (s *session) handleResponse(data []byte) {
+	var resp lsp.Response
+	if err := json.Unmarshal(data, &resp); err != nil || resp.ID == nil {
+		return
+	}
+	s.mu.Lock()
+	ch, ok := s.pending[*resp.ID]
+	s.mu.Unlock()
+	if ok {
+		ch <- &resp
+	}
+}
+
+func (s *session) notify(method string, params interface{}) {
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(lsp.Request{
+		JSONRPC: "2.0",
+		Method:  method,
+		Params:  paramsJSON,
+	})
+	if err != nil {
+		return
+	}
+	s.transport.WriteMessage(data)
+}
+
+func (s *session) reply(id *int64, result json.RawMessage, err error) {
+	resp := lsp.Response{JSONRPC: "2.0", ID: id}
+	if err != nil {
+		var rerr *lsp.ResponseError
+		switch {
+		case errors.As(err, &rerr):
+		case errors.Is(err, context.Canceled):
+			rerr = &lsp.ResponseError{Code: lsp.CodeRequestCancelled, Message: "request cancelled"}
+		default:
+			rerr = &lsp.ResponseError{Code: lsp.CodeInternalError, Message: err.Error()}
+		}
+		resp.Error = rerr
+	} else {
+		if len(result) == 0 {
+			result = json.RawMessage("null")
+		}
+		resp.Result = result
+	}
+
+	data, merr := json.Marshal(resp)
+	if merr != nil {
+		return
+	}
+	s.transport.WriteMessage(data)
+}
+
+func (s *session) logf(format string, args ...interface{}) {
+	if s.verbose {
+		fmt.Fprintf(os.Stderr, "daemon: "+format+"\n", args...)
+	}
+}
@@ -0,0 +1,341 @@
+// Package diff computes line diffs and renders them as unified diffs.
+//
+// The edit script comes from Myers' O(ND) algorithm in its linear-space
+// form, so large inputs with few changes stay cheap. Output follows the
+// format accepted by patch and git apply, including the
+// "\ No newline at end of file" marker.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultContext is the number of unchanged lines shown around each change.
+const DefaultContext = 3
+
+// OpKind is the kind of a line operation in an edit script.
+type OpKind int
+
+const (
+	Equal OpKind = iota
+	Delete
+	Insert
+)
+
+// Op is one line of an edit script. OldLine and NewLine are 0-indexed
+// positions in the old and new line slices; only the ones meaningful for
+// the kind are set (Equal sets both).
+type Op struct {
+	Kind    OpKind
+	OldLine int
+	NewLine int
+}
+
+// Lines splits text into lines, keeping each line's "\n" terminator.
+// A final line without a terminator is kept as is.
+func Lines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Compute returns a shortest edit script turning a into b. Deletions are
+// ordered before insertions within each changed region.
+func Compute(a, b []string) []Op {
+	d := &differ{
+		a:        a,
+		b:        b,
+		deleted:  make([]bool, len(a)),
+		inserted: make([]bool, len(b)),
+	}
+	d.compare(0, len(a), 0, len(b))
+
+	ops := make([]Op, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && d.deleted[i]:
+			ops = append(ops, Op{Kind: Delete, OldLine: i, NewLine: j})
+			i++
+		case j < len(b) && d.inserted[j]:
+			ops = append(ops, Op{Kind: Insert, OldLine: i, NewLine: j})
+			j++
+		default:
+			ops = append(ops, Op{Kind: Equal, OldLine: i, NewLine: j})
+			i++
+			j++
+		}
+	}
+	return ops
+}
+
+// Unified returns a unified diff turning oldText into newText, with the
+// given number of context lines, or "" if the texts are equal. Labels are
+// used verbatim in the ---/+++ header lines.
+func Unified(oldLabel, newLabel, oldText, newText string, context int) string {
+	if oldText == newText {
+		return ""
+	}
+	a, b := Lines(oldText), Lines(newText)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n", oldLabel)
+	fmt.Fprintf(&sb, "+++ %s\n", newLabel)
+	for _, h := range hunks(Compute(a, b), context) {
+		writeHunk(&sb, a, b, h)
+	}
+	return sb.String()
+}
+
+// hunk is a run of ops rendered under one @@ header.
+type hunk struct {
+	ops []Op
+}
+
+// hunks groups changes into hunks, merging changes whose context would
+// touch or overlap.
+func hunks(ops []Op, context int) []hunk {
+	if context < 0 {
+		context = 0
+	}
+
+	var out []hunk
+	i := 0
+	for i < len(ops) {
+		// Find the next change.
+		for i < len(ops) && ops[i].Kind == Equal {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+
+		// The previous hunk ended more than context lines back, or the
+		// two would have been merged, so this cannot overlap it.
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend over changes separated by at most 2*context equal lines.
+		end := i
+		for {
+			for end < len(ops) && ops[end].Kind != Equal {
+				end++
+			}
+			run := 0
+			for end+run < len(ops) && ops[end+run].Kind == Equal {
+				run++
+			}
+			if end+run < len(ops) && run <= 2*context {
+				end += run
+				continue
+			}
+			if run > context {
+				run = context
+			}
+			end += run
+			break
+		}
+
+		out = append(out, hunk{ops: ops[start:end]})
+		i = end
+	}
+	return out
+}
+
+func writeHunk(sb *strings.Builder, a, b []string, h hunk) {
+	oldStart, newStart := -1, -1
+	oldCount, newCount := 0, 0
+	for _, op := range h.ops {
+		if op.Kind != Insert {
+			if oldStart < 0 {
+				oldStart = op.OldLine
+			}
+			oldCount++
+		}
+		if op.Kind != Delete {
+			if newStart < 0 {
+				newStart = op.NewLine
+			}
+			newCount++
+		}
+	}
+	// An empty side is addressed by the line before it.
+	if oldStart < 0 {
+		oldStart = h.ops[0].OldLine - 1
+	}
+	if newStart < 0 {
+		newStart = h.ops[0].NewLine - 1
+	}
+
+	fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+	for _, op := range h.ops {
+		switch op.Kind {
+		case Equal:
+			writeLine(sb, ' ', a[op.OldLine])
+		case Delete:
+			writeLine(sb, '-', a[op.OldLine])
+		case Insert:
+			writeLine(sb, '+', b[op.NewLine])
+		}
+	}
+}
+
+// hunkRange formats a 0-indexed start and count as "start,count" with a
+// 1-indexed start, omitting the count when it is 1 as diff and git do.
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeLine(sb *strings.Builder, prefix byte, line string) {
+	sb.WriteByte(prefix)
+	sb.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		sb.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// differ marks the lines of a deleted and of b inserted by a shortest
+// edit script, found by recursive bisection on middle snakes.
+type differ struct {
+	a, b     []string
+	deleted  []bool
+	inserted []bool
+}
+
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	if aLo == aHi {
+		for j := bLo; j < bHi; j++ {
+			d.inserted[j] = true
+		}
+		return
+	}
+	if bLo == bHi {
+		for i := aLo; i < aHi; i++ {
+			d.deleted[i] = true
+		}
+		return
+	}
+
+	sx, sy, fx, fy, ok := d.middleSnake(aLo, aHi, bLo, bHi)
+	if !ok {
+		// Not reachable for a valid box; replace it wholesale.
+		for i := aLo; i < aHi; i++ {
+			d.deleted[i] = true
+		}
+		for j := bLo; j < bHi; j++ {
+			d.inserted[j] = true
+		}
+		return
+	}
+	d.compare(aLo, sx, bLo, sy)
+	d.walkSnake(sx, sy, fx, fy)
+	d.compare(fx, aHi, fy, bHi)
+}
+
+// walkSnake marks the single edit inside a snake, which is surrounded by
+// equal lines on either side.
+func (d *differ) walkSnake(x, y, fx, fy int) {
+	for x < fx && y < fy && d.a[x] == d.b[y] {
+		x++
+		y++
+	}
+	switch {
+	case fx-x > fy-y:
+		d.deleted[x] = true
+	case fy-y > fx-x:
+		d.inserted[y] = true
+	}
+}
+
+// middleSnake runs the forward and backward searches until they overlap
+// and returns the start and end of the overlapping snake. The box is known
+// to be non-empty on both sides with differing first and last lines, so
+// the snake always contains exactly one edit.
+func (d *differ) middleSnake(left, right, top, bottom int) (sx, sy, fx, fy int, ok bool) {
+	width, height := right-left, bottom-top
+	max := (width + height + 1) / 2
+	delta := width - height
+	off := max + 1
+
+	vf := make([]int, 2*max+3) // furthest x on each forward diagonal k
+	vb := make([]int, 2*max+3) // furthest y on each backward diagonal c
+	vf[off+1] = left
+	vb[off+1] = bottom
+
+	for dd := 0; dd <= max; dd++ {
+		// Forward: diagonals k = x - left - (y - top).
+		for k := dd; k >= -dd; k -= 2 {
+			var px, x int
+			if k == -dd || (k != dd && vf[off+k-1] < vf[off+k+1]) {
+				x = vf[off+k+1]
+				px = x
+			} else {
+				px = vf[off+k-1]
+				x = px + 1
+			}
+			y := top + (x - left) - k
+			py := y
+			if dd != 0 && x == px {
+				py = y - 1
+			}
+			for x < right && y < bottom && d.a[x] == d.b[y] {
+				x++
+				y++
+			}
+			vf[off+k] = x
+
+			c := k - delta
+			if delta%2 != 0 && c >= -(dd-1) && c <= dd-1 && y >= vb[off+c] {
+				return px, py, x, y, true
+			}
+		}
+
+		// Backward: diagonals c = k - delta, walking from the bottom right.
+		for c := dd; c >= -dd; c -= 2 {
+			var py, y int
+			if c == -dd || (c != dd && vb[off+c-1] > vb[off+c+1]) {
+				y = vb[off+c+1]
+				py = y
+			} else {
+				py = vb[off+c-1]
+				y = py - 1
+			}
+			k := c + delta
+			x := left + (y - top) + k
+			px := x
+			if dd != 0 && y == py {
+				px = x + 1
+			}
+			for x > left && y > top && d.a[x-1] == d.b[y-1] {
+				x--
+				y--
+			}
+			vb[off+c] = y
+
+			if delta%2 == 0 && k >= -dd && k <= dd && x <= vf[off+k] {
+				return x, y, px, py, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
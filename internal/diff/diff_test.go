@@ -0,0 +1,146 @@
+package diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		context  int
+		want     string
+	}{
+		{
+			name:    "equal",
+			old:     "a\nb\n",
+			new:     "a\nb\n",
+			context: DefaultContext,
+			want:    "",
+		},
+		{
+			name:    "change in the middle",
+			old:     "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			new:     "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			context: DefaultContext,
+			want:    "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name:    "changes far apart get their own hunks",
+			old:     "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			new:     "one\n2\n3\n4\n5\n6\n7\n8\nnine\n",
+			context: 1,
+			want:    "--- a\n+++ b\n@@ -1,2 +1,2 @@\n-1\n+one\n 2\n@@ -8,2 +8,2 @@\n 8\n-9\n+nine\n",
+		},
+		{
+			name:    "changes close together share a hunk",
+			old:     "1\n2\n3\n4\n5\n",
+			new:     "one\n2\n3\nfour\n5\n",
+			context: 1,
+			want:    "--- a\n+++ b\n@@ -1,5 +1,5 @@\n-1\n+one\n 2\n 3\n-4\n+four\n 5\n",
+		},
+		{
+			name:    "insert at start",
+			old:     "a\n",
+			new:     "new\na\n",
+			context: 0,
+			want:    "--- a\n+++ b\n@@ -0,0 +1 @@\n+new\n",
+		},
+		{
+			name:    "delete at end",
+			old:     "a\nb\n",
+			new:     "a\n",
+			context: 0,
+			want:    "--- a\n+++ b\n@@ -2 +1,0 @@\n-b\n",
+		},
+		{
+			name:    "new file",
+			old:     "",
+			new:     "a\nb\n",
+			context: DefaultContext,
+			want:    "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:    "missing final newline",
+			old:     "a\nb",
+			new:     "a\nb\n",
+			context: DefaultContext,
+			want:    "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := Unified("a", "b", tt.old, tt.new, tt.context); got != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// lcs returns the length of the longest common subsequence of a and b.
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// TestComputeRandom checks on random inputs that the edit script turns a
+// into b and is as short as possible.
+func TestComputeRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randLines := func() []string {
+		lines := make([]string, rng.Intn(30))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return lines
+	}
+
+	for n := 0; n < 2000; n++ {
+		a, b := randLines(), randLines()
+		ops := Compute(a, b)
+
+		var got []string
+		edits, i, j := 0, 0, 0
+		for _, op := range ops {
+			switch op.Kind {
+			case Equal:
+				if op.OldLine != i || op.NewLine != j || a[i] != b[j] {
+					t.Fatalf("%q -> %q: bad equal op %+v", a, b, op)
+				}
+				got = append(got, a[i])
+				i++
+				j++
+			case Delete:
+				if op.OldLine != i {
+					t.Fatalf("%q -> %q: bad delete op %+v", a, b, op)
+				}
+				edits++
+				i++
+			case Insert:
+				if op.NewLine != j {
+					t.Fatalf("%q -> %q: bad insert op %+v", a, b, op)
+				}
+				got = append(got, b[j])
+				edits++
+				j++
+			}
+		}
+		if i != len(a) || strings.Join(got, "") != strings.Join(b, "") {
+			t.Fatalf("%q -> %q: script produces %q", a, b, got)
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); edits != want {
+			t.Fatalf("%q -> %q: %d edits, want %d", a, b, edits, want)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DevNull is the path a patch uses for the missing side of a created or
+// deleted file.
+const DevNull = "/dev/null"
+
+// FilePatch is the part of a unified diff that applies to one file.
+type FilePatch struct {
+	OldPath string // from the "---" line, "" if the patch has no header
+	NewPath string // from the "+++" line
+	Hunks   []Hunk
+}
+
+// Hunk is one @@ section of a unified diff.
+type Hunk struct {
+	Header   string // the @@ line
+	Line     int    // line of the header in the patch, from 1
+	OldStart int    // 1-indexed; for an empty old side, the line it follows
+	OldCount int
+	NewStart int
+	NewCount int
+
+	// Body lines, each starting with ' ', '-' or '+'.
+	Lines []string
+
+	// Set by "\ No newline at end of file" after an old or new line.
+	OldNoNewline bool
+	NewNoNewline bool
+}
+
+// Parse parses a unified diff, with or without ---/+++ file headers, as
+// written by diff -u, git diff and most tools. Text outside of file headers
+// and hunks (commit messages, "diff --git" and "index" lines) is skipped.
+func Parse(patch string) ([]FilePatch, error) {
+	lines := strings.Split(patch, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var files []FilePatch
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			files = append(files, FilePatch{
+				OldPath: headerPath(line[4:]),
+				NewPath: headerPath(strings.TrimSuffix(lines[i+1], "\r")[4:]),
+			})
+			i++
+
+		case strings.HasPrefix(line, "@@ "):
+			h, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("patch line %d: %w", i+1, err)
+			}
+			h.Line = i + 1
+
+			oldLeft, newLeft := h.OldCount, h.NewCount
+			for oldLeft > 0 || newLeft > 0 {
+				i++
+				if i >= len(lines) {
+					return nil, fmt.Errorf("patch line %d: hunk %s ends early (%d old and %d new lines missing)",
+						h.Line, h.Header, oldLeft, newLeft)
+				}
+				body := lines[i]
+				if body == "" || body == "\r" {
+					body = " " // context line whose space was stripped
+				}
+				switch body[0] {
+				case ' ':
+					oldLeft--
+					newLeft--
+				case '-':
+					oldLeft--
+				case '+':
+					newLeft--
+				case '\\':
+					markNoNewline(&h)
+					continue
+				default:
+					return nil, fmt.Errorf("patch line %d: hunk %s ends early (%d old and %d new lines missing)",
+						i+1, h.Header, oldLeft, newLeft)
+				}
+				if oldLeft < 0 || newLeft < 0 {
+					return nil, fmt.Errorf("patch line %d: hunk %s has more lines than its header says", i+1, h.Header)
+				}
+				h.Lines = append(h.Lines, body)
+			}
+			if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\\") {
+				i++
+				markNoNewline(&h)
+			}
+
+			if len(files) == 0 {
+				files = append(files, FilePatch{})
+			}
+			f := &files[len(files)-1]
+			f.Hunks = append(f.Hunks, h)
+		}
+	}
+
+	for _, f := range files {
+		if len(f.Hunks) == 0 {
+			return nil, fmt.Errorf("patch for %s has no hunks", f.NewPath)
+		}
+	}
+	return files, nil
+}
+
+// headerPath extracts the path from a ---/+++ header, dropping a trailing
+// timestamp and unquoting a C-style quoted name.
+func headerPath(s string) string {
+	if strings.HasPrefix(s, `"`) {
+		if end := strings.LastIndex(s, `"`); end > 0 {
+			if p, err := strconv.Unquote(s[:end+1]); err == nil {
+				return p
+			}
+		}
+	}
+	if tab := strings.IndexByte(s, '\t'); tab >= 0 {
+		s = s[:tab]
+	}
+	return strings.TrimSpace(s)
+}
+
+// markNoNewline records a "\ No newline at end of file" marker against
+// the side(s) of the body line it follows.
+func markNoNewline(h *Hunk) {
+	if len(h.Lines) == 0 {
+		return
+	}
+	switch h.Lines[len(h.Lines)-1][0] {
+	case ' ':
+		h.OldNoNewline = true
+		h.NewNoNewline = true
+	case '-':
+		h.OldNoNewline = true
+	case '+':
+		h.NewNoNewline = true
+	}
+}
+
+// parseHunkHeader parses "@@ -l[,s] +l[,s] @@ [section]".
+func parseHunkHeader(line string) (Hunk, error) {
+	end := strings.Index(line[3:], " @@")
+	if end < 0 {
+		return Hunk{}, fmt.Errorf("malformed hunk header %q", line)
+	}
+	fields := strings.Fields(line[3 : 3+end])
+	if len(fields) != 2 || !strings.HasPrefix(fields[0], "-") || !strings.HasPrefix(fields[1], "+") {
+		return Hunk{}, fmt.Errorf("malformed hunk header %q", line)
+	}
+
+	h := Hunk{Header: line[:3+end+3]}
+	var err error
+	if h.OldStart, h.OldCount, err = parseRange(fields[0][1:]); err != nil {
+		return Hunk{}, fmt.Errorf("malformed hunk header %q: %w", line, err)
+	}
+	if h.NewStart, h.NewCount, err = parseRange(fields[1][1:]); err != nil {
+		return Hunk{}, fmt.Errorf("malformed hunk header %q: %w", line, err)
+	}
+	return h, nil
+}
+
+func parseRange(s string) (start, count int, err error) {
+	count = 1
+	if comma := strings.IndexByte(s, ','); comma >= 0 {
+		if count, err = strconv.Atoi(s[comma+1:]); err != nil {
+			return 0, 0, err
+		}
+		s = s[:comma]
+	}
+	if start, err = strconv.Atoi(s); err != nil {
+		return 0, 0, err
+	}
+	if start < 0 || count < 0 {
+		return 0, 0, fmt.Errorf("negative range")
+	}
+	return start, count, nil
+}
+
+// Options controls how tolerant ApplyHunks is of a patch that does not
+// quite match the file.
+type Options struct {
+	// Fuzz is the number of context lines that may be ignored at each
+	// end of a hunk when it does not match in full, as in patch -F.
+	Fuzz int
+	// MaxOffset is how many lines a hunk may be found away from the
+	// position its header states. Negative means anywhere in the file.
+	MaxOffset int
+}
+
+// HunkResult records where a hunk was applied.
+type HunkResult struct {
+	Hunk   int // 1-indexed position in the file's patch
+	Line   int // 1-indexed line at which the hunk was applied
+	Offset int // lines away from the position the header states
+	Fuzz   int // context lines ignored at each end
+}
+
+// HunkError explains why a hunk could not be applied.
+type HunkError struct {
+	Hunk   int
+	Header string
+	Reason string
+}
+
+func (e *HunkError) Error() string {
+	return fmt.Sprintf("hunk %d (%s) failed: %s", e.Hunk, e.Header, e.Reason)
+}
+
+// ApplyHunks applies hunks in order to lines, which hold the file content
+// without line terminators, and returns the new lines. Each hunk is looked
+// for at its stated position (shifted by the hunks before it), then at
+// growing distances up to MaxOffset, then again ignoring up to Fuzz
+// context lines at its ends. Every hunk is tried; if any fails the error
+// joins a *HunkError for each failure.
+func ApplyHunks(lines []string, hunks []Hunk, opts Options) ([]string, []HunkResult, error) {
+	out := append([]string(nil), lines...)
+	var results []HunkResult
+	var errs []error
+
+	delta := 0  // lines added minus lines removed by earlier hunks
+	drift := 0  // offset at which the previous hunk was found
+	minPos := 0 // hunks may not overlap the one before
+	for i, h := range hunks {
+		oldBlock, newBlock := h.sides()
+		stated := h.OldStart - 1 + delta
+		if h.OldCount == 0 {
+			stated = h.OldStart + delta // insert after line OldStart
+		}
+		expected := stated + drift
+
+		// The hunk's old text is found at out[at:], less pre leading and
+		// suf trailing context lines ignored under fuzz.
+		at, fuzz, pre, suf := -1, 0, 0, 0
+		if len(oldBlock) == 0 {
+			if expected < minPos || expected > len(out) {
+				errs = append(errs, &HunkError{i + 1, h.Header,
+					fmt.Sprintf("insertion point after line %d is outside the file (%d lines)", expected, len(out))})
+				continue
+			}
+			at = expected
+		} else {
+			lead, trail := h.context()
+			prevPre, prevSuf := -1, -1
+			for f := 0; f <= opts.Fuzz; f++ {
+				p, s := min(f, lead), min(f, trail)
+				if p == prevPre && s == prevSuf {
+					break // no more context to ignore
+				}
+				prevPre, prevSuf = p, s
+				if p+s >= len(oldBlock) {
+					break
+				}
+				if at = find(out, oldBlock[p:len(oldBlock)-s], expected+p, minPos, opts.MaxOffset); at >= 0 {
+					fuzz, pre, suf = f, p, s
+					break
+				}
+			}
+			if at < 0 {
+				errs = append(errs, &HunkError{i + 1, h.Header, mismatch(out, oldBlock, expected, minPos, opts.MaxOffset)})
+				continue
+			}
+		}
+
+		// Ignored context stays in place; replace the rest.
+		oldBlock = oldBlock[pre : len(oldBlock)-suf]
+		newBlock = newBlock[pre : len(newBlock)-suf]
+		replaced := append([]string(nil), out[:at]...)
+		replaced = append(replaced, newBlock...)
+		replaced = append(replaced, out[at+len(oldBlock):]...)
+		out = replaced
+
+		drift = at - pre - stated
+		results = append(results, HunkResult{Hunk: i + 1, Line: max(at-pre, 0) + 1, Offset: drift, Fuzz: fuzz})
+		delta += len(newBlock) - len(oldBlock)
+		minPos = at + len(newBlock)
+	}
+
+	if len(errs) > 0 {
+		return nil, results, errors.Join(errs...)
+	}
+	return out, results, nil
+}
+
+// sides returns the old and new text of the hunk, without prefixes.
+func (h Hunk) sides() (old, new []string) {
+	for _, l := range h.Lines {
+		switch l[0] {
+		case ' ':
+			old = append(old, l[1:])
+			new = append(new, l[1:])
+		case '-':
+			old = append(old, l[1:])
+		case '+':
+			new = append(new, l[1:])
+		}
+	}
+	return old, new
+}
+
+// context returns the number of context lines before the first change
+// and after the last.
+func (h Hunk) context() (lead, trail int) {
+	for lead < len(h.Lines) && h.Lines[lead][0] == ' ' {
+		lead++
+	}
+	for trail < len(h.Lines)-lead && h.Lines[len(h.Lines)-1-trail][0] == ' ' {
+		trail++
+	}
+	return lead, trail
+}
+
+// find returns the position nearest expected, at or after minPos and at
+// most maxOffset away (any distance if negative), where block occurs in
+// lines, or -1.
+func find(lines, block []string, expected, minPos, maxOffset int) int {
+	last := len(lines) - len(block)
+	for d := 0; maxOffset < 0 || d <= maxOffset; d++ {
+		fwd, back := expected+d, expected-d
+		if fwd > last && back < minPos {
+			return -1
+		}
+		if fwd >= minPos && fwd <= last && matchAt(lines, block, fwd) {
+			return fwd
+		}
+		if d > 0 && back >= minPos && back <= last && matchAt(lines, block, back) {
+			return back
+		}
+	}
+	return -1
+}
+
+func matchAt(lines, block []string, pos int) bool {
+	for i, l := range block {
+		if lines[pos+i] != l {
+			return false
+		}
+	}
+	return true
+}
+
+// mismatch describes why block was not found: the closest candidate
+// within range and the first line at which it differs.
+func mismatch(lines, block []string, expected, minPos, maxOffset int) string {
+	lo, hi := minPos, len(lines)-len(block)
+	if maxOffset >= 0 {
+		lo = max(lo, expected-maxOffset)
+		hi = min(hi, expected+maxOffset)
+	}
+	where := "anywhere in the file"
+	if maxOffset >= 0 {
+		where = fmt.Sprintf("within %d lines of line %d", maxOffset, expected+1)
+	}
+	if lo > hi {
+		return fmt.Sprintf("its %d old lines do not fit %s (file has %d lines)", len(block), where, len(lines))
+	}
+
+	best, bestScore := -1, -1
+	for pos := lo; pos <= hi; pos++ {
+		score := 0
+		for i, l := range block {
+			if lines[pos+i] == l {
+				score++
+			}
+		}
+		if score > bestScore || (score == bestScore && abs(pos-expected) < abs(best-expected)) {
+			best, bestScore = pos, score
+		}
+	}
+	for i, l := range block {
+		if got := lines[best+i]; got != l {
+			return fmt.Sprintf("old text not found %s; closest match at line %d differs at line %d: expected %q, found %q",
+				where, best+1, best+i+1, l, got)
+		}
+	}
+	return fmt.Sprintf("old text not found %s", where)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,240 @@
+package diff
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	patch := "commit message\n" +
+		"diff --git a/x.go b/x.go\n" +
+		"--- a/x.go\t2024-01-01 00:00:00\n" +
+		"+++ b/x.go\t2024-01-02 00:00:00\n" +
+		"@@ -1,2 +1,2 @@ func main() {\n" +
+		" a\n" +
+		"-b\n" +
+		"\\ No newline at end of file\n" +
+		"+B\n" +
+		"--- \"a/with space.go\"\n" +
+		"+++ /dev/null\n" +
+		"@@ -3 +2,0 @@\n" +
+		"-c\n"
+	files, err := Parse(patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d files, want 2", len(files))
+	}
+
+	f := files[0]
+	if f.OldPath != "a/x.go" || f.NewPath != "b/x.go" || len(f.Hunks) != 1 {
+		t.Fatalf("file 1 = %+v", f)
+	}
+	h := f.Hunks[0]
+	if h.Header != "@@ -1,2 +1,2 @@" || h.Line != 5 || h.OldStart != 1 || h.OldCount != 2 || h.NewStart != 1 || h.NewCount != 2 {
+		t.Errorf("hunk 1 = %+v", h)
+	}
+	if got := strings.Join(h.Lines, "|"); got != " a|-b|+B" {
+		t.Errorf("hunk 1 lines = %q", got)
+	}
+	if !h.OldNoNewline || h.NewNoNewline {
+		t.Errorf("hunk 1 no-newline markers = %v, %v; want old only", h.OldNoNewline, h.NewNoNewline)
+	}
+
+	f = files[1]
+	if f.OldPath != "a/with space.go" || f.NewPath != DevNull {
+		t.Errorf("file 2 paths = %q, %q", f.OldPath, f.NewPath)
+	}
+	if h := f.Hunks[0]; h.OldStart != 3 || h.OldCount != 1 || h.NewStart != 2 || h.NewCount != 0 {
+		t.Errorf("hunk 2 = %+v", h)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		patch, want string
+	}{
+		{"@@ -1 +1\n", "malformed hunk header"},
+		{"@@ -x +1 @@\n-a\n+b\n", "malformed hunk header"},
+		{"@@ -1,2 +1,2 @@\n a\n", "ends early"},
+		{"@@ -1,2 +1,2 @@\n a\nnot a body line\n", "ends early"},
+		{"@@ -1,2 +1 @@\n a\n+b\n", "more lines than its header says"},
+		{"--- a\n+++ b\n", "has no hunks"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.patch)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.patch, err, tt.want)
+		}
+	}
+}
+
+func parseHunks(t *testing.T, patch string) []Hunk {
+	t.Helper()
+	files, err := Parse(patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files[0].Hunks
+}
+
+func TestApplyHunks(t *testing.T) {
+	file := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9"}
+	tests := []struct {
+		name    string
+		lines   []string
+		patch   string
+		opts    Options
+		want    string
+		results []HunkResult
+	}{
+		{
+			name:    "exact",
+			lines:   file,
+			patch:   "@@ -4,3 +4,3 @@\n 4\n-5\n+five\n 6\n",
+			want:    "1 2 3 4 five 6 7 8 9",
+			results: []HunkResult{{Hunk: 1, Line: 4}},
+		},
+		{
+			name:    "offset",
+			lines:   append([]string{"0a", "0b"}, file...),
+			patch:   "@@ -4,3 +4,3 @@\n 4\n-5\n+five\n 6\n",
+			opts:    Options{MaxOffset: -1},
+			want:    "0a 0b 1 2 3 4 five 6 7 8 9",
+			results: []HunkResult{{Hunk: 1, Line: 6, Offset: 2}},
+		},
+		{
+			name:    "later hunks follow the offset of earlier ones",
+			lines:   append([]string{"0"}, file...),
+			patch:   "@@ -1,2 +1,2 @@\n 1\n-2\n+two\n@@ -8,2 +8,2 @@\n 8\n-9\n+nine\n",
+			opts:    Options{MaxOffset: 1},
+			want:    "0 1 two 3 4 5 6 7 8 nine",
+			results: []HunkResult{{Hunk: 1, Line: 2, Offset: 1}, {Hunk: 2, Line: 9, Offset: 1}},
+		},
+		{
+			name:    "fuzz ignores changed context",
+			lines:   file,
+			patch:   "@@ -4,3 +4,3 @@\n four\n-5\n+five\n 6\n",
+			opts:    Options{Fuzz: 1},
+			want:    "1 2 3 4 five 6 7 8 9",
+			results: []HunkResult{{Hunk: 1, Line: 4, Fuzz: 1}},
+		},
+		{
+			name:    "insert into an empty file",
+			lines:   nil,
+			patch:   "@@ -0,0 +1,2 @@\n+a\n+b\n",
+			want:    "a b",
+			results: []HunkResult{{Hunk: 1, Line: 1}},
+		},
+		{
+			name:    "insert after a line",
+			lines:   file,
+			patch:   "@@ -9,0 +10 @@\n+10\n",
+			want:    "1 2 3 4 5 6 7 8 9 10",
+			results: []HunkResult{{Hunk: 1, Line: 10}},
+		},
+	}
+	for _, tt := range tests {
+		got, results, err := ApplyHunks(tt.lines, parseHunks(t, tt.patch), tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if s := strings.Join(got, " "); s != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, s, tt.want)
+		}
+		if len(results) != len(tt.results) {
+			t.Errorf("%s: results = %+v, want %+v", tt.name, results, tt.results)
+			continue
+		}
+		for i := range results {
+			if results[i] != tt.results[i] {
+				t.Errorf("%s: results = %+v, want %+v", tt.name, results, tt.results)
+				break
+			}
+		}
+	}
+}
+
+func TestApplyHunksFails(t *testing.T) {
+	file := []string{"1", "2", "3", "4", "5", "6", "7", "8", "9"}
+	tests := []struct {
+		name, patch string
+		opts        Options
+		want        []string
+	}{
+		{
+			name:  "beyond the allowed offset",
+			patch: "@@ -1,3 +1,3 @@\n 4\n-5\n+five\n 6\n",
+			opts:  Options{MaxOffset: 2},
+			want:  []string{"hunk 1", "old text not found within 2 lines of line 1"},
+		},
+		{
+			name:  "changed context without fuzz",
+			patch: "@@ -4,3 +4,3 @@\n four\n-5\n+five\n 6\n",
+			want:  []string{`expected "four", found "4"`},
+		},
+		{
+			name:  "changed line under fuzz",
+			patch: "@@ -4,3 +4,3 @@\n 4\n-FIVE\n+five\n 6\n",
+			opts:  Options{Fuzz: 2},
+			want:  []string{`expected "FIVE", found "5"`},
+		},
+		{
+			name:  "every failing hunk is reported",
+			patch: "@@ -1 +1 @@\n-x\n+X\n@@ -5 +5 @@\n-5\n+five\n@@ -9 +9 @@\n-y\n+Y\n",
+			want:  []string{"hunk 1", "hunk 3"},
+		},
+	}
+	for _, tt := range tests {
+		_, _, err := ApplyHunks(file, parseHunks(t, tt.patch), tt.opts)
+		var he *HunkError
+		if !errors.As(err, &he) {
+			t.Errorf("%s: error = %v, want a *HunkError", tt.name, err)
+			continue
+		}
+		for _, w := range tt.want {
+			if !strings.Contains(err.Error(), w) {
+				t.Errorf("%s: error = %q, want it to contain %q", tt.name, err, w)
+			}
+		}
+	}
+}
+
+// TestApplyUnifiedRandom checks that applying the diff between two random
+// texts to the first gives the second.
+func TestApplyUnifiedRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randLines := func() []string {
+		lines := make([]string, rng.Intn(40))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(5)))
+		}
+		return lines
+	}
+	text := func(lines []string) string {
+		if len(lines) == 0 {
+			return ""
+		}
+		return strings.Join(lines, "\n") + "\n"
+	}
+
+	for n := 0; n < 1000; n++ {
+		a, b := randLines(), randLines()
+		context := rng.Intn(4)
+		patch := Unified("a", "b", text(a), text(b), context)
+		if patch == "" {
+			continue
+		}
+		got, _, err := ApplyHunks(a, parseHunks(t, patch), Options{})
+		if err != nil {
+			t.Fatalf("%q -> %q with context %d: %v\n%s", a, b, context, err, patch)
+		}
+		if text(got) != text(b) {
+			t.Fatalf("%q -> %q with context %d: got %q\n%s", a, b, context, got, patch)
+		}
+	}
+}
@@ -1,27 +1,59 @@
 package lsp
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Client manages an LSP server process and provides typed methods for LSP requests.
 type Client struct {
-	cmd     *exec.Cmd
-	conn    *Conn
-	rootURI string
-	verbose bool
+	serverCmd []string           // nil when connected over a stream (see NewClient)
+	stream    io.ReadWriteCloser // nil when the client started the server
+	rootURI   string
+	verbose   bool
+	stderr    *lineRing // the server's last stderr lines
+	serverLog io.Writer // also gets all of the server's stderr, if set
+	closed    atomic.Bool
+
+	// the current server process and connection, replaced by Restart
+	procMu       sync.Mutex
+	proc         *process // nil when connected over a stream
+	conn         *Conn
+	capabilities ServerCapabilities
+	restarts     []time.Time // of recent automatic restarts
+	restartCount int
+
+	restartMu   sync.Mutex // serializes restarts
+	autoRestart atomic.Bool
+	gaveUp      atomic.Bool // a crash was not followed by a restart
+
+	// documents opened with didOpen, so re-opening sends didChange instead
+	docsMu sync.Mutex
+	docs   map[string]*openDocument // URI -> document state
+
+	// additional notification listeners (see AddNotificationListener)
+	listenersMu  sync.Mutex
+	listeners    map[int]func(method string, params json.RawMessage)
+	nextListener int
+
+	// receiver of workspace/applyEdit requests (see HandleApplyEdit)
+	editSlot    chan struct{} // full while a receiver is set
+	editMu      sync.Mutex
+	editHandler func(ApplyWorkspaceEditParams) error
 
 	// diagnostics collected from publishDiagnostics notifications
 	diagMu      sync.Mutex
 	diagnostics map[string][]Diagnostic // URI -> diagnostics
-	diagCh      chan struct{}           // signaled when new diagnostics arrive
+	diagStale   map[string]bool         // URIs changed since their last diagnostics
+	diagCh      chan struct{}           // closed and replaced when diagnostics arrive
 
 	// progress tracking for server readiness
 	progressMu sync.Mutex
@@ -29,62 +61,99 @@ type Client struct {
 	progClosed bool
 }
 
-// StartClient spawns the language server and performs the initialize handshake.
-func StartClient(serverCmd []string, rootDir string, verbose bool) (*Client, error) {
+type openDocument struct {
+	languageID string
+	version    int
+	text       string
+}
+
+// shutdownTimeout bounds how long Close waits for the server to answer
+// shutdown before it is killed.
+const shutdownTimeout = 2 * time.Second
+
+// StartClient spawns the language server and performs the initialize
+// handshake, giving up when ctx is done. ctx only bounds the start; it is
+// not kept by the client. The server's stderr is written to serverLog if
+// it is not nil, and to os.Stderr if verbose.
+func StartClient(ctx context.Context, serverCmd []string, rootDir string, verbose bool, serverLog io.Writer) (*Client, error) {
 	absRoot, err := filepath.Abs(rootDir)
 	if err != nil {
 		return nil, fmt.Errorf("resolve root dir: %w", err)
 	}
 
-	cmd := exec.Command(serverCmd[0], serverCmd[1:]...)
-	if verbose {
-		cmd.Stderr = os.Stderr
+	c := newClient(absRoot, verbose)
+	c.serverCmd = serverCmd
+	c.stderr = newLineRing(stderrLines)
+	if serverLog != nil {
+		c.serverLog = &logWriter{w: serverLog}
+	}
+	if c.proc, c.conn, err = c.spawn(); err != nil {
+		return nil, err
 	}
-	cmd.Dir = absRoot
 
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, fmt.Errorf("stdin pipe: %w", err)
+	if c.capabilities, err = c.initialize(ctx, c.proc, c.conn); err != nil {
+		c.proc.stopping.Store(true)
+		c.conn.Close()
+		c.proc.cmd.Process.Kill()
+		<-c.proc.exited
+		return nil, c.withStderr(fmt.Errorf("initialize: %w", err))
 	}
-	stdout, err := cmd.StdoutPipe()
+	return c, nil
+}
+
+// NewClient performs the initialize handshake over an already-connected
+// stream, such as a socket to an lsp-cli daemon. Closing the client closes
+// the stream; no process is managed.
+func NewClient(ctx context.Context, rwc io.ReadWriteCloser, rootDir string, verbose bool) (*Client, error) {
+	absRoot, err := filepath.Abs(rootDir)
 	if err != nil {
-		return nil, fmt.Errorf("stdout pipe: %w", err)
+		return nil, fmt.Errorf("resolve root dir: %w", err)
 	}
 
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("start server %q: %w", serverCmd[0], err)
-	}
+	c := newClient(absRoot, verbose)
+	c.stream = rwc
+	c.conn = NewConn(NewTransport(rwc, rwc))
+	c.conn.NotificationHandler = c.handleNotification
+	c.conn.RequestHandler = c.handleRequest
 
-	transport := NewTransport(stdout, stdin)
-	conn := NewConn(transport)
+	if c.capabilities, err = c.initialize(ctx, nil, c.conn); err != nil {
+		c.conn.Close()
+		rwc.Close()
+		return nil, fmt.Errorf("initialize: %w", err)
+	}
+	return c, nil
+}
 
-	c := &Client{
-		cmd:         cmd,
-		conn:        conn,
+func newClient(absRoot string, verbose bool) *Client {
+	return &Client{
 		rootURI:     fileURI(absRoot),
 		verbose:     verbose,
+		docs:        make(map[string]*openDocument),
+		listeners:   make(map[int]func(string, json.RawMessage)),
 		diagnostics: make(map[string][]Diagnostic),
-		diagCh:      make(chan struct{}, 1),
+		diagStale:   make(map[string]bool),
+		diagCh:      make(chan struct{}),
 		progDone:    make(chan struct{}),
+		editSlot:    make(chan struct{}, 1),
 	}
-
-	// Handle server notifications
-	conn.NotificationHandler = c.handleNotification
-
-	// Initialize handshake
-	if err := c.initialize(); err != nil {
-		c.Close()
-		return nil, fmt.Errorf("initialize: %w", err)
-	}
-
-	return c, nil
 }
 
-func (c *Client) initialize() error {
+// initialize performs the initialize handshake over conn, the connection
+// to p (nil over a stream), and returns the server's capabilities. It does
+// not go through the current connection, so a restarted server is not
+// used by anyone else before it is initialized.
+func (c *Client) initialize(ctx context.Context, p *process, conn *Conn) (ServerCapabilities, error) {
 	params := InitializeParams{
 		ProcessID: os.Getpid(),
 		RootURI:   c.rootURI,
 		Capabilities: ClientCapabilities{
+			Workspace: &WorkspaceClientCapabilities{
+				ApplyEdit:     true,
+				Configuration: true,
+				WorkspaceEdit: &WorkspaceEditClientCapabilities{
+					DocumentChanges: true,
+				},
+			},
 			TextDocument: &TextDocumentClientCapabilities{
 				Definition: &DefinitionClientCapabilities{
 					LinkSupport: true,
@@ -99,32 +168,124 @@ func (c *Client) initialize() error {
 				Implementation: &ImplementationClientCapabilities{
 					LinkSupport: true,
 				},
+				TypeDefinition: &TypeDefinitionClientCapabilities{
+					LinkSupport: true,
+				},
+				Declaration: &DeclarationClientCapabilities{
+					LinkSupport: true,
+				},
 				PublishDiagnostics: &PublishDiagnosticsClientCapabilities{
 					RelatedInformation: true,
 				},
+				Rename: &RenameClientCapabilities{
+					PrepareSupport: true,
+				},
+				CodeAction: &CodeActionClientCapabilities{
+					CodeActionLiteralSupport: codeActionLiteralSupport(),
+					IsPreferredSupport:       true,
+					DisabledSupport:          true,
+					DataSupport:              true,
+					ResolveSupport: &ResolveSupport{
+						Properties: []string{"edit", "command"},
+					},
+				},
+				Completion: &CompletionClientCapabilities{
+					CompletionItem: &CompletionItemCapabilities{
+						DocumentationFormat: []string{"plaintext", "markdown"},
+						ResolveSupport: &ResolveSupport{
+							Properties: []string{"documentation", "detail"},
+						},
+					},
+				},
+				SignatureHelp: &SignatureHelpClientCapabilities{
+					SignatureInformation: &SignatureInformationCapabilities{
+						DocumentationFormat: []string{"plaintext", "markdown"},
+						ParameterInformation: &ParameterInformationCapabilities{
+							LabelOffsetSupport: true,
+						},
+						ActiveParameterSupport: true,
+					},
+				},
+				Formatting: &FormattingClientCapabilities{},
+				Diagnostic: &DiagnosticClientCapabilities{
+					RelatedDocumentSupport: true,
+				},
+				RangeFormatting: &RangeFormattingClientCapabilities{},
+				CallHierarchy:   &CallHierarchyClientCapabilities{},
+				TypeHierarchy:   &TypeHierarchyClientCapabilities{},
 			},
 		},
 	}
 
-	result, err := c.conn.Call("initialize", params)
+	result, err := c.callOn(ctx, p, conn, "initialize", params)
 	if err != nil {
-		return fmt.Errorf("initialize request: %w", err)
+		return ServerCapabilities{}, fmt.Errorf("initialize request: %w", err)
 	}
 
 	var initResult InitializeResult
 	if err := json.Unmarshal(result, &initResult); err != nil {
-		return fmt.Errorf("unmarshal initialize result: %w", err)
+		return ServerCapabilities{}, fmt.Errorf("unmarshal initialize result: %w", err)
 	}
 
 	// Send initialized notification
-	if err := c.conn.Notify("initialized", struct{}{}); err != nil {
-		return fmt.Errorf("initialized notification: %w", err)
+	if err := c.notifyOn(ctx, p, conn, "initialized", struct{}{}); err != nil {
+		return ServerCapabilities{}, fmt.Errorf("initialized notification: %w", err)
 	}
 
-	return nil
+	return initResult.Capabilities, nil
+}
+
+// codeActionLiteralSupport advertises every code action kind, so servers
+// send CodeAction literals rather than bare commands.
+func codeActionLiteralSupport() *CodeActionLiteralSupport {
+	lit := &CodeActionLiteralSupport{}
+	lit.CodeActionKind.ValueSet = []string{
+		"", "quickfix", "refactor", "refactor.extract", "refactor.inline",
+		"refactor.rewrite", "source", "source.organizeImports", "source.fixAll",
+	}
+	return lit
+}
+
+// Capabilities returns the capabilities the server reported in initialize.
+func (c *Client) Capabilities() ServerCapabilities {
+	c.procMu.Lock()
+	defer c.procMu.Unlock()
+	return c.capabilities
+}
+
+// AddNotificationListener registers fn to be called for every server
+// notification, after the client's own handling. The returned function
+// removes the listener.
+func (c *Client) AddNotificationListener(fn func(method string, params json.RawMessage)) func() {
+	c.listenersMu.Lock()
+	id := c.nextListener
+	c.nextListener++
+	c.listeners[id] = fn
+	c.listenersMu.Unlock()
+
+	return func() {
+		c.listenersMu.Lock()
+		delete(c.listeners, id)
+		c.listenersMu.Unlock()
+	}
 }
 
 func (c *Client) handleNotification(method string, params json.RawMessage) {
+	c.handleOwnNotification(method, params)
+
+	c.listenersMu.Lock()
+	fns := make([]func(string, json.RawMessage), 0, len(c.listeners))
+	for _, fn := range c.listeners {
+		fns = append(fns, fn)
+	}
+	c.listenersMu.Unlock()
+
+	for _, fn := range fns {
+		fn(method, params)
+	}
+}
+
+func (c *Client) handleOwnNotification(method string, params json.RawMessage) {
 	if c.verbose {
 		fmt.Fprintf(os.Stderr, "notification: %s\n", method)
 	}
@@ -135,19 +296,21 @@ func (c *Client) handleNotification(method string, params json.RawMessage) {
 		if err := json.Unmarshal(params, &p); err != nil {
 			return
 		}
-		c.diagMu.Lock()
-		c.diagnostics[p.URI] = p.Diagnostics
-		c.diagMu.Unlock()
+		// Diagnostics for an older version than the one last sent do not
+		// describe the document's current content.
+		current := true
+		if p.Version != nil {
+			c.docsMu.Lock()
+			if doc, ok := c.docs[p.URI]; ok && *p.Version < doc.version {
+				current = false
+			}
+			c.docsMu.Unlock()
+		}
+		c.setDiagnostics(p.URI, p.Diagnostics, current)
 
 		// Receiving diagnostics means the server has processed the file — signal ready
 		c.signalReady()
 
-		// Signal that new diagnostics arrived
-		select {
-		case c.diagCh <- struct{}{}:
-		default:
-		}
-
 	case "$/progress":
 		// Track work done progress — gopls uses this to signal loading completion
 		var prog struct {
@@ -169,11 +332,79 @@ func (c *Client) handleNotification(method string, params json.RawMessage) {
 		if val.Kind == "end" {
 			c.signalReady()
 		}
+	}
+}
+
+// HandleApplyEdit makes fn the receiver of the edits the server asks the
+// client to apply with workspace/applyEdit, usually while executing a
+// command. fn applies the edit or returns why it could not. While no
+// receiver is set, edits are refused. The returned function removes fn.
+//
+// The server does not say which command an edit belongs to, so there is
+// one receiver at a time: HandleApplyEdit waits until the previous one is
+// removed, or returns ctx's error if it is done first.
+func (c *Client) HandleApplyEdit(ctx context.Context, fn func(ApplyWorkspaceEditParams) error) (func(), error) {
+	select {
+	case c.editSlot <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	c.editMu.Lock()
+	c.editHandler = fn
+	c.editMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			c.editMu.Lock()
+			c.editHandler = nil
+			c.editMu.Unlock()
+			<-c.editSlot
+		})
+	}, nil
+}
+
+// handleRequest answers the requests servers send to the client. The
+// server blocks until it has a reply, so everything gets one: settings are
+// left at the server's defaults, progress tokens and capability
+// registrations are accepted, and anything else is MethodNotFound.
+func (c *Client) handleRequest(method string, params json.RawMessage) (interface{}, error) {
+	if c.verbose {
+		fmt.Fprintf(os.Stderr, "request: %s\n", method)
+	}
+
+	switch method {
+	case "workspace/configuration":
+		var p ConfigurationParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, fmt.Errorf("unmarshal configuration params: %w", err)
+		}
+		// null for each item means "use your defaults".
+		return make([]interface{}, len(p.Items)), nil
+
+	case "window/workDoneProgress/create",
+		"client/registerCapability",
+		"client/unregisterCapability":
+		// gopls creates a progress token before sending $/progress.
+		return nil, nil
 
-	case "window/workDoneProgress/create":
-		// Server is asking to create a progress token — acknowledge it
-		// (gopls sends this before $/progress)
+	case "workspace/applyEdit":
+		var p ApplyWorkspaceEditParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, fmt.Errorf("unmarshal applyEdit params: %w", err)
+		}
+		c.editMu.Lock()
+		fn := c.editHandler
+		c.editMu.Unlock()
+		if fn == nil {
+			return ApplyWorkspaceEditResult{FailureReason: "lsp-cli is not applying edits"}, nil
+		}
+		if err := fn(p); err != nil {
+			return ApplyWorkspaceEditResult{FailureReason: err.Error()}, nil
+		}
+		return ApplyWorkspaceEditResult{Applied: true}, nil
 	}
+	return nil, &ResponseError{Code: CodeMethodNotFound, Message: "method not supported: " + method}
 }
 
 // signalReady marks the server as ready (initial loading complete).
@@ -186,21 +417,38 @@ func (c *Client) signalReady() {
 	}
 }
 
+// NotificationStats returns the state of the queue of notifications from
+// the server waiting to be handled.
+func (c *Client) NotificationStats() NotificationStats {
+	_, conn := c.current()
+	return conn.NotificationStats()
+}
+
+// Ready reports whether the server has finished initial loading.
+func (c *Client) Ready() bool {
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	return c.progClosed
+}
+
 // WaitReady blocks until the server signals it has finished initial loading,
-// or until the timeout expires. Returns true if ready, false if timed out.
-func (c *Client) WaitReady(timeout time.Duration) bool {
-	timer := time.NewTimer(timeout)
-	defer timer.Stop()
+// or until ctx is done. Returns true if ready, false if it gave up.
+func (c *Client) WaitReady(ctx context.Context) bool {
+	c.progressMu.Lock()
+	done := c.progDone
+	c.progressMu.Unlock()
 	select {
-	case <-c.progDone:
+	case <-done:
 		return true
-	case <-timer.C:
+	case <-ctx.Done():
 		return false
 	}
 }
 
 // OpenFile sends textDocument/didOpen for the given file.
-func (c *Client) OpenFile(filePath string) (string, error) {
+// If the file is already open, its current disk content is sent with
+// textDocument/didChange instead.
+func (c *Client) OpenFile(ctx context.Context, filePath string) (string, error) {
 	absPath, err := filepath.Abs(filePath)
 	if err != nil {
 		return "", fmt.Errorf("resolve path: %w", err)
@@ -212,34 +460,115 @@ func (c *Client) OpenFile(filePath string) (string, error) {
 	}
 
 	uri := fileURI(absPath)
-	langID := detectLanguageID(absPath)
+	if err := c.OpenDocument(ctx, uri, detectLanguageID(absPath), string(content)); err != nil {
+		return "", err
+	}
+	return uri, nil
+}
+
+// OpenDocument opens a document with the given content. Re-opening a
+// document that is already open sends textDocument/didChange with the full
+// text, or nothing if the text is unchanged.
+func (c *Client) OpenDocument(ctx context.Context, uri, languageID, text string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.docsMu.Lock()
+	defer c.docsMu.Unlock()
+
+	if doc, ok := c.docs[uri]; ok {
+		if doc.text == text {
+			return nil
+		}
+		doc.version++
+		doc.text = text
+		c.markStale(uri)
+		params := DidChangeTextDocumentParams{
+			TextDocument: VersionedTextDocumentIdentifier{URI: uri, Version: doc.version},
+			ContentChanges: []TextDocumentContentChangeEvent{
+				{Text: text},
+			},
+		}
+		if err := c.notify(ctx, "textDocument/didChange", params); err != nil {
+			return fmt.Errorf("didChange: %w", err)
+		}
+		return nil
+	}
 
 	params := DidOpenTextDocumentParams{
 		TextDocument: TextDocumentItem{
 			URI:        uri,
-			LanguageID: langID,
+			LanguageID: languageID,
 			Version:    1,
-			Text:       string(content),
+			Text:       text,
 		},
 	}
+	c.markStale(uri)
 
-	if err := c.conn.Notify("textDocument/didOpen", params); err != nil {
-		return "", fmt.Errorf("didOpen: %w", err)
+	if err := c.notify(ctx, "textDocument/didOpen", params); err != nil {
+		return fmt.Errorf("didOpen: %w", err)
 	}
+	c.docs[uri] = &openDocument{languageID: languageID, version: 1, text: text}
 
-	return uri, nil
+	return nil
 }
 
 // CloseFile sends textDocument/didClose for the given URI.
-func (c *Client) CloseFile(uri string) error {
+func (c *Client) CloseFile(ctx context.Context, uri string) error {
+	c.docsMu.Lock()
+	delete(c.docs, uri)
+	c.docsMu.Unlock()
+
 	params := DidCloseTextDocumentParams{
 		TextDocument: TextDocumentIdentifier{URI: uri},
 	}
-	return c.conn.Notify("textDocument/didClose", params)
+	return c.notify(ctx, "textDocument/didClose", params)
+}
+
+// SyncOpenDocuments re-reads every open document from disk, so a client
+// kept open across edits does not answer from old text: documents whose
+// file changed are updated with didChange, and those whose file is gone
+// are closed.
+func (c *Client) SyncOpenDocuments(ctx context.Context) error {
+	c.docsMu.Lock()
+	uris := make([]string, 0, len(c.docs))
+	for uri := range c.docs {
+		uris = append(uris, uri)
+	}
+	c.docsMu.Unlock()
+
+	for _, uri := range uris {
+		path := URIToPath(uri)
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			if err := c.CloseFile(ctx, uri); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("read file: %w", err)
+		}
+		if err := c.OpenDocument(ctx, uri, detectLanguageID(path), string(content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Call sends an arbitrary request to the server and returns the raw result.
+// It is used by proxies that forward requests they do not interpret.
+func (c *Client) Call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	return c.call(ctx, method, params)
+}
+
+// Notify sends an arbitrary notification to the server.
+func (c *Client) Notify(ctx context.Context, method string, params interface{}) error {
+	return c.notify(ctx, method, params)
 }
 
 // Definition requests the definition of the symbol at the given position.
-func (c *Client) Definition(uri string, line, col int) ([]Location, error) {
+func (c *Client) Definition(ctx context.Context, uri string, line, col int) ([]Location, error) {
 	params := DefinitionParams{
 		TextDocumentPositionParams: TextDocumentPositionParams{
 			TextDocument: TextDocumentIdentifier{URI: uri},
@@ -251,7 +580,7 @@ func (c *Client) Definition(uri string, line, col int) ([]Location, error) {
 		fmt.Fprintf(os.Stderr, "definition request: uri=%s line=%d col=%d\n", uri, line, col)
 	}
 
-	result, err := c.conn.Call("textDocument/definition", params)
+	result, err := c.call(ctx, "textDocument/definition", params)
 	if err != nil {
 		return nil, err
 	}
@@ -264,7 +593,7 @@ func (c *Client) Definition(uri string, line, col int) ([]Location, error) {
 }
 
 // References requests all references to the symbol at the given position.
-func (c *Client) References(uri string, line, col int, includeDecl bool) ([]Location, error) {
+func (c *Client) References(ctx context.Context, uri string, line, col int, includeDecl bool) ([]Location, error) {
 	params := ReferenceParams{
 		TextDocumentPositionParams: TextDocumentPositionParams{
 			TextDocument: TextDocumentIdentifier{URI: uri},
@@ -275,7 +604,7 @@ func (c *Client) References(uri string, line, col int, includeDecl bool) ([]Loca
 		},
 	}
 
-	result, err := c.conn.Call("textDocument/references", params)
+	result, err := c.call(ctx, "textDocument/references", params)
 	if err != nil {
 		return nil, err
 	}
@@ -288,7 +617,7 @@ func (c *Client) References(uri string, line, col int, includeDecl bool) ([]Loca
 }
 
 // Hover requests hover information at the given position.
-func (c *Client) Hover(uri string, line, col int) (*Hover, error) {
+func (c *Client) Hover(ctx context.Context, uri string, line, col int) (*Hover, error) {
 	params := HoverParams{
 		TextDocumentPositionParams: TextDocumentPositionParams{
 			TextDocument: TextDocumentIdentifier{URI: uri},
@@ -296,7 +625,7 @@ func (c *Client) Hover(uri string, line, col int) (*Hover, error) {
 		},
 	}
 
-	result, err := c.conn.Call("textDocument/hover", params)
+	result, err := c.call(ctx, "textDocument/hover", params)
 	if err != nil {
 		return nil, err
 	}
@@ -314,12 +643,12 @@ func (c *Client) Hover(uri string, line, col int) (*Hover, error) {
 
 // DocumentSymbols requests symbols in the given document.
 // Returns (hierarchical, flat, error) — one of the two will be non-nil.
-func (c *Client) DocumentSymbols(uri string) ([]DocumentSymbol, []SymbolInformation, error) {
+func (c *Client) DocumentSymbols(ctx context.Context, uri string) ([]DocumentSymbol, []SymbolInformation, error) {
 	params := DocumentSymbolParams{
 		TextDocument: TextDocumentIdentifier{URI: uri},
 	}
 
-	result, err := c.conn.Call("textDocument/documentSymbol", params)
+	result, err := c.call(ctx, "textDocument/documentSymbol", params)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -342,10 +671,10 @@ func (c *Client) DocumentSymbols(uri string) ([]DocumentSymbol, []SymbolInformat
 }
 
 // WorkspaceSymbols queries for symbols across the workspace.
-func (c *Client) WorkspaceSymbols(query string) ([]SymbolInformation, error) {
+func (c *Client) WorkspaceSymbols(ctx context.Context, query string) ([]SymbolInformation, error) {
 	params := WorkspaceSymbolParams{Query: query}
 
-	result, err := c.conn.Call("workspace/symbol", params)
+	result, err := c.call(ctx, "workspace/symbol", params)
 	if err != nil {
 		return nil, err
 	}
@@ -358,7 +687,7 @@ func (c *Client) WorkspaceSymbols(query string) ([]SymbolInformation, error) {
 }
 
 // Implementations requests implementations of the symbol at the given position.
-func (c *Client) Implementations(uri string, line, col int) ([]Location, error) {
+func (c *Client) Implementations(ctx context.Context, uri string, line, col int) ([]Location, error) {
 	params := ImplementationParams{
 		TextDocumentPositionParams: TextDocumentPositionParams{
 			TextDocument: TextDocumentIdentifier{URI: uri},
@@ -366,7 +695,44 @@ func (c *Client) Implementations(uri string, line, col int) ([]Location, error)
 		},
 	}
 
-	result, err := c.conn.Call("textDocument/implementation", params)
+	result, err := c.call(ctx, "textDocument/implementation", params)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseLocationResponse(result)
+}
+
+// TypeDefinition requests the definition of the type of the symbol at the
+// given position: for a variable or field, where its type is declared.
+func (c *Client) TypeDefinition(ctx context.Context, uri string, line, col int) ([]Location, error) {
+	params := TypeDefinitionParams{
+		TextDocumentPositionParams: TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: line, Character: col},
+		},
+	}
+
+	result, err := c.call(ctx, "textDocument/typeDefinition", params)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseLocationResponse(result)
+}
+
+// Declaration requests the declaration of the symbol at the given position.
+// For languages that separate the two (C, C++) this is the header
+// declaration rather than the definition.
+func (c *Client) Declaration(ctx context.Context, uri string, line, col int) ([]Location, error) {
+	params := DeclarationParams{
+		TextDocumentPositionParams: TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: line, Character: col},
+		},
+	}
+
+	result, err := c.call(ctx, "textDocument/declaration", params)
 	if err != nil {
 		return nil, err
 	}
@@ -374,6 +740,347 @@ func (c *Client) Implementations(uri string, line, col int) ([]Location, error)
 	return parseLocationResponse(result)
 }
 
+// Completion requests the completion items at the given position. Both
+// response shapes, an item array and a CompletionList, are accepted.
+func (c *Client) Completion(ctx context.Context, uri string, line, col int) (*CompletionList, error) {
+	params := CompletionParams{
+		TextDocumentPositionParams: TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: line, Character: col},
+		},
+		Context: &CompletionContext{TriggerKind: 1},
+	}
+
+	result, err := c.call(ctx, "textDocument/completion", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var list CompletionList
+	if len(result) > 0 && result[0] == '[' {
+		if err := json.Unmarshal(result, &list.Items); err != nil {
+			return nil, fmt.Errorf("unmarshal completion items: %w", err)
+		}
+		return &list, nil
+	}
+	if string(result) == "null" {
+		return &list, nil
+	}
+	if err := json.Unmarshal(result, &list); err != nil {
+		return nil, fmt.Errorf("unmarshal completion list: %w", err)
+	}
+	return &list, nil
+}
+
+// ResolveCompletionItem fills in the documentation and detail of a
+// completion item, which servers often leave out of the initial list.
+func (c *Client) ResolveCompletionItem(ctx context.Context, item CompletionItem) (CompletionItem, error) {
+	result, err := c.call(ctx, "completionItem/resolve", item)
+	if err != nil {
+		return item, err
+	}
+
+	var resolved CompletionItem
+	if err := json.Unmarshal(result, &resolved); err != nil {
+		return item, fmt.Errorf("unmarshal resolved completion item: %w", err)
+	}
+	return resolved, nil
+}
+
+// SignatureHelp requests the signatures of the call at the given position.
+// Returns nil if the position is not inside a call.
+func (c *Client) SignatureHelp(ctx context.Context, uri string, line, col int) (*SignatureHelp, error) {
+	params := SignatureHelpParams{
+		TextDocumentPositionParams: TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: line, Character: col},
+		},
+	}
+
+	result, err := c.call(ctx, "textDocument/signatureHelp", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(result) == "null" {
+		return nil, nil
+	}
+
+	var help SignatureHelp
+	if err := json.Unmarshal(result, &help); err != nil {
+		return nil, fmt.Errorf("unmarshal signature help: %w", err)
+	}
+	return &help, nil
+}
+
+// Formatting requests the edits that format a whole document.
+func (c *Client) Formatting(ctx context.Context, uri string, opts FormattingOptions) ([]TextEdit, error) {
+	params := DocumentFormattingParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Options:      opts,
+	}
+
+	result, err := c.call(ctx, "textDocument/formatting", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var edits []TextEdit
+	if err := json.Unmarshal(result, &edits); err != nil {
+		return nil, fmt.Errorf("unmarshal formatting edits: %w", err)
+	}
+	return edits, nil
+}
+
+// RangeFormatting requests the edits that format part of a document.
+func (c *Client) RangeFormatting(ctx context.Context, uri string, rng Range, opts FormattingOptions) ([]TextEdit, error) {
+	params := DocumentRangeFormattingParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Range:        rng,
+		Options:      opts,
+	}
+
+	result, err := c.call(ctx, "textDocument/rangeFormatting", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var edits []TextEdit
+	if err := json.Unmarshal(result, &edits); err != nil {
+		return nil, fmt.Errorf("unmarshal range formatting edits: %w", err)
+	}
+	return edits, nil
+}
+
+// CodeActions requests the code actions available for a range. diags are
+// the diagnostics overlapping the range, which quick fixes refer to; only,
+// if set, restricts the kinds of action returned. Bare commands in the
+// response are returned as actions that carry only the command.
+func (c *Client) CodeActions(ctx context.Context, uri string, rng Range, diags []Diagnostic, only []string) ([]CodeAction, error) {
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	params := CodeActionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Range:        rng,
+		Context:      CodeActionContext{Diagnostics: diags, Only: only},
+	}
+
+	result, err := c.call(ctx, "textDocument/codeAction", params)
+	if err != nil {
+		return nil, err
+	}
+
+	// (Command | CodeAction)[]
+	var raw []json.RawMessage
+	if err := json.Unmarshal(result, &raw); err != nil {
+		return nil, fmt.Errorf("unmarshal code actions: %w", err)
+	}
+	actions := make([]CodeAction, 0, len(raw))
+	for _, r := range raw {
+		var probe struct {
+			Command json.RawMessage `json:"command"`
+		}
+		if err := json.Unmarshal(r, &probe); err != nil {
+			return nil, fmt.Errorf("unmarshal code action: %w", err)
+		}
+		if len(probe.Command) > 0 && probe.Command[0] == '"' {
+			var cmd Command
+			if err := json.Unmarshal(r, &cmd); err != nil {
+				return nil, fmt.Errorf("unmarshal command: %w", err)
+			}
+			actions = append(actions, CodeAction{Title: cmd.Title, Command: &cmd})
+			continue
+		}
+		var action CodeAction
+		if err := json.Unmarshal(r, &action); err != nil {
+			return nil, fmt.Errorf("unmarshal code action: %w", err)
+		}
+		actions = append(actions, action)
+	}
+	return actions, nil
+}
+
+// ResolveCodeAction fills in the edit (and command) of a code action the
+// server left unresolved.
+func (c *Client) ResolveCodeAction(ctx context.Context, action CodeAction) (CodeAction, error) {
+	result, err := c.call(ctx, "codeAction/resolve", action)
+	if err != nil {
+		return action, err
+	}
+
+	var resolved CodeAction
+	if err := json.Unmarshal(result, &resolved); err != nil {
+		return action, fmt.Errorf("unmarshal resolved code action: %w", err)
+	}
+	return resolved, nil
+}
+
+// ExecuteCommand runs a server command and returns its result, which is
+// command specific. Commands that change files usually do so by sending
+// a workspace/applyEdit request before they return.
+func (c *Client) ExecuteCommand(ctx context.Context, cmd Command) (json.RawMessage, error) {
+	params := ExecuteCommandParams{
+		Command:   cmd.Command,
+		Arguments: cmd.Arguments,
+	}
+	return c.call(ctx, "workspace/executeCommand", params)
+}
+
+// PrepareRename checks that the symbol at the given position can be renamed.
+// Returns nil if the server reports that it cannot.
+func (c *Client) PrepareRename(ctx context.Context, uri string, line, col int) (*PrepareRenameResult, error) {
+	params := PrepareRenameParams{
+		TextDocumentPositionParams: TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: line, Character: col},
+		},
+	}
+
+	result, err := c.call(ctx, "textDocument/prepareRename", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(result) == "null" {
+		return nil, nil
+	}
+
+	// Range | { range, placeholder } | { defaultBehavior }
+	var prep PrepareRenameResult
+	if err := json.Unmarshal(result, &prep); err == nil && prep.Range != (Range{}) {
+		return &prep, nil
+	}
+	var r Range
+	if err := json.Unmarshal(result, &r); err == nil && r != (Range{}) {
+		return &PrepareRenameResult{Range: r}, nil
+	}
+	pos := Position{Line: line, Character: col}
+	return &PrepareRenameResult{Range: Range{Start: pos, End: pos}}, nil
+}
+
+// Rename requests the workspace edit that renames the symbol at the given
+// position to newName. The edit is not applied.
+func (c *Client) Rename(ctx context.Context, uri string, line, col int, newName string) (*WorkspaceEdit, error) {
+	params := RenameParams{
+		TextDocumentPositionParams: TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: line, Character: col},
+		},
+		NewName: newName,
+	}
+
+	result, err := c.call(ctx, "textDocument/rename", params)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(result) == "null" {
+		return nil, nil
+	}
+
+	var edit WorkspaceEdit
+	if err := json.Unmarshal(result, &edit); err != nil {
+		return nil, fmt.Errorf("unmarshal rename: %w", err)
+	}
+	return &edit, nil
+}
+
+// PrepareCallHierarchy resolves the position to call hierarchy items,
+// usually one: the function or method whose name is under the cursor.
+func (c *Client) PrepareCallHierarchy(ctx context.Context, uri string, line, col int) ([]CallHierarchyItem, error) {
+	params := CallHierarchyPrepareParams{
+		TextDocumentPositionParams: TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: line, Character: col},
+		},
+	}
+
+	result, err := c.call(ctx, "textDocument/prepareCallHierarchy", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []CallHierarchyItem
+	if err := json.Unmarshal(result, &items); err != nil {
+		return nil, fmt.Errorf("unmarshal call hierarchy items: %w", err)
+	}
+	return items, nil
+}
+
+// IncomingCalls returns the callers of a call hierarchy item.
+func (c *Client) IncomingCalls(ctx context.Context, item CallHierarchyItem) ([]CallHierarchyIncomingCall, error) {
+	result, err := c.call(ctx, "callHierarchy/incomingCalls", CallHierarchyIncomingCallsParams{Item: item})
+	if err != nil {
+		return nil, err
+	}
+
+	var calls []CallHierarchyIncomingCall
+	if err := json.Unmarshal(result, &calls); err != nil {
+		return nil, fmt.Errorf("unmarshal incoming calls: %w", err)
+	}
+	return calls, nil
+}
+
+// OutgoingCalls returns the callees of a call hierarchy item.
+func (c *Client) OutgoingCalls(ctx context.Context, item CallHierarchyItem) ([]CallHierarchyOutgoingCall, error) {
+	result, err := c.call(ctx, "callHierarchy/outgoingCalls", CallHierarchyOutgoingCallsParams{Item: item})
+	if err != nil {
+		return nil, err
+	}
+
+	var calls []CallHierarchyOutgoingCall
+	if err := json.Unmarshal(result, &calls); err != nil {
+		return nil, fmt.Errorf("unmarshal outgoing calls: %w", err)
+	}
+	return calls, nil
+}
+
+// PrepareTypeHierarchy resolves the position to type hierarchy items,
+// usually one: the type whose name is under the cursor.
+func (c *Client) PrepareTypeHierarchy(ctx context.Context, uri string, line, col int) ([]TypeHierarchyItem, error) {
+	params := TypeHierarchyPrepareParams{
+		TextDocumentPositionParams: TextDocumentPositionParams{
+			TextDocument: TextDocumentIdentifier{URI: uri},
+			Position:     Position{Line: line, Character: col},
+		},
+	}
+
+	result, err := c.call(ctx, "textDocument/prepareTypeHierarchy", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []TypeHierarchyItem
+	if err := json.Unmarshal(result, &items); err != nil {
+		return nil, fmt.Errorf("unmarshal type hierarchy items: %w", err)
+	}
+	return items, nil
+}
+
+// Supertypes returns the direct supertypes of a type hierarchy item.
+func (c *Client) Supertypes(ctx context.Context, item TypeHierarchyItem) ([]TypeHierarchyItem, error) {
+	return c.typeHierarchy(ctx, "typeHierarchy/supertypes", TypeHierarchySupertypesParams{Item: item})
+}
+
+// Subtypes returns the direct subtypes of a type hierarchy item.
+func (c *Client) Subtypes(ctx context.Context, item TypeHierarchyItem) ([]TypeHierarchyItem, error) {
+	return c.typeHierarchy(ctx, "typeHierarchy/subtypes", TypeHierarchySubtypesParams{Item: item})
+}
+
+func (c *Client) typeHierarchy(ctx context.Context, method string, params interface{}) ([]TypeHierarchyItem, error) {
+	result, err := c.call(ctx, method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []TypeHierarchyItem
+	if err := json.Unmarshal(result, &items); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", method, err)
+	}
+	return items, nil
+}
+
 // GetDiagnostics returns the most recently received diagnostics for a URI.
 func (c *Client) GetDiagnostics(uri string) []Diagnostic {
 	c.diagMu.Lock()
@@ -381,28 +1088,141 @@ func (c *Client) GetDiagnostics(uri string) []Diagnostic {
 	return c.diagnostics[uri]
 }
 
-// WaitForDiagnostics waits for a diagnostics notification and returns.
-// It returns immediately if diagnostics have already been received for the URI.
-func (c *Client) WaitForDiagnostics(uri string) []Diagnostic {
-	// Check if we already have diagnostics
+// CurrentDiagnostics returns the diagnostics for a URI if they were
+// received after the document was last opened or changed.
+func (c *Client) CurrentDiagnostics(uri string) ([]Diagnostic, bool) {
 	c.diagMu.Lock()
-	if diags, ok := c.diagnostics[uri]; ok {
+	defer c.diagMu.Unlock()
+	diags, ok := c.diagnostics[uri]
+	return diags, ok && !c.diagStale[uri]
+}
+
+// WaitForDiagnostics waits until current diagnostics (see
+// CurrentDiagnostics) have been published for every URI, or ctx is done.
+// Returns the URIs still without current diagnostics.
+func (c *Client) WaitForDiagnostics(ctx context.Context, uris []string) []string {
+	for {
+		c.diagMu.Lock()
+		var missing []string
+		for _, uri := range uris {
+			if _, ok := c.diagnostics[uri]; !ok || c.diagStale[uri] {
+				missing = append(missing, uri)
+			}
+		}
+		changed := c.diagCh
 		c.diagMu.Unlock()
-		return diags
+
+		if len(missing) == 0 {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return missing
+		}
+	}
+}
+
+// Diagnostics returns the current diagnostics of open documents. If the
+// server supports pull diagnostics they are requested for each document;
+// otherwise it waits for the server to publish them (see
+// WaitForDiagnostics). Returns the URIs whose diagnostics did not arrive
+// before ctx was done.
+func (c *Client) Diagnostics(ctx context.Context, uris []string) (map[string][]Diagnostic, []string, error) {
+	var missing []string
+	if c.Capabilities().SupportsPullDiagnostics() {
+		for _, uri := range uris {
+			if _, err := c.DocumentDiagnostics(ctx, uri); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", URIToPath(uri), err)
+			}
+		}
+	} else {
+		missing = c.WaitForDiagnostics(ctx, uris)
+	}
+
+	diags := make(map[string][]Diagnostic, len(uris))
+	c.diagMu.Lock()
+	for _, uri := range uris {
+		diags[uri] = c.diagnostics[uri]
 	}
 	c.diagMu.Unlock()
+	return diags, missing, nil
+}
+
+// DocumentDiagnostics pulls the diagnostics of a document with
+// textDocument/diagnostic. They are also recorded, with those of any
+// related documents in the report, as if published.
+func (c *Client) DocumentDiagnostics(ctx context.Context, uri string) ([]Diagnostic, error) {
+	params := DocumentDiagnosticParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+	}
+
+	result, err := c.call(ctx, "textDocument/diagnostic", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var report DocumentDiagnosticReport
+	if err := json.Unmarshal(result, &report); err != nil {
+		return nil, fmt.Errorf("unmarshal diagnostic report: %w", err)
+	}
+	if report.Kind == "full" {
+		c.setDiagnostics(uri, report.Items, true)
+	}
+	for related, r := range report.RelatedDocuments {
+		if r.Kind == "full" {
+			c.setDiagnostics(related, r.Items, true)
+		}
+	}
+	return c.GetDiagnostics(uri), nil
+}
+
+// WorkspaceDiagnostics pulls the diagnostics of every file in the
+// workspace with workspace/diagnostic, keyed by URI, and records them.
+func (c *Client) WorkspaceDiagnostics(ctx context.Context) (map[string][]Diagnostic, error) {
+	params := WorkspaceDiagnosticParams{PreviousResultIDs: []json.RawMessage{}}
 
-	// Wait for notification
-	<-c.diagCh
+	result, err := c.call(ctx, "workspace/diagnostic", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var report WorkspaceDiagnosticReport
+	if err := json.Unmarshal(result, &report); err != nil {
+		return nil, fmt.Errorf("unmarshal workspace diagnostic report: %w", err)
+	}
+	diags := make(map[string][]Diagnostic, len(report.Items))
+	for _, r := range report.Items {
+		if r.Kind == "full" {
+			c.setDiagnostics(r.URI, r.Items, true)
+			diags[r.URI] = r.Items
+		}
+	}
+	return diags, nil
+}
 
+// setDiagnostics records the diagnostics of a URI and wakes waiters. If
+// current is false they are kept but the URI is still considered stale.
+func (c *Client) setDiagnostics(uri string, diags []Diagnostic, current bool) {
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
 	c.diagMu.Lock()
 	defer c.diagMu.Unlock()
-	return c.diagnostics[uri]
+	c.diagnostics[uri] = diags
+	if current {
+		delete(c.diagStale, uri)
+	}
+	close(c.diagCh)
+	c.diagCh = make(chan struct{})
 }
 
-// DiagnosticsChannel returns a channel signaled when new diagnostics arrive.
-func (c *Client) DiagnosticsChannel() <-chan struct{} {
-	return c.diagCh
+// markStale records that a document is about to change, so diagnostics
+// received before do not count as current.
+func (c *Client) markStale(uri string) {
+	c.diagMu.Lock()
+	defer c.diagMu.Unlock()
+	c.diagStale[uri] = true
 }
 
 // AllDiagnostics returns all collected diagnostics keyed by URI.
@@ -416,18 +1236,32 @@ func (c *Client) AllDiagnostics() map[string][]Diagnostic {
 	return result
 }
 
-// Close shuts down the LSP server and cleans up.
+// Close shuts down the LSP server and cleans up. A server that does not
+// answer shutdown within shutdownTimeout is killed regardless.
 func (c *Client) Close() error {
+	c.closed.Store(true)
+	c.restartMu.Lock()
+	defer c.restartMu.Unlock()
+
+	p, conn := c.current()
+	if p != nil {
+		p.stopping.Store(true)
+	}
+
 	// Send shutdown request
-	c.conn.Call("shutdown", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	conn.CallContext(ctx, "shutdown", nil)
+	cancel()
 	// Send exit notification
-	c.conn.Notify("exit", nil)
-	c.conn.Close()
+	conn.Notify("exit", nil)
+	conn.Close()
 
-	if c.cmd.Process != nil {
-		c.cmd.Process.Kill()
+	if p == nil {
+		return c.stream.Close()
 	}
-	return c.cmd.Wait()
+	p.cmd.Process.Kill()
+	<-p.exited
+	return nil
 }
 
 // parseLocationResponse handles the various shapes of location responses:
@@ -437,9 +1271,10 @@ func parseLocationResponse(result json.RawMessage) ([]Location, error) {
 		return nil, nil
 	}
 
-	// Try Location[] first
+	// Try Location[] first. A LocationLink[] unmarshals into it too, but
+	// without URIs.
 	var locs []Location
-	if err := json.Unmarshal(result, &locs); err == nil {
+	if err := json.Unmarshal(result, &locs); err == nil && (len(locs) == 0 || locs[0].URI != "") {
 		return locs, nil
 	}
 
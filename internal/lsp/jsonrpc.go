@@ -2,7 +2,9 @@ package lsp
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -23,10 +25,10 @@ type Request struct {
 
 // Response is a JSON-RPC 2.0 response.
 type Response struct {
-	JSONRPC string           `json:"jsonrpc"`
-	ID      *int64           `json:"id"`
-	Result  json.RawMessage  `json:"result,omitempty"`
-	Error   *ResponseError   `json:"error,omitempty"`
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *ResponseError  `json:"error,omitempty"`
 }
 
 // ResponseError is a JSON-RPC 2.0 error.
@@ -36,6 +38,22 @@ type ResponseError struct {
 	Data    json.RawMessage `json:"data,omitempty"`
 }
 
+// CancelParams for $/cancelRequest, which asks the other side to stop
+// working on the request with the given id.
+type CancelParams struct {
+	ID int64 `json:"id"`
+}
+
+// Standard JSON-RPC 2.0 error codes.
+const (
+	CodeMethodNotFound = -32601
+	CodeInternalError  = -32603
+
+	// CodeRequestCancelled is the LSP error for a request cancelled with
+	// $/cancelRequest.
+	CodeRequestCancelled = -32800
+)
+
 func (e *ResponseError) Error() string {
 	return fmt.Sprintf("LSP error %d: %s", e.Code, e.Message)
 }
@@ -109,6 +127,29 @@ func (t *Transport) ReadMessage() ([]byte, error) {
 	return body, nil
 }
 
+// maxQueuedNotifications bounds the notification queue of a Conn. When it
+// is full, diagnostics replace those queued for the same document, since
+// only the latest count; any other notification waits for room, holding
+// up the read loop, so none is lost.
+const maxQueuedNotifications = 1000
+
+// NotificationStats describes the notification queue of a Conn.
+// MaxQueued shows how far the handler has fallen behind, and Coalesced
+// how many diagnostics were replaced by newer ones while the queue was
+// full.
+type NotificationStats struct {
+	Delivered uint64 `json:"delivered"`
+	Queued    int    `json:"queued"`    // waiting to be handled now
+	MaxQueued int    `json:"maxQueued"` // most ever waiting at once
+	Coalesced uint64 `json:"coalesced,omitempty"`
+}
+
+type notification struct {
+	method string
+	params json.RawMessage
+	uri    string // of a publishDiagnostics
+}
+
 // Conn manages JSON-RPC communication with pending request tracking.
 type Conn struct {
 	transport *Transport
@@ -116,9 +157,24 @@ type Conn struct {
 	pending   map[int64]chan *Response
 	mu        sync.Mutex
 
-	// NotificationHandler is called for server-initiated notifications.
+	// NotificationHandler is called for server-initiated notifications,
+	// one at a time and in the order they arrived. It runs on a goroutine
+	// of its own, behind a bounded queue, so a slow handler does not delay
+	// the responses to calls until it falls far behind.
 	NotificationHandler func(method string, params json.RawMessage)
 
+	queueMu sync.Mutex
+	queue   []notification // read but not yet handled, oldest first
+	queued  chan struct{}  // signalled when queue becomes non-empty
+	room    chan struct{}  // signalled when a notification leaves queue
+	stats   NotificationStats
+
+	// RequestHandler is called for server-initiated requests, each on its
+	// own goroutine so it may make calls of its own. Its result, or its
+	// error as a *ResponseError, is sent back as the reply. Without a
+	// handler every request is answered with MethodNotFound.
+	RequestHandler func(method string, params json.RawMessage) (interface{}, error)
+
 	done chan struct{}
 }
 
@@ -127,14 +183,36 @@ func NewConn(t *Transport) *Conn {
 	c := &Conn{
 		transport: t,
 		pending:   make(map[int64]chan *Response),
+		queued:    make(chan struct{}, 1),
+		room:      make(chan struct{}, 1),
 		done:      make(chan struct{}),
 	}
 	go c.readLoop()
+	go c.notifyLoop()
 	return c
 }
 
-// Call sends a request and waits for the response.
+// NotificationStats returns the state of the notification queue.
+func (c *Conn) NotificationStats() NotificationStats {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	st := c.stats
+	st.Queued = len(c.queue)
+	return st
+}
+
+// Call sends a request and waits for the response, however long it takes.
 func (c *Conn) Call(method string, params interface{}) (json.RawMessage, error) {
+	return c.CallContext(context.Background(), method, params)
+}
+
+// CallContext sends a request and waits for the response until ctx is
+// done. The server is then told to stop with $/cancelRequest and the
+// context's error is returned; a late response is dropped.
+func (c *Conn) CallContext(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	id := c.nextID.Add(1)
 
 	paramsJSON, err := json.Marshal(params)
@@ -176,11 +254,25 @@ func (c *Conn) Call(method string, params interface{}) (json.RawMessage, error)
 		return resp.Result, nil
 	case <-c.done:
 		return nil, fmt.Errorf("connection closed")
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		c.Notify("$/cancelRequest", CancelParams{ID: id})
+		return nil, ctx.Err()
 	}
 }
 
 // Notify sends a notification (no response expected).
 func (c *Conn) Notify(method string, params interface{}) error {
+	return c.NotifyContext(context.Background(), method, params)
+}
+
+// NotifyContext sends a notification unless ctx is already done.
+func (c *Conn) NotifyContext(ctx context.Context, method string, params interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	paramsJSON, err := json.Marshal(params)
 	if err != nil {
 		return fmt.Errorf("marshal params: %w", err)
@@ -224,34 +316,40 @@ func (c *Conn) readLoop() {
 			return
 		}
 
-		// Try to parse as a response (has "id" field)
+		// A message with a method is a request or, without an id, a
+		// notification; one with only an id is a response. The id of a
+		// server request may be a number or a string, and is echoed back
+		// as it came.
 		var msg struct {
-			ID     *int64          `json:"id"`
+			ID     json.RawMessage `json:"id"`
 			Method string          `json:"method"`
 			Params json.RawMessage `json:"params"`
 		}
 		if err := json.Unmarshal(data, &msg); err != nil {
 			continue
 		}
+		hasID := len(msg.ID) > 0 && string(msg.ID) != "null"
 
-		if msg.Method != "" && msg.ID == nil {
+		switch {
+		case msg.Method != "" && !hasID:
 			// Server notification (e.g., textDocument/publishDiagnostics)
-			if c.NotificationHandler != nil {
-				c.NotificationHandler(msg.Method, msg.Params)
-			}
-			continue
-		}
+			c.queueNotification(notification{method: msg.Method, params: msg.Params})
+
+		case msg.Method != "":
+			// Server request (e.g., workspace/configuration). The server
+			// waits for the reply, so one is always sent.
+			go c.handleRequest(msg.ID, msg.Method, msg.Params)
 
-		if msg.ID != nil {
+		case hasID:
 			var resp Response
-			if err := json.Unmarshal(data, &resp); err != nil {
+			if err := json.Unmarshal(data, &resp); err != nil || resp.ID == nil {
 				continue
 			}
 
 			c.mu.Lock()
-			ch, ok := c.pending[*msg.ID]
+			ch, ok := c.pending[*resp.ID]
 			if ok {
-				delete(c.pending, *msg.ID)
+				delete(c.pending, *resp.ID)
 			}
 			c.mu.Unlock()
 
@@ -261,3 +359,135 @@ func (c *Conn) readLoop() {
 		}
 	}
 }
+
+// queueNotification hands a notification to notifyLoop. It blocks while
+// the queue is full, unless the notification replaces queued diagnostics
+// or the connection is closed.
+func (c *Conn) queueNotification(n notification) {
+	if n.method == "textDocument/publishDiagnostics" {
+		var p struct {
+			URI string `json:"uri"`
+		}
+		json.Unmarshal(n.params, &p)
+		n.uri = p.URI
+	}
+
+	c.queueMu.Lock()
+	for len(c.queue) >= maxQueuedNotifications {
+		if c.coalesce(n) {
+			c.queueMu.Unlock()
+			return
+		}
+		c.queueMu.Unlock()
+		closed := false
+		select {
+		case <-c.room:
+		case <-c.done:
+			// notifyLoop still delivers what is queued, n included.
+			closed = true
+		}
+		c.queueMu.Lock()
+		if closed {
+			break
+		}
+	}
+	c.queue = append(c.queue, n)
+	c.stats.MaxQueued = max(c.stats.MaxQueued, len(c.queue))
+	c.queueMu.Unlock()
+	select {
+	case c.queued <- struct{}{}:
+	default: // already signalled
+	}
+}
+
+// coalesce replaces the queued diagnostics for n's document with n, moved
+// to the end of the queue. It reports false if n is not diagnostics or
+// none are queued for its document. c.queueMu must be held.
+func (c *Conn) coalesce(n notification) bool {
+	if n.uri == "" {
+		return false
+	}
+	for i, q := range c.queue {
+		if q.uri == n.uri {
+			copy(c.queue[i:], c.queue[i+1:])
+			c.queue[len(c.queue)-1] = n
+			c.stats.Coalesced++
+			return true
+		}
+	}
+	return false
+}
+
+// notifyLoop delivers queued notifications to the NotificationHandler, in
+// order. Those already queued when the connection closes are still
+// delivered.
+func (c *Conn) notifyLoop() {
+	for {
+		c.queueMu.Lock()
+		if len(c.queue) == 0 {
+			c.queue = nil // let the backing array go
+			c.queueMu.Unlock()
+			select {
+			case <-c.queued:
+				continue
+			case <-c.done:
+				c.queueMu.Lock()
+				empty := len(c.queue) == 0
+				c.queueMu.Unlock()
+				if empty {
+					return
+				}
+				continue
+			}
+		}
+		n := c.queue[0]
+		c.queue = c.queue[1:]
+		c.queueMu.Unlock()
+		select {
+		case c.room <- struct{}{}:
+		default: // already signalled
+		}
+
+		if c.NotificationHandler != nil {
+			c.NotificationHandler(n.method, n.params)
+		}
+		c.queueMu.Lock()
+		c.stats.Delivered++
+		c.queueMu.Unlock()
+	}
+}
+
+// handleRequest answers a server request with the RequestHandler's result.
+func (c *Conn) handleRequest(id json.RawMessage, method string, params json.RawMessage) {
+	var result interface{}
+	err := error(&ResponseError{Code: CodeMethodNotFound, Message: "method not found: " + method})
+	if c.RequestHandler != nil {
+		result, err = c.RequestHandler(method, params)
+	}
+
+	reply := struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  interface{}     `json:"result,omitempty"`
+		Error   *ResponseError  `json:"error,omitempty"`
+	}{JSONRPC: "2.0", ID: id}
+	if err != nil {
+		var rerr *ResponseError
+		if !errors.As(err, &rerr) {
+			rerr = &ResponseError{Code: CodeInternalError, Message: err.Error()}
+		}
+		reply.Error = rerr
+	} else {
+		// A successful reply must have a result, even if it is null.
+		reply.Result = json.RawMessage("null")
+		if result != nil {
+			reply.Result = result
+		}
+	}
+
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return
+	}
+	c.transport.WriteMessage(data)
+}
@@ -0,0 +1,357 @@
+package lsp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// peer is the server end of a Conn under test.
+type peer struct {
+	t  *testing.T
+	tr *Transport
+	w  io.Closer // closing it ends the Conn's input
+}
+
+// newConnPair returns a Conn connected to a peer playing the server.
+func newConnPair(t *testing.T) (*Conn, *peer) {
+	t.Helper()
+	toConnR, toConnW := io.Pipe()
+	toPeerR, toPeerW := io.Pipe()
+	c := NewConn(NewTransport(toConnR, toPeerW))
+	t.Cleanup(func() {
+		c.Close()
+		toConnW.Close()
+		toPeerR.Close()
+	})
+	return c, &peer{t: t, tr: NewTransport(toPeerR, toConnW), w: toConnW}
+}
+
+// read returns the next message the Conn sent.
+func (p *peer) read() Request {
+	p.t.Helper()
+	data, err := p.tr.ReadMessage()
+	if err != nil {
+		p.t.Fatalf("peer read: %v", err)
+	}
+	var req Request
+	if err := json.Unmarshal(data, &req); err != nil {
+		p.t.Fatalf("peer read %s: %v", data, err)
+	}
+	return req
+}
+
+// send writes a raw message to the Conn.
+func (p *peer) send(msg string) {
+	p.t.Helper()
+	if err := p.tr.WriteMessage([]byte(msg)); err != nil {
+		p.t.Fatalf("peer write: %v", err)
+	}
+}
+
+func (p *peer) reply(id int64, result string) {
+	p.t.Helper()
+	resp, _ := json.Marshal(Response{JSONRPC: "2.0", ID: &id, Result: json.RawMessage(result)})
+	p.send(string(resp))
+}
+
+type callResult struct {
+	result json.RawMessage
+	err    error
+}
+
+func goCall(c *Conn, method string) chan callResult {
+	done := make(chan callResult, 1)
+	go func() {
+		result, err := c.Call(method, nil)
+		done <- callResult{result, err}
+	}()
+	return done
+}
+
+func TestConnCall(t *testing.T) {
+	c, p := newConnPair(t)
+
+	first := goCall(c, "first")
+	req1 := p.read()
+	second := goCall(c, "second")
+	req2 := p.read()
+	if req1.Method != "first" || req2.Method != "second" || *req1.ID == *req2.ID {
+		t.Fatalf("requests = %+v, %+v", req1, req2)
+	}
+
+	// Responses are matched by id, whatever their order.
+	p.reply(*req2.ID, `"two"`)
+	p.send(fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"error":{"code":-32602,"message":"bad params"}}`, *req1.ID))
+
+	if r := <-second; r.err != nil || string(r.result) != `"two"` {
+		t.Errorf("second = %s, %v", r.result, r.err)
+	}
+	r := <-first
+	var rerr *ResponseError
+	if !errors.As(r.err, &rerr) || rerr.Code != -32602 || rerr.Message != "bad params" {
+		t.Errorf("first error = %v, want the server's error", r.err)
+	}
+}
+
+func TestConnClosed(t *testing.T) {
+	c, p := newConnPair(t)
+	call := goCall(c, "never answered")
+	p.read()
+	p.w.Close()
+	if r := <-call; r.err == nil {
+		t.Error("call on a closed connection succeeded")
+	}
+}
+
+func TestConnServerRequests(t *testing.T) {
+	c, p := newConnPair(t)
+	c.RequestHandler = func(method string, params json.RawMessage) (interface{}, error) {
+		switch method {
+		case "workspace/configuration":
+			return []interface{}{map[string]bool{"on": true}}, nil
+		case "window/workDoneProgress/create":
+			return nil, nil
+		case "workspace/applyEdit":
+			return nil, errors.New("cannot apply")
+		}
+		return nil, &ResponseError{Code: CodeMethodNotFound, Message: "method not found: " + method}
+	}
+
+	tests := []struct {
+		request, reply string
+	}{
+		{
+			`{"jsonrpc":"2.0","id":1,"method":"workspace/configuration","params":{}}`,
+			`{"jsonrpc":"2.0","id":1,"result":[{"on":true}]}`,
+		},
+		{
+			`{"jsonrpc":"2.0","id":"token-2","method":"window/workDoneProgress/create"}`,
+			`{"jsonrpc":"2.0","id":"token-2","result":null}`,
+		},
+		{
+			`{"jsonrpc":"2.0","id":3,"method":"workspace/applyEdit"}`,
+			`{"jsonrpc":"2.0","id":3,"error":{"code":-32603,"message":"cannot apply"}}`,
+		},
+		{
+			`{"jsonrpc":"2.0","id":4,"method":"unknown/method"}`,
+			`{"jsonrpc":"2.0","id":4,"error":{"code":-32601,"message":"method not found: unknown/method"}}`,
+		},
+	}
+	for _, tt := range tests {
+		p.send(tt.request)
+		data, err := p.tr.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.reply {
+			t.Errorf("reply to %s:\n got %s\nwant %s", tt.request, data, tt.reply)
+		}
+	}
+}
+
+func TestConnServerRequestsWithoutHandler(t *testing.T) {
+	_, p := newConnPair(t)
+	p.send(`{"jsonrpc":"2.0","id":7,"method":"workspace/configuration"}`)
+	data, err := p.tr.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"jsonrpc":"2.0","id":7,"error":{"code":-32601,"message":"method not found: workspace/configuration"}}`
+	if string(data) != want {
+		t.Errorf("reply = %s, want %s", data, want)
+	}
+}
+
+func TestConnCallCancel(t *testing.T) {
+	c, p := newConnPair(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.CallContext(ctx, "slow", nil)
+		done <- err
+	}()
+	req := p.read()
+	cancel()
+
+	// The server is told to stop, by the request's id.
+	note := p.read()
+	var params CancelParams
+	json.Unmarshal(note.Params, &params)
+	if note.Method != "$/cancelRequest" || note.ID != nil || params.ID != *req.ID {
+		t.Errorf("after cancel, got %+v (params %s), want $/cancelRequest for id %d", note, note.Params, *req.ID)
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled call error = %v, want context.Canceled", err)
+	}
+
+	// A late response is dropped and the connection goes on working.
+	p.reply(*req.ID, `"late"`)
+	next := goCall(c, "next")
+	req2 := p.read()
+	p.reply(*req2.ID, `"next"`)
+	if r := <-next; r.err != nil || string(r.result) != `"next"` {
+		t.Errorf("call after a cancelled one = %s, %v", r.result, r.err)
+	}
+}
+
+func TestConnCallDoneContext(t *testing.T) {
+	c, _ := newConnPair(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.CallContext(ctx, "never sent", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("call with a done context: %v, want context.Canceled", err)
+	}
+	if err := c.NotifyContext(ctx, "never sent", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("notify with a done context: %v, want context.Canceled", err)
+	}
+}
+
+// TestConnNotificationQueue checks that notifications reach the handler
+// in order and none are lost while it is slow, and that call responses
+// are not held up behind them.
+func TestConnNotificationQueue(t *testing.T) {
+	c, p := newConnPair(t)
+	const n = 1000
+	started, release := make(chan struct{}), make(chan struct{})
+	got := make(chan string, n)
+	first := true
+	c.NotificationHandler = func(method string, params json.RawMessage) {
+		if first {
+			first = false
+			close(started)
+			<-release
+		}
+		got <- string(params)
+	}
+
+	call := goCall(c, "call")
+	req := p.read()
+	for i := 0; i < n; i++ {
+		p.send(fmt.Sprintf(`{"jsonrpc":"2.0","method":"note","params":%d}`, i))
+	}
+	p.reply(*req.ID, `"answer"`)
+
+	<-started
+	if r := <-call; r.err != nil || string(r.result) != `"answer"` {
+		t.Fatalf("call behind a blocked handler = %s, %v", r.result, r.err)
+	}
+	if st := c.NotificationStats(); st.Queued != n-1 || st.MaxQueued < n-1 || st.Delivered != 0 {
+		t.Errorf("stats while the handler is blocked = %+v, want %d queued", st, n-1)
+	}
+
+	close(release)
+	for i := 0; i < n; i++ {
+		if params := <-got; params != fmt.Sprint(i) {
+			t.Fatalf("notification %d has params %s", i, params)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for c.NotificationStats().Delivered != n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if st := c.NotificationStats(); st.Queued != 0 || st.MaxQueued < n-1 || st.Delivered != n {
+		t.Errorf("stats after draining = %+v, want %d delivered", st, n)
+	}
+}
+
+// TestConnNotificationsAfterClose checks that notifications read before
+// the connection closed are still delivered.
+func TestConnNotificationsAfterClose(t *testing.T) {
+	c, p := newConnPair(t)
+	release := make(chan struct{})
+	got := make(chan string, 3)
+	c.NotificationHandler = func(method string, params json.RawMessage) {
+		<-release
+		got <- method
+	}
+
+	for _, m := range []string{"a", "b", "c"} {
+		p.send(`{"jsonrpc":"2.0","method":"` + m + `"}`)
+	}
+	// The reply to a request proves the notifications before it were read.
+	p.send(`{"jsonrpc":"2.0","id":1,"method":"ping"}`)
+	p.read()
+	p.w.Close()
+	c.Close()
+
+	close(release)
+	for _, want := range []string{"a", "b", "c"} {
+		select {
+		case m := <-got:
+			if m != want {
+				t.Errorf("got %s, want %s", m, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("notification %s not delivered after close", want)
+		}
+	}
+}
+
+// TestConnNotificationQueueFull checks that once the queue is full, newer
+// diagnostics replace queued ones for the same document, and other
+// notifications hold up the read loop rather than being dropped.
+func TestConnNotificationQueueFull(t *testing.T) {
+	c, p := newConnPair(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	got := make(chan string, maxQueuedNotifications+10)
+	first := true
+	c.NotificationHandler = func(method string, params json.RawMessage) {
+		if first {
+			first = false
+			close(started)
+			<-release
+		}
+		got <- string(params)
+	}
+	diags := func(version int) string {
+		return fmt.Sprintf(`{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///a","version":%d}}`, version)
+	}
+
+	p.send(`{"jsonrpc":"2.0","method":"note","params":"first"}`)
+	<-started
+	p.send(diags(1))
+	for i := 1; i < maxQueuedNotifications; i++ {
+		p.send(fmt.Sprintf(`{"jsonrpc":"2.0","method":"note","params":%d}`, i))
+	}
+	p.send(diags(2))
+	p.send(`{"jsonrpc":"2.0","method":"note","params":"last"}`)
+
+	// The read loop waits for room for the last note, so a response
+	// behind it is not read yet.
+	call := goCall(c, "call")
+	req := p.read()
+	sent := make(chan struct{})
+	go func() {
+		p.reply(*req.ID, `"answer"`)
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.Fatal("response read while the notification queue was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if st := c.NotificationStats(); st.Queued != maxQueuedNotifications || st.Coalesced != 1 {
+		t.Errorf("stats while full = %+v, want %d queued and 1 coalesced", st, maxQueuedNotifications)
+	}
+
+	close(release)
+	want := []string{`"first"`}
+	for i := 1; i < maxQueuedNotifications; i++ {
+		want = append(want, fmt.Sprint(i))
+	}
+	want = append(want, `{"uri":"file:///a","version":2}`, `"last"`)
+	for i, w := range want {
+		if params := <-got; params != w {
+			t.Fatalf("notification %d has params %s, want %s", i, params, w)
+		}
+	}
+	if r := <-call; r.err != nil || string(r.result) != `"answer"` {
+		t.Errorf("call after the queue drained = %s, %v", r.result, r.err)
+	}
+}
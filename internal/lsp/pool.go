@@ -0,0 +1,170 @@
+package lsp
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pool keeps long-lived clients keyed by (server command, workspace root),
+// so repeated requests against the same workspace reuse a warm server.
+// Servers that crash are restarted (see Client.SetAutoRestart).
+type Pool struct {
+	Verbose   bool
+	ServerLog io.Writer // gets the stderr of every server, if set
+
+	mu      sync.Mutex
+	clients map[string]*poolEntry
+}
+
+type poolEntry struct {
+	command []string
+	root    string
+	started time.Time
+
+	ready  chan struct{} // closed once the start attempt finishes
+	client *Client
+	err    error
+}
+
+// dead reports whether the entry's server started, then crashed for good.
+func (e *poolEntry) dead() bool {
+	select {
+	case <-e.ready:
+		return e.client != nil && e.client.dead()
+	default:
+		return false
+	}
+}
+
+// PoolEntry describes a running client in a Pool.
+type PoolEntry struct {
+	Command []string  `json:"command"`
+	Root    string    `json:"root"`
+	Started time.Time `json:"started"`
+	Ready   bool      `json:"ready"`
+
+	// Restarts counts automatic restarts after crashes. Error is set if
+	// the server has crashed and is not running.
+	Restarts int    `json:"restarts,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	Notifications NotificationStats `json:"notifications"`
+}
+
+// NewPool creates an empty pool.
+func NewPool(verbose bool) *Pool {
+	return &Pool{
+		Verbose: verbose,
+		clients: make(map[string]*poolEntry),
+	}
+}
+
+// Get returns the client for the given server command and root, starting
+// the server if there is none yet. Concurrent callers for the same key wait
+// for a single start attempt, each until its ctx is done. A failed start
+// is not cached, and a server that crashed and was not restarted is
+// replaced by a new one.
+func (p *Pool) Get(ctx context.Context, serverCmd []string, rootDir string) (*Client, error) {
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.Join(serverCmd, "\x00") + "\x00\x00" + absRoot
+
+	p.mu.Lock()
+	e, ok := p.clients[key]
+	if ok && e.dead() {
+		delete(p.clients, key)
+		go e.client.Close()
+		ok = false
+	}
+	if !ok {
+		e = &poolEntry{
+			command: serverCmd,
+			root:    absRoot,
+			started: time.Now(),
+			ready:   make(chan struct{}),
+		}
+		p.clients[key] = e
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		e.client, e.err = StartClient(ctx, serverCmd, absRoot, p.Verbose, p.ServerLog)
+		if e.err == nil {
+			e.client.SetAutoRestart(true)
+		} else {
+			p.mu.Lock()
+			delete(p.clients, key)
+			p.mu.Unlock()
+		}
+		close(e.ready)
+	}
+
+	select {
+	case <-e.ready:
+		return e.client, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Entries lists the successfully started clients, ordered by root.
+func (p *Pool) Entries() []PoolEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var entries []PoolEntry
+	for _, e := range p.clients {
+		select {
+		case <-e.ready:
+		default:
+			continue // still starting
+		}
+		if e.client == nil {
+			continue
+		}
+		entry := PoolEntry{
+			Command:       e.command,
+			Root:          e.root,
+			Started:       e.started,
+			Ready:         e.client.Ready(),
+			Restarts:      e.client.Restarts(),
+			Notifications: e.client.NotificationStats(),
+		}
+		if err := e.client.Crashed(); err != nil {
+			entry.Error = err.Error()
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Root < entries[j].Root
+	})
+	return entries
+}
+
+// Close shuts down every server in the pool.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	entries := p.clients
+	p.clients = make(map[string]*poolEntry)
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, e := range entries {
+		wg.Add(1)
+		go func(e *poolEntry) {
+			defer wg.Done()
+			<-e.ready
+			if e.client != nil {
+				e.client.Close()
+			}
+		}(e)
+	}
+	wg.Wait()
+}
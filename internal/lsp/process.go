@@ -0,0 +1,448 @@
+package lsp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// stderrLines is how many of the server's last stderr lines are kept
+	// for error messages.
+	stderrLines = 20
+
+	// stderrLineBytes is how much of each stderr line is kept: its end,
+	// so a server writing without newlines does not grow it forever.
+	stderrLineBytes = 4 << 10
+
+	// crashGrace is how long a call that lost its connection waits for the
+	// server process to be reaped, to report why it exited.
+	crashGrace = time.Second
+
+	// restartTimeout bounds an automatic restart, including initialize.
+	restartTimeout = time.Minute
+
+	// At most maxRestarts automatic restarts are made in restartWindow, so
+	// a server that crashes on startup is not restarted forever.
+	maxRestarts   = 3
+	restartWindow = 5 * time.Minute
+)
+
+// ServerCrashedError reports that the language server exited while the
+// client was still using it.
+type ServerCrashedError struct {
+	Command  []string
+	ExitCode int      // -1 if the server was killed by a signal
+	State    string   // how it exited, e.g. "exit status 2" or "signal: killed"
+	Stderr   []string // the last lines the server wrote to stderr
+}
+
+func (e *ServerCrashedError) Error() string {
+	msg := fmt.Sprintf("language server %s exited unexpectedly (%s)", e.Command[0], e.State)
+	if len(e.Stderr) > 0 {
+		msg += "\nlast server stderr output:\n  " + strings.Join(e.Stderr, "\n  ")
+	}
+	return msg
+}
+
+// process is one run of a language server spawned by the client.
+type process struct {
+	cmd      *exec.Cmd
+	exited   chan struct{}       // closed once the process has exited
+	crash    *ServerCrashedError // why it exited, if unasked; set before exited is closed
+	stopping atomic.Bool         // set when the client ends the process itself
+}
+
+// spawn starts the server process and connects to it. The caller
+// initializes it.
+func (c *Client) spawn() (*process, *Conn, error) {
+	cmd := exec.Command(c.serverCmd[0], c.serverCmd[1:]...)
+	cmd.Dir = URIToPath(c.rootURI)
+	stderr := []io.Writer{c.stderr}
+	if c.serverLog != nil {
+		stderr = append(stderr, c.serverLog)
+	}
+	if c.verbose {
+		stderr = append(stderr, os.Stderr)
+	}
+	cmd.Stderr = io.MultiWriter(stderr...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("start server %q: %w", c.serverCmd[0], err)
+	}
+
+	p := &process{cmd: cmd, exited: make(chan struct{})}
+	conn := NewConn(NewTransport(stdout, stdin))
+	conn.NotificationHandler = c.handleNotification
+	conn.RequestHandler = c.handleRequest
+	go c.watch(p, conn)
+	return p, conn, nil
+}
+
+// watch waits for the server process to exit. Unless the client ended it,
+// the exit is recorded as a crash, the connection is closed so pending
+// calls fail, and the server is restarted if that is enabled.
+func (c *Client) watch(p *process, conn *Conn) {
+	err := p.cmd.Wait()
+	if !p.stopping.Load() {
+		p.crash = &ServerCrashedError{
+			Command:  c.serverCmd,
+			ExitCode: p.cmd.ProcessState.ExitCode(),
+			State:    p.cmd.ProcessState.String(),
+			Stderr:   c.stderr.Lines(),
+		}
+		if c.verbose {
+			fmt.Fprintf(os.Stderr, "server exited: %v\n", err)
+		}
+	}
+	conn.Close()
+	close(p.exited)
+
+	if p.crash == nil {
+		return
+	}
+	if c.autoRestart.Load() && c.allowRestart() {
+		ctx, cancel := context.WithTimeout(context.Background(), restartTimeout)
+		defer cancel()
+		err := c.restart(ctx, p)
+		if err == nil {
+			return
+		}
+		if c.verbose {
+			fmt.Fprintf(os.Stderr, "restart server: %v\n", err)
+		}
+	}
+	c.gaveUp.Store(true)
+}
+
+// dead reports whether the server has crashed and will not be restarted
+// on its own: automatic restarts are off, too many were made, or the
+// restart failed.
+func (c *Client) dead() bool {
+	return c.gaveUp.Load() && c.Crashed() != nil
+}
+
+// allowRestart records an automatic restart, unless there have already
+// been maxRestarts in restartWindow.
+func (c *Client) allowRestart() bool {
+	c.procMu.Lock()
+	defer c.procMu.Unlock()
+	var recent []time.Time
+	for _, t := range c.restarts {
+		if time.Since(t) < restartWindow {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= maxRestarts {
+		return false
+	}
+	c.restarts = append(recent, time.Now())
+	return true
+}
+
+// SetAutoRestart sets whether a server that crashes is restarted right
+// away (see Restart). Calls in flight when it crashes still fail.
+func (c *Client) SetAutoRestart(on bool) {
+	c.autoRestart.Store(on)
+}
+
+// Restart starts a new server process in place of the current one,
+// stopping it first if it is still running, initializes it and reopens
+// the documents that were open. Listeners and edit handlers carry over.
+// Only servers the client started itself can be restarted.
+func (c *Client) Restart(ctx context.Context) error {
+	return c.restart(ctx, nil)
+}
+
+// restart restarts the server. If crashed is set, only that process is
+// replaced; if it already has been, there is nothing to do.
+func (c *Client) restart(ctx context.Context, crashed *process) error {
+	c.restartMu.Lock()
+	defer c.restartMu.Unlock()
+
+	if c.serverCmd == nil {
+		return errors.New("cannot restart a server the client did not start")
+	}
+	if c.closed.Load() {
+		return errors.New("client is closed")
+	}
+
+	old, oldConn := c.current()
+	if crashed != nil && old != crashed {
+		return nil
+	}
+	old.stopping.Store(true)
+	oldConn.Close()
+	old.cmd.Process.Kill()
+	<-old.exited
+
+	p, conn, err := c.spawn()
+	if err != nil {
+		return err
+	}
+	// Until the new server is initialized and has the documents, callers
+	// keep the old connection and fail as they would have.
+	stop := func() {
+		p.stopping.Store(true)
+		conn.Close()
+		p.cmd.Process.Kill()
+		<-p.exited
+	}
+
+	// Callers waiting for the old server to be ready go on waiting for
+	// the new one.
+	c.progressMu.Lock()
+	if c.progClosed {
+		c.progDone, c.progClosed = make(chan struct{}), false
+	}
+	c.progressMu.Unlock()
+
+	caps, err := c.initialize(ctx, p, conn)
+	if err != nil {
+		stop()
+		return fmt.Errorf("initialize: %w", err)
+	}
+
+	// Documents are held until the new connection is in place, so none
+	// opened meanwhile is missed.
+	c.docsMu.Lock()
+	defer c.docsMu.Unlock()
+	for uri, doc := range c.docs {
+		c.markStale(uri)
+		params := DidOpenTextDocumentParams{
+			TextDocument: TextDocumentItem{
+				URI:        uri,
+				LanguageID: doc.languageID,
+				Version:    doc.version,
+				Text:       doc.text,
+			},
+		}
+		if err := c.notifyOn(ctx, p, conn, "textDocument/didOpen", params); err != nil {
+			stop()
+			return fmt.Errorf("reopen %s: %w", URIToPath(uri), err)
+		}
+	}
+
+	c.procMu.Lock()
+	c.proc, c.conn, c.capabilities = p, conn, caps
+	c.restartCount++
+	c.procMu.Unlock()
+
+	if c.verbose {
+		fmt.Fprintf(os.Stderr, "server restarted, %d documents reopened\n", len(c.docs))
+	}
+	return nil
+}
+
+// Crashed returns the ServerCrashedError of the current server process
+// if it has exited on its own, or nil.
+func (c *Client) Crashed() error {
+	p, _ := c.current()
+	if p == nil {
+		return nil
+	}
+	select {
+	case <-p.exited:
+		if p.crash != nil {
+			return p.crash
+		}
+	default:
+	}
+	return nil
+}
+
+// Restarts returns how many times the server has been restarted.
+func (c *Client) Restarts() int {
+	c.procMu.Lock()
+	defer c.procMu.Unlock()
+	return c.restartCount
+}
+
+// current returns the server process (nil when connected over a stream)
+// and the connection to it.
+func (c *Client) current() (*process, *Conn) {
+	c.procMu.Lock()
+	defer c.procMu.Unlock()
+	return c.proc, c.conn
+}
+
+// call sends a request to the current server. If the connection fails
+// because the server crashed, the ServerCrashedError is returned instead.
+func (c *Client) call(ctx context.Context, method string, params interface{}) (json.RawMessage, error) {
+	p, conn := c.current()
+	return c.callOn(ctx, p, conn, method, params)
+}
+
+// callOn is call over conn, the connection to p.
+func (c *Client) callOn(ctx context.Context, p *process, conn *Conn, method string, params interface{}) (json.RawMessage, error) {
+	result, err := conn.CallContext(ctx, method, params)
+	if err != nil {
+		return nil, c.connError(ctx, p, err)
+	}
+	return result, nil
+}
+
+// notify sends a notification to the current server, like call.
+func (c *Client) notify(ctx context.Context, method string, params interface{}) error {
+	p, conn := c.current()
+	return c.notifyOn(ctx, p, conn, method, params)
+}
+
+// notifyOn is notify over conn, the connection to p.
+func (c *Client) notifyOn(ctx context.Context, p *process, conn *Conn, method string, params interface{}) error {
+	if err := conn.NotifyContext(ctx, method, params); err != nil {
+		return c.connError(ctx, p, err)
+	}
+	return nil
+}
+
+// connError replaces an error that came from losing the connection to p
+// with the reason p exited, if it crashed. A server that does not answer
+// in time may have said why on stderr, so that is added to a timeout.
+func (c *Client) connError(ctx context.Context, p *process, err error) error {
+	var rerr *ResponseError
+	if p == nil || errors.As(err, &rerr) {
+		return err
+	}
+	if ctx.Err() != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return c.withStderr(err)
+		}
+		return err
+	}
+	timer := time.NewTimer(crashGrace)
+	defer timer.Stop()
+	select {
+	case <-p.exited:
+		if p.crash != nil {
+			return p.crash
+		}
+	case <-timer.C:
+	}
+	return err
+}
+
+// StderrError is an error from a server that was still running, with the
+// last lines it wrote to stderr, which often say what went wrong.
+type StderrError struct {
+	Err    error
+	Stderr []string
+}
+
+func (e *StderrError) Error() string {
+	return e.Err.Error() + "\nlast server stderr output:\n  " + strings.Join(e.Stderr, "\n  ")
+}
+
+func (e *StderrError) Unwrap() error { return e.Err }
+
+// withStderr adds the server's last stderr lines to err, unless there are
+// none or err already has them.
+func (c *Client) withStderr(err error) error {
+	var crash *ServerCrashedError
+	var serr *StderrError
+	if c.stderr == nil || errors.As(err, &crash) || errors.As(err, &serr) {
+		return err
+	}
+	lines := c.stderr.Lines()
+	if len(lines) == 0 {
+		return err
+	}
+	return &StderrError{Err: err, Stderr: lines}
+}
+
+// logWriter passes writes on to w until one fails, which is reported once;
+// later writes are dropped. Either way it reports success, since a write
+// error would stop exec copying the server's stderr, and a server whose
+// stderr is not read blocks once the pipe fills.
+type logWriter struct {
+	w      io.Writer
+	failed atomic.Bool
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	if l.failed.Load() {
+		return len(p), nil
+	}
+	if _, err := l.w.Write(p); err != nil && !l.failed.Swap(true) {
+		fmt.Fprintf(os.Stderr, "warning: server log: %v; no longer writing to it\n", err)
+	}
+	return len(p), nil
+}
+
+// lineRing keeps the last lines written to it, each cut to its last
+// stderrLineBytes.
+type lineRing struct {
+	mu      sync.Mutex
+	lines   []string
+	max     int
+	partial []byte
+}
+
+func newLineRing(max int) *lineRing {
+	return &lineRing{max: max}
+}
+
+func (r *lineRing) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := append(r.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		r.add(strings.TrimRight(string(data[:i]), "\r"))
+		data = data[i+1:]
+	}
+	r.partial = append([]byte(nil), lastBytes(data)...)
+	return len(p), nil
+}
+
+func (r *lineRing) add(line string) {
+	r.lines = append(r.lines, string(lastBytes([]byte(line))))
+	if len(r.lines) > r.max {
+		r.lines = r.lines[len(r.lines)-r.max:]
+	}
+}
+
+// lastBytes returns the last stderrLineBytes of b, starting at a rune.
+func lastBytes(b []byte) []byte {
+	if len(b) <= stderrLineBytes {
+		return b
+	}
+	b = b[len(b)-stderrLineBytes:]
+	for len(b) > 0 && !utf8.RuneStart(b[0]) {
+		b = b[1:]
+	}
+	return b
+}
+
+// Lines returns the kept lines, oldest first, including an unfinished
+// last line.
+func (r *lineRing) Lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lines := append([]string(nil), r.lines...)
+	if len(r.partial) > 0 {
+		lines = append(lines, string(r.partial))
+	}
+	return lines
+}
@@ -0,0 +1,96 @@
+package lsp
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestLineRing(t *testing.T) {
+	r := newLineRing(3)
+	for _, s := range []string{"one\ntw", "o\r\nthree\n", "four\nfi", "ve"} {
+		if n, err := r.Write([]byte(s)); n != len(s) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", s, n, err)
+		}
+	}
+	// The last three lines are kept, and the unfinished one besides.
+	if got := strings.Join(r.Lines(), "|"); got != "two|three|four|five" {
+		t.Errorf("lines = %s, want two|three|four|five", got)
+	}
+}
+
+// TestLineRingLongLines checks that only the end of a long line is kept,
+// finished or not, cut at a rune.
+func TestLineRingLongLines(t *testing.T) {
+	r := newLineRing(3)
+	long := strings.Repeat("é", stderrLineBytes) // two bytes each
+	for i := 0; i < 4; i++ {
+		r.Write([]byte(long))
+	}
+	if n := len(r.partial); n > stderrLineBytes || n < stderrLineBytes-1 {
+		t.Errorf("unfinished line kept %d bytes, want about %d", n, stderrLineBytes)
+	}
+	r.Write([]byte("!\nshort\n"))
+	lines := r.Lines()
+	if len(lines) != 2 || lines[1] != "short" {
+		t.Fatalf("lines = %d, want the long one and short", len(lines))
+	}
+	if l := lines[0]; len(l) > stderrLineBytes || !utf8.ValidString(l) || !strings.HasSuffix(l, "é!") {
+		t.Errorf("long line kept as %d bytes, valid %v, want its end", len(l), utf8.ValidString(l))
+	}
+}
+
+func TestWithStderr(t *testing.T) {
+	c := &Client{stderr: newLineRing(stderrLines)}
+	base := errors.New("initialize: timed out")
+	if err := c.withStderr(base); err != base {
+		t.Errorf("without stderr: %v, want the error unchanged", err)
+	}
+
+	c.stderr.Write([]byte("go.mod file not found\n"))
+	err := c.withStderr(base)
+	var serr *StderrError
+	if !errors.As(err, &serr) || !errors.Is(err, base) {
+		t.Fatalf("withStderr = %#v, want a StderrError wrapping the error", err)
+	}
+	if want := "initialize: timed out\nlast server stderr output:\n  go.mod file not found"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+
+	// Errors that carry stderr already are not wrapped again.
+	if again := c.withStderr(err); again != err {
+		t.Errorf("wrapped twice: %v", again)
+	}
+	crash := &ServerCrashedError{ExitCode: 2, Stderr: []string{"panic"}}
+	if got := c.withStderr(crash); got != crash {
+		t.Errorf("crash wrapped: %v", got)
+	}
+}
+
+// failingWriter fails every write after the first ok ones.
+type failingWriter struct {
+	ok     int
+	writes []string
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	w.writes = append(w.writes, string(p))
+	if len(w.writes) > w.ok {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func TestLogWriter(t *testing.T) {
+	w := &failingWriter{ok: 1}
+	l := &logWriter{w: w}
+	for _, s := range []string{"a", "b", "c"} {
+		if n, err := l.Write([]byte(s)); n != 1 || err != nil {
+			t.Errorf("Write(%q) = %d, %v; want success whatever the log does", s, n, err)
+		}
+	}
+	if got := strings.Join(w.writes, ""); got != "ab" {
+		t.Errorf("log got %q, want writes up to the failing one", got)
+	}
+}
@@ -2,7 +2,11 @@
 // Uses only stdlib - no external dependencies.
 package lsp
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
 
 // Position in a text document (0-indexed).
 type Position struct {
@@ -84,18 +88,23 @@ type Hover struct {
 
 // HoverContents extracts the hover text, handling both MarkupContent and string forms.
 func (h *Hover) HoverContents() string {
+	return markupText(h.Contents)
+}
+
+// markupText returns the text of a MarkupContent or plain string value.
+func markupText(raw json.RawMessage) string {
 	// Try MarkupContent first
 	var mc MarkupContent
-	if err := json.Unmarshal(h.Contents, &mc); err == nil && mc.Value != "" {
+	if err := json.Unmarshal(raw, &mc); err == nil && mc.Value != "" {
 		return mc.Value
 	}
 	// Try plain string
 	var s string
-	if err := json.Unmarshal(h.Contents, &s); err == nil {
+	if err := json.Unmarshal(raw, &s); err == nil {
 		return s
 	}
 	// Fallback: return raw
-	return string(h.Contents)
+	return string(raw)
 }
 
 // DocumentSymbolParams for textDocument/documentSymbol.
@@ -198,6 +207,450 @@ type ImplementationParams struct {
 	TextDocumentPositionParams
 }
 
+// TypeDefinitionParams for textDocument/typeDefinition.
+type TypeDefinitionParams struct {
+	TextDocumentPositionParams
+}
+
+// DeclarationParams for textDocument/declaration.
+type DeclarationParams struct {
+	TextDocumentPositionParams
+}
+
+// TextEdit is a textual edit applicable to a text document.
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+// OptionalVersionedTextDocumentIdentifier identifies a document at a
+// version, or at any version when Version is nil.
+type OptionalVersionedTextDocumentIdentifier struct {
+	URI     string `json:"uri"`
+	Version *int   `json:"version"`
+}
+
+// TextDocumentEdit describes edits to a single document.
+type TextDocumentEdit struct {
+	TextDocument OptionalVersionedTextDocumentIdentifier `json:"textDocument"`
+	Edits        []TextEdit                              `json:"edits"`
+}
+
+// WorkspaceEdit represents changes to many documents. Servers send either
+// Changes or DocumentChanges; the latter may also contain file create,
+// rename and delete operations, which are kept raw.
+type WorkspaceEdit struct {
+	Changes         map[string][]TextEdit `json:"changes,omitempty"`
+	DocumentChanges []json.RawMessage     `json:"documentChanges,omitempty"`
+}
+
+// FileEdits returns the text edits of the workspace edit keyed by URI.
+// As the specification asks, documentChanges is used if present and
+// changes otherwise; servers may send both, with the same edits. File
+// resource operations are not supported and cause an error.
+func (we *WorkspaceEdit) FileEdits() (map[string][]TextEdit, error) {
+	edits := make(map[string][]TextEdit)
+	if len(we.DocumentChanges) == 0 {
+		for uri, e := range we.Changes {
+			edits[uri] = append(edits[uri], e...)
+		}
+		return edits, nil
+	}
+	for _, raw := range we.DocumentChanges {
+		var op struct {
+			Kind string `json:"kind"`
+		}
+		if err := json.Unmarshal(raw, &op); err != nil {
+			return nil, fmt.Errorf("unmarshal document change: %w", err)
+		}
+		if op.Kind != "" {
+			return nil, fmt.Errorf("unsupported file operation %q in workspace edit", op.Kind)
+		}
+		var tde TextDocumentEdit
+		if err := json.Unmarshal(raw, &tde); err != nil {
+			return nil, fmt.Errorf("unmarshal text document edit: %w", err)
+		}
+		edits[tde.TextDocument.URI] = append(edits[tde.TextDocument.URI], tde.Edits...)
+	}
+	return edits, nil
+}
+
+// PrepareRenameParams for textDocument/prepareRename.
+type PrepareRenameParams struct {
+	TextDocumentPositionParams
+}
+
+// PrepareRenameResult is the range of the symbol to rename and an
+// optional placeholder (usually the current name).
+type PrepareRenameResult struct {
+	Range       Range  `json:"range"`
+	Placeholder string `json:"placeholder,omitempty"`
+}
+
+// RenameParams for textDocument/rename.
+type RenameParams struct {
+	TextDocumentPositionParams
+	NewName string `json:"newName"`
+}
+
+// CompletionParams for textDocument/completion.
+type CompletionParams struct {
+	TextDocumentPositionParams
+	Context *CompletionContext `json:"context,omitempty"`
+}
+
+// CompletionContext says how completion was triggered; 1 means invoked
+// explicitly rather than by typing a trigger character.
+type CompletionContext struct {
+	TriggerKind int `json:"triggerKind"`
+}
+
+// CompletionList is a possibly incomplete list of completion items.
+type CompletionList struct {
+	IsIncomplete bool             `json:"isIncomplete"`
+	Items        []CompletionItem `json:"items"`
+}
+
+// CompletionItem is one completion candidate. Documentation is either a
+// string or MarkupContent; TextEdit is a TextEdit or InsertReplaceEdit.
+type CompletionItem struct {
+	Label         string             `json:"label"`
+	Kind          CompletionItemKind `json:"kind,omitempty"`
+	Detail        string             `json:"detail,omitempty"`
+	Documentation json.RawMessage    `json:"documentation,omitempty"`
+	SortText      string             `json:"sortText,omitempty"`
+	FilterText    string             `json:"filterText,omitempty"`
+	InsertText    string             `json:"insertText,omitempty"`
+	TextEdit      json.RawMessage    `json:"textEdit,omitempty"`
+	Data          json.RawMessage    `json:"data,omitempty"`
+}
+
+// DocumentationText returns the item's documentation as text.
+func (ci *CompletionItem) DocumentationText() string {
+	if len(ci.Documentation) == 0 {
+		return ""
+	}
+	return markupText(ci.Documentation)
+}
+
+// InsertString returns the text inserted when the item is accepted: the
+// text edit's new text, the insert text, or else the label.
+func (ci *CompletionItem) InsertString() string {
+	var edit struct {
+		NewText string `json:"newText"`
+	}
+	if len(ci.TextEdit) > 0 && json.Unmarshal(ci.TextEdit, &edit) == nil && edit.NewText != "" {
+		return edit.NewText
+	}
+	if ci.InsertText != "" {
+		return ci.InsertText
+	}
+	return ci.Label
+}
+
+// CompletionItemKind represents the kind of a completion item.
+type CompletionItemKind int
+
+var completionItemKindNames = map[CompletionItemKind]string{
+	1:  "text",
+	2:  "method",
+	3:  "function",
+	4:  "constructor",
+	5:  "field",
+	6:  "variable",
+	7:  "class",
+	8:  "interface",
+	9:  "module",
+	10: "property",
+	11: "unit",
+	12: "value",
+	13: "enum",
+	14: "keyword",
+	15: "snippet",
+	16: "color",
+	17: "file",
+	18: "reference",
+	19: "folder",
+	20: "enum_member",
+	21: "constant",
+	22: "struct",
+	23: "event",
+	24: "operator",
+	25: "type_parameter",
+}
+
+func (k CompletionItemKind) String() string {
+	if name, ok := completionItemKindNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// SignatureHelpParams for textDocument/signatureHelp.
+type SignatureHelpParams struct {
+	TextDocumentPositionParams
+}
+
+// SignatureHelp describes the signatures of the call at a position and
+// which signature and parameter are active.
+type SignatureHelp struct {
+	Signatures      []SignatureInformation `json:"signatures"`
+	ActiveSignature *int                   `json:"activeSignature,omitempty"`
+	ActiveParameter *int                   `json:"activeParameter,omitempty"`
+}
+
+// SignatureInformation is one signature (overload) of a callable. Its
+// ActiveParameter, if set, overrides the one in SignatureHelp.
+type SignatureInformation struct {
+	Label           string                 `json:"label"`
+	Documentation   json.RawMessage        `json:"documentation,omitempty"`
+	Parameters      []ParameterInformation `json:"parameters,omitempty"`
+	ActiveParameter *int                   `json:"activeParameter,omitempty"`
+}
+
+// DocumentationText returns the signature's documentation as text.
+func (si *SignatureInformation) DocumentationText() string {
+	if len(si.Documentation) == 0 {
+		return ""
+	}
+	return markupText(si.Documentation)
+}
+
+// ParameterInformation is a parameter of a signature. Its label is either
+// a substring of the signature label or [start, end] offsets into it.
+type ParameterInformation struct {
+	Label         json.RawMessage `json:"label"`
+	Documentation json.RawMessage `json:"documentation,omitempty"`
+}
+
+// DocumentationText returns the parameter's documentation as text.
+func (pi *ParameterInformation) DocumentationText() string {
+	if len(pi.Documentation) == 0 {
+		return ""
+	}
+	return markupText(pi.Documentation)
+}
+
+// ParameterSpans returns the byte offsets of each parameter within the
+// signature label, or -1, -1 for one that cannot be found.
+func (si *SignatureInformation) ParameterSpans() [][2]int {
+	spans := make([][2]int, len(si.Parameters))
+	// A parameter given by name is looked for after the previous one, or
+	// the opening parenthesis, so x in max(x, y) is not found in "max".
+	from := strings.IndexByte(si.Label, '(') + 1
+	for i := range si.Parameters {
+		start, end := si.Parameters[i].span(si.Label, from)
+		spans[i] = [2]int{start, end}
+		if start >= 0 {
+			from = end
+		}
+	}
+	return spans
+}
+
+// span returns the byte offsets of the parameter within the signature
+// label, or -1, -1 if it cannot be found. A label given by name is looked
+// for from byte offset from; offset labels count UTF-16 code units and are
+// converted.
+func (pi *ParameterInformation) span(signature string, from int) (start, end int) {
+	var name string
+	if err := json.Unmarshal(pi.Label, &name); err == nil {
+		i := strings.Index(signature[from:], name)
+		if i < 0 || name == "" {
+			return -1, -1
+		}
+		return from + i, from + i + len(name)
+	}
+	var offsets [2]int
+	if err := json.Unmarshal(pi.Label, &offsets); err != nil {
+		return -1, -1
+	}
+	start, end = -1, -1
+	units := 0
+	for i, r := range signature {
+		if units == offsets[0] {
+			start = i
+		}
+		if units == offsets[1] {
+			end = i
+		}
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+	}
+	if units == offsets[1] {
+		end = len(signature)
+	}
+	if start < 0 || end < start {
+		return -1, -1
+	}
+	return start, end
+}
+
+// FormattingOptions describe the indentation a formatter should use.
+type FormattingOptions struct {
+	TabSize                int  `json:"tabSize"`
+	InsertSpaces           bool `json:"insertSpaces"`
+	TrimTrailingWhitespace bool `json:"trimTrailingWhitespace,omitempty"`
+	InsertFinalNewline     bool `json:"insertFinalNewline,omitempty"`
+	TrimFinalNewlines      bool `json:"trimFinalNewlines,omitempty"`
+}
+
+// DocumentFormattingParams for textDocument/formatting.
+type DocumentFormattingParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Options      FormattingOptions      `json:"options"`
+}
+
+// DocumentRangeFormattingParams for textDocument/rangeFormatting.
+type DocumentRangeFormattingParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+	Options      FormattingOptions      `json:"options"`
+}
+
+// CodeActionParams for textDocument/codeAction.
+type CodeActionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+	Context      CodeActionContext      `json:"context"`
+}
+
+// CodeActionContext carries the diagnostics overlapping the requested
+// range and, optionally, the kinds of action wanted.
+type CodeActionContext struct {
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	Only        []string     `json:"only,omitempty"`
+}
+
+// CodeAction is a quick fix, refactoring or source action. An action
+// carries an edit, a command to execute after the edit, or both; servers
+// that support codeAction/resolve may leave the edit out until resolved.
+type CodeAction struct {
+	Title       string              `json:"title"`
+	Kind        string              `json:"kind,omitempty"`
+	Diagnostics []Diagnostic        `json:"diagnostics,omitempty"`
+	IsPreferred bool                `json:"isPreferred,omitempty"`
+	Disabled    *CodeActionDisabled `json:"disabled,omitempty"`
+	Edit        *WorkspaceEdit      `json:"edit,omitempty"`
+	Command     *Command            `json:"command,omitempty"`
+	Data        json.RawMessage     `json:"data,omitempty"`
+}
+
+// CodeActionDisabled explains why a code action cannot be applied.
+type CodeActionDisabled struct {
+	Reason string `json:"reason"`
+}
+
+// Command is a server command, run with workspace/executeCommand.
+type Command struct {
+	Title     string            `json:"title"`
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments,omitempty"`
+}
+
+// ExecuteCommandParams for workspace/executeCommand.
+type ExecuteCommandParams struct {
+	Command   string            `json:"command"`
+	Arguments []json.RawMessage `json:"arguments,omitempty"`
+}
+
+// ApplyWorkspaceEditParams for the server's workspace/applyEdit request.
+type ApplyWorkspaceEditParams struct {
+	Label string        `json:"label,omitempty"`
+	Edit  WorkspaceEdit `json:"edit"`
+}
+
+// ApplyWorkspaceEditResult answers workspace/applyEdit.
+type ApplyWorkspaceEditResult struct {
+	Applied       bool   `json:"applied"`
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// ConfigurationParams for the server's workspace/configuration request.
+// The reply holds one settings value per item, in order.
+type ConfigurationParams struct {
+	Items []ConfigurationItem `json:"items"`
+}
+
+// ConfigurationItem names a settings section, optionally for a resource.
+type ConfigurationItem struct {
+	ScopeURI string `json:"scopeUri,omitempty"`
+	Section  string `json:"section,omitempty"`
+}
+
+// CallHierarchyPrepareParams for textDocument/prepareCallHierarchy.
+type CallHierarchyPrepareParams struct {
+	TextDocumentPositionParams
+}
+
+// CallHierarchyItem is a function, method or other callable in a call
+// hierarchy. Data is kept raw and passed back to the server unchanged.
+type CallHierarchyItem struct {
+	Name           string          `json:"name"`
+	Kind           SymbolKind      `json:"kind"`
+	Tags           []int           `json:"tags,omitempty"`
+	Detail         string          `json:"detail,omitempty"`
+	URI            string          `json:"uri"`
+	Range          Range           `json:"range"`
+	SelectionRange Range           `json:"selectionRange"`
+	Data           json.RawMessage `json:"data,omitempty"`
+}
+
+// CallHierarchyIncomingCallsParams for callHierarchy/incomingCalls.
+type CallHierarchyIncomingCallsParams struct {
+	Item CallHierarchyItem `json:"item"`
+}
+
+// CallHierarchyIncomingCall is a caller of an item. FromRanges are the
+// call sites, in the caller's document.
+type CallHierarchyIncomingCall struct {
+	From       CallHierarchyItem `json:"from"`
+	FromRanges []Range           `json:"fromRanges"`
+}
+
+// CallHierarchyOutgoingCallsParams for callHierarchy/outgoingCalls.
+type CallHierarchyOutgoingCallsParams struct {
+	Item CallHierarchyItem `json:"item"`
+}
+
+// CallHierarchyOutgoingCall is a callee of an item. FromRanges are the
+// call sites, in the calling item's document.
+type CallHierarchyOutgoingCall struct {
+	To         CallHierarchyItem `json:"to"`
+	FromRanges []Range           `json:"fromRanges"`
+}
+
+// TypeHierarchyPrepareParams for textDocument/prepareTypeHierarchy.
+type TypeHierarchyPrepareParams struct {
+	TextDocumentPositionParams
+}
+
+// TypeHierarchyItem is a class, interface or other type in a type
+// hierarchy. Data is kept raw and passed back to the server unchanged.
+type TypeHierarchyItem struct {
+	Name           string          `json:"name"`
+	Kind           SymbolKind      `json:"kind"`
+	Tags           []int           `json:"tags,omitempty"`
+	Detail         string          `json:"detail,omitempty"`
+	URI            string          `json:"uri"`
+	Range          Range           `json:"range"`
+	SelectionRange Range           `json:"selectionRange"`
+	Data           json.RawMessage `json:"data,omitempty"`
+}
+
+// TypeHierarchySupertypesParams for typeHierarchy/supertypes.
+type TypeHierarchySupertypesParams struct {
+	Item TypeHierarchyItem `json:"item"`
+}
+
+// TypeHierarchySubtypesParams for typeHierarchy/subtypes.
+type TypeHierarchySubtypesParams struct {
+	Item TypeHierarchyItem `json:"item"`
+}
+
 // DiagnosticSeverity represents the severity of a diagnostic.
 type DiagnosticSeverity int
 
@@ -224,32 +677,94 @@ func (ds DiagnosticSeverity) String() string {
 }
 
 // Diagnostic represents a diagnostic (error, warning, etc.).
+// Code (a number or a string), related information and data are kept so
+// diagnostics can be passed back to the server in a code action request.
 type Diagnostic struct {
-	Range    Range              `json:"range"`
-	Severity DiagnosticSeverity `json:"severity,omitempty"`
-	Source   string             `json:"source,omitempty"`
-	Message  string             `json:"message"`
+	Range              Range              `json:"range"`
+	Severity           DiagnosticSeverity `json:"severity,omitempty"`
+	Code               interface{}        `json:"code,omitempty"`
+	Source             string             `json:"source,omitempty"`
+	Message            string             `json:"message"`
+	Tags               []int              `json:"tags,omitempty"`
+	RelatedInformation json.RawMessage    `json:"relatedInformation,omitempty"`
+	Data               json.RawMessage    `json:"data,omitempty"`
 }
 
 // PublishDiagnosticsParams is sent from server to client.
 type PublishDiagnosticsParams struct {
 	URI         string       `json:"uri"`
+	Version     *int         `json:"version,omitempty"`
 	Diagnostics []Diagnostic `json:"diagnostics"`
 }
 
+// DocumentDiagnosticParams for textDocument/diagnostic.
+type DocumentDiagnosticParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// DocumentDiagnosticReport is the result of textDocument/diagnostic. Kind
+// is "full" (Items is complete) or "unchanged" (since a previous result,
+// which lsp-cli never sends). Related documents, keyed by URI, carry
+// diagnostics the change to this document caused in others.
+type DocumentDiagnosticReport struct {
+	Kind             string                              `json:"kind"`
+	Items            []Diagnostic                        `json:"items,omitempty"`
+	RelatedDocuments map[string]DocumentDiagnosticReport `json:"relatedDocuments,omitempty"`
+}
+
+// WorkspaceDiagnosticParams for workspace/diagnostic.
+type WorkspaceDiagnosticParams struct {
+	PreviousResultIDs []json.RawMessage `json:"previousResultIds"`
+}
+
+// WorkspaceDiagnosticReport is the result of workspace/diagnostic.
+type WorkspaceDiagnosticReport struct {
+	Items []WorkspaceDocumentDiagnosticReport `json:"items"`
+}
+
+// WorkspaceDocumentDiagnosticReport is the report for one document in a
+// workspace diagnostic report.
+type WorkspaceDocumentDiagnosticReport struct {
+	URI   string       `json:"uri"`
+	Kind  string       `json:"kind"`
+	Items []Diagnostic `json:"items,omitempty"`
+}
+
 // --- Initialize types ---
 
 type ClientCapabilities struct {
+	Workspace    *WorkspaceClientCapabilities    `json:"workspace,omitempty"`
 	TextDocument *TextDocumentClientCapabilities `json:"textDocument,omitempty"`
 }
 
+type WorkspaceClientCapabilities struct {
+	ApplyEdit     bool                             `json:"applyEdit,omitempty"`
+	Configuration bool                             `json:"configuration,omitempty"`
+	WorkspaceEdit *WorkspaceEditClientCapabilities `json:"workspaceEdit,omitempty"`
+}
+
+type WorkspaceEditClientCapabilities struct {
+	DocumentChanges bool `json:"documentChanges,omitempty"`
+}
+
 type TextDocumentClientCapabilities struct {
-	Definition      *DefinitionClientCapabilities      `json:"definition,omitempty"`
-	References      *ReferencesClientCapabilities       `json:"references,omitempty"`
-	Hover           *HoverClientCapabilities            `json:"hover,omitempty"`
-	DocumentSymbol  *DocumentSymbolClientCapabilities   `json:"documentSymbol,omitempty"`
-	Implementation  *ImplementationClientCapabilities   `json:"implementation,omitempty"`
+	Definition         *DefinitionClientCapabilities         `json:"definition,omitempty"`
+	References         *ReferencesClientCapabilities         `json:"references,omitempty"`
+	Hover              *HoverClientCapabilities              `json:"hover,omitempty"`
+	DocumentSymbol     *DocumentSymbolClientCapabilities     `json:"documentSymbol,omitempty"`
+	Implementation     *ImplementationClientCapabilities     `json:"implementation,omitempty"`
+	TypeDefinition     *TypeDefinitionClientCapabilities     `json:"typeDefinition,omitempty"`
+	Declaration        *DeclarationClientCapabilities        `json:"declaration,omitempty"`
+	CodeAction         *CodeActionClientCapabilities         `json:"codeAction,omitempty"`
+	Completion         *CompletionClientCapabilities         `json:"completion,omitempty"`
+	SignatureHelp      *SignatureHelpClientCapabilities      `json:"signatureHelp,omitempty"`
+	Formatting         *FormattingClientCapabilities         `json:"formatting,omitempty"`
+	Diagnostic         *DiagnosticClientCapabilities         `json:"diagnostic,omitempty"`
+	RangeFormatting    *RangeFormattingClientCapabilities    `json:"rangeFormatting,omitempty"`
 	PublishDiagnostics *PublishDiagnosticsClientCapabilities `json:"publishDiagnostics,omitempty"`
+	Rename             *RenameClientCapabilities             `json:"rename,omitempty"`
+	CallHierarchy      *CallHierarchyClientCapabilities      `json:"callHierarchy,omitempty"`
+	TypeHierarchy      *TypeHierarchyClientCapabilities      `json:"typeHierarchy,omitempty"`
 }
 
 type DefinitionClientCapabilities struct {
@@ -270,10 +785,76 @@ type ImplementationClientCapabilities struct {
 	LinkSupport bool `json:"linkSupport,omitempty"`
 }
 
+type TypeDefinitionClientCapabilities struct {
+	LinkSupport bool `json:"linkSupport,omitempty"`
+}
+
+type DeclarationClientCapabilities struct {
+	LinkSupport bool `json:"linkSupport,omitempty"`
+}
+
+type CodeActionClientCapabilities struct {
+	CodeActionLiteralSupport *CodeActionLiteralSupport `json:"codeActionLiteralSupport,omitempty"`
+	IsPreferredSupport       bool                      `json:"isPreferredSupport,omitempty"`
+	DisabledSupport          bool                      `json:"disabledSupport,omitempty"`
+	DataSupport              bool                      `json:"dataSupport,omitempty"`
+	ResolveSupport           *ResolveSupport           `json:"resolveSupport,omitempty"`
+}
+
+type CodeActionLiteralSupport struct {
+	CodeActionKind struct {
+		ValueSet []string `json:"valueSet"`
+	} `json:"codeActionKind"`
+}
+
+type ResolveSupport struct {
+	Properties []string `json:"properties"`
+}
+
+type CompletionClientCapabilities struct {
+	CompletionItem *CompletionItemCapabilities `json:"completionItem,omitempty"`
+}
+
+type CompletionItemCapabilities struct {
+	SnippetSupport      bool            `json:"snippetSupport"`
+	DocumentationFormat []string        `json:"documentationFormat,omitempty"`
+	ResolveSupport      *ResolveSupport `json:"resolveSupport,omitempty"`
+}
+
+type SignatureHelpClientCapabilities struct {
+	SignatureInformation *SignatureInformationCapabilities `json:"signatureInformation,omitempty"`
+}
+
+type SignatureInformationCapabilities struct {
+	DocumentationFormat    []string                          `json:"documentationFormat,omitempty"`
+	ParameterInformation   *ParameterInformationCapabilities `json:"parameterInformation,omitempty"`
+	ActiveParameterSupport bool                              `json:"activeParameterSupport,omitempty"`
+}
+
+type ParameterInformationCapabilities struct {
+	LabelOffsetSupport bool `json:"labelOffsetSupport"`
+}
+
+type FormattingClientCapabilities struct{}
+
+type DiagnosticClientCapabilities struct {
+	RelatedDocumentSupport bool `json:"relatedDocumentSupport,omitempty"`
+}
+
+type RangeFormattingClientCapabilities struct{}
+
 type PublishDiagnosticsClientCapabilities struct {
 	RelatedInformation bool `json:"relatedInformation,omitempty"`
 }
 
+type RenameClientCapabilities struct {
+	PrepareSupport bool `json:"prepareSupport,omitempty"`
+}
+
+type CallHierarchyClientCapabilities struct{}
+
+type TypeHierarchyClientCapabilities struct{}
+
 type InitializeParams struct {
 	ProcessID    int                `json:"processId"`
 	RootURI      string             `json:"rootUri"`
@@ -281,12 +862,99 @@ type InitializeParams struct {
 }
 
 type ServerCapabilities struct {
-	DefinitionProvider     interface{} `json:"definitionProvider,omitempty"`
-	ReferencesProvider     interface{} `json:"referencesProvider,omitempty"`
-	HoverProvider          interface{} `json:"hoverProvider,omitempty"`
-	DocumentSymbolProvider interface{} `json:"documentSymbolProvider,omitempty"`
-	ImplementationProvider interface{} `json:"implementationProvider,omitempty"`
-	WorkspaceSymbolProvider interface{} `json:"workspaceSymbolProvider,omitempty"`
+	DefinitionProvider              interface{} `json:"definitionProvider,omitempty"`
+	ReferencesProvider              interface{} `json:"referencesProvider,omitempty"`
+	HoverProvider                   interface{} `json:"hoverProvider,omitempty"`
+	DocumentSymbolProvider          interface{} `json:"documentSymbolProvider,omitempty"`
+	ImplementationProvider          interface{} `json:"implementationProvider,omitempty"`
+	TypeDefinitionProvider          interface{} `json:"typeDefinitionProvider,omitempty"`
+	DeclarationProvider             interface{} `json:"declarationProvider,omitempty"`
+	WorkspaceSymbolProvider         interface{} `json:"workspaceSymbolProvider,omitempty"`
+	RenameProvider                  interface{} `json:"renameProvider,omitempty"`
+	CallHierarchyProvider           interface{} `json:"callHierarchyProvider,omitempty"`
+	TypeHierarchyProvider           interface{} `json:"typeHierarchyProvider,omitempty"`
+	CompletionProvider              interface{} `json:"completionProvider,omitempty"`
+	SignatureHelpProvider           interface{} `json:"signatureHelpProvider,omitempty"`
+	CodeActionProvider              interface{} `json:"codeActionProvider,omitempty"`
+	ExecuteCommandProvider          interface{} `json:"executeCommandProvider,omitempty"`
+	DocumentFormattingProvider      interface{} `json:"documentFormattingProvider,omitempty"`
+	DocumentRangeFormattingProvider interface{} `json:"documentRangeFormattingProvider,omitempty"`
+	DiagnosticProvider              interface{} `json:"diagnosticProvider,omitempty"`
+}
+
+// SupportsCompletionResolve reports whether the server answers
+// completionItem/resolve (completionProvider: {resolveProvider: true}).
+func (sc ServerCapabilities) SupportsCompletionResolve() bool {
+	opts, ok := sc.CompletionProvider.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	resolve, _ := opts["resolveProvider"].(bool)
+	return resolve
+}
+
+// SupportsPullDiagnostics reports whether the server answers
+// textDocument/diagnostic.
+func (sc ServerCapabilities) SupportsPullDiagnostics() bool {
+	return enabled(sc.DiagnosticProvider)
+}
+
+// SupportsWorkspaceDiagnostics reports whether the server answers
+// workspace/diagnostic (diagnosticProvider: {workspaceDiagnostics: true}).
+func (sc ServerCapabilities) SupportsWorkspaceDiagnostics() bool {
+	opts, ok := sc.DiagnosticProvider.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	workspace, _ := opts["workspaceDiagnostics"].(bool)
+	return workspace
+}
+
+// SupportsFormatting reports whether the server answers
+// textDocument/formatting.
+func (sc ServerCapabilities) SupportsFormatting() bool {
+	return enabled(sc.DocumentFormattingProvider)
+}
+
+// SupportsRangeFormatting reports whether the server answers
+// textDocument/rangeFormatting.
+func (sc ServerCapabilities) SupportsRangeFormatting() bool {
+	return enabled(sc.DocumentRangeFormattingProvider)
+}
+
+// enabled reports whether a provider capability, which is either a boolean
+// or an options object, is present and not false.
+func enabled(provider interface{}) bool {
+	switch p := provider.(type) {
+	case nil:
+		return false
+	case bool:
+		return p
+	default:
+		return true
+	}
+}
+
+// SupportsCodeActionResolve reports whether the server answers
+// codeAction/resolve (codeActionProvider: {resolveProvider: true}).
+func (sc ServerCapabilities) SupportsCodeActionResolve() bool {
+	opts, ok := sc.CodeActionProvider.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	resolve, _ := opts["resolveProvider"].(bool)
+	return resolve
+}
+
+// SupportsPrepareRename reports whether the server answers
+// textDocument/prepareRename (renameProvider: {prepareProvider: true}).
+func (sc ServerCapabilities) SupportsPrepareRename() bool {
+	opts, ok := sc.RenameProvider.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	prepare, _ := opts["prepareProvider"].(bool)
+	return prepare
 }
 
 type InitializeResult struct {
@@ -298,6 +966,24 @@ type DidOpenTextDocumentParams struct {
 	TextDocument TextDocumentItem `json:"textDocument"`
 }
 
+// VersionedTextDocumentIdentifier identifies a specific version of a text document.
+type VersionedTextDocumentIdentifier struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+}
+
+// TextDocumentContentChangeEvent describes a change to a text document.
+// Only full-text changes are sent, so Range is omitted.
+type TextDocumentContentChangeEvent struct {
+	Text string `json:"text"`
+}
+
+// DidChangeTextDocumentParams for textDocument/didChange.
+type DidChangeTextDocumentParams struct {
+	TextDocument   VersionedTextDocumentIdentifier  `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
 // DidCloseTextDocumentParams for textDocument/didClose.
 type DidCloseTextDocumentParams struct {
 	TextDocument TextDocumentIdentifier `json:"textDocument"`
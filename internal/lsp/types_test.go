@@ -0,0 +1,106 @@
+package lsp
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFileEdits(t *testing.T) {
+	tests := []struct {
+		name, edit string
+		want       map[string]string // URI -> new texts joined by |
+		err        string
+	}{
+		{
+			name: "changes",
+			edit: `{"changes":{"file:///a":[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":1}},"newText":"x"}]}}`,
+			want: map[string]string{"file:///a": "x"},
+		},
+		{
+			name: "documentChanges over changes",
+			edit: `{"changes":{"file:///a":[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":1}},"newText":"x"}]},
+				"documentChanges":[
+					{"textDocument":{"uri":"file:///a","version":3},"edits":[{"range":{"start":{"line":0,"character":0},"end":{"line":0,"character":1}},"newText":"x"}]},
+					{"textDocument":{"uri":"file:///b","version":null},"edits":[{"range":{"start":{"line":1,"character":0},"end":{"line":1,"character":1}},"newText":"y"}]}]}`,
+			want: map[string]string{"file:///a": "x", "file:///b": "y"},
+		},
+		{
+			name: "file operation",
+			edit: `{"documentChanges":[{"kind":"rename","oldUri":"file:///a","newUri":"file:///b"}]}`,
+			err:  `unsupported file operation "rename"`,
+		},
+	}
+	for _, tt := range tests {
+		var we WorkspaceEdit
+		if err := json.Unmarshal([]byte(tt.edit), &we); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		edits, err := we.FileEdits()
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		got := make(map[string]string)
+		for uri, es := range edits {
+			var texts []string
+			for _, e := range es {
+				texts = append(texts, e.NewText)
+			}
+			got[uri] = strings.Join(texts, "|")
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: edits = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for uri, w := range tt.want {
+			if got[uri] != w {
+				t.Errorf("%s: edits = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestParameterSpans(t *testing.T) {
+	tests := []struct {
+		label  string
+		params string // JSON parameter labels
+		want   [][2]int
+	}{
+		{"max(x, y int) int", `["x", "y int"]`, [][2]int{{4, 5}, {7, 12}}},
+		{"f(a, a)", `["a", "a"]`, [][2]int{{2, 3}, {5, 6}}},
+		{"f(a, b)", `["zzz", "b"]`, [][2]int{{-1, -1}, {5, 6}}},
+		{"f(a int, b int)", `[[2, 7], [9, 14]]`, [][2]int{{2, 7}, {9, 14}}},
+		// Offsets count UTF-16 units: 😀 is two of them and four bytes.
+		{"😀(a, b)", `[[3, 4], [6, 7]]`, [][2]int{{5, 6}, {8, 9}}},
+		{"f(a)", `[[2, 9]]`, [][2]int{{-1, -1}}},
+	}
+	for _, tt := range tests {
+		var labels []json.RawMessage
+		if err := json.Unmarshal([]byte(tt.params), &labels); err != nil {
+			t.Fatal(err)
+		}
+		si := SignatureInformation{Label: tt.label}
+		for _, l := range labels {
+			si.Parameters = append(si.Parameters, ParameterInformation{Label: l})
+		}
+		got := si.ParameterSpans()
+		if len(got) != len(tt.want) {
+			t.Errorf("%s %s: spans = %v, want %v", tt.label, tt.params, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s %s: spans = %v, want %v", tt.label, tt.params, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Package mcp implements a minimal Model Context Protocol server: JSON-RPC
+// 2.0 messages, one per line, over stdio, exposing a set of tools.
+package mcp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// supportedVersions lists the protocol revisions this server speaks, newest first.
+var supportedVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// Standard JSON-RPC 2.0 error codes.
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// Tool describes a tool offered to the client. InputSchema is a JSON Schema
+// object describing the arguments.
+type Tool struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	InputSchema interface{} `json:"inputSchema"`
+}
+
+// ToolHandler runs a tool with the raw JSON arguments and returns its text
+// output. A returned error is reported to the client as a failed tool call,
+// not as a protocol error.
+type ToolHandler func(args json.RawMessage) (string, error)
+
+// Server dispatches MCP requests to registered tools.
+type Server struct {
+	Name    string
+	Version string
+
+	tools    []Tool
+	handlers map[string]ToolHandler
+
+	writeMu sync.Mutex
+	w       io.Writer
+}
+
+// NewServer creates a server that reports the given name and version.
+func NewServer(name, version string) *Server {
+	return &Server{
+		Name:     name,
+		Version:  version,
+		handlers: make(map[string]ToolHandler),
+	}
+}
+
+// AddTool registers a tool. Tools are listed in registration order.
+func (s *Server) AddTool(tool Tool, handler ToolHandler) {
+	s.tools = append(s.tools, tool)
+	s.handlers[tool.Name] = handler
+}
+
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *responseError  `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Serve reads requests from r and writes responses to w until r is
+// exhausted. Tool calls run concurrently; responses may arrive out of order.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var msg message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			s.writeError(json.RawMessage("null"), codeParseError, err.Error())
+			continue
+		}
+		if msg.Method == "" {
+			// A response to a server request; this server sends none.
+			continue
+		}
+		if len(msg.ID) == 0 {
+			// Notifications (initialized, cancelled) need no action.
+			continue
+		}
+
+		if msg.Method == "tools/call" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.handle(msg)
+			}()
+			continue
+		}
+		s.handle(msg)
+	}
+	return scanner.Err()
+}
+
+func (s *Server) handle(msg message) {
+	switch msg.Method {
+	case "initialize":
+		var p struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(msg.Params, &p)
+
+		version := supportedVersions[0]
+		for _, v := range supportedVersions {
+			if v == p.ProtocolVersion {
+				version = v
+			}
+		}
+		s.writeResult(msg.ID, map[string]interface{}{
+			"protocolVersion": version,
+			"capabilities": map[string]interface{}{
+				"tools": map[string]interface{}{},
+			},
+			"serverInfo": map[string]string{
+				"name":    s.Name,
+				"version": s.Version,
+			},
+		})
+
+	case "ping":
+		s.writeResult(msg.ID, struct{}{})
+
+	case "tools/list":
+		tools := s.tools
+		if tools == nil {
+			tools = []Tool{}
+		}
+		s.writeResult(msg.ID, map[string]interface{}{"tools": tools})
+
+	case "tools/call":
+		var p struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			s.writeError(msg.ID, codeInvalidParams, err.Error())
+			return
+		}
+		handler, ok := s.handlers[p.Name]
+		if !ok {
+			s.writeError(msg.ID, codeInvalidParams, fmt.Sprintf("unknown tool %q", p.Name))
+			return
+		}
+		if len(p.Arguments) == 0 {
+			p.Arguments = json.RawMessage("{}")
+		}
+
+		text, err := handler(p.Arguments)
+		isError := err != nil
+		if isError {
+			text = err.Error()
+		}
+		s.writeResult(msg.ID, map[string]interface{}{
+			"content": []map[string]string{
+				{"type": "text", "text": text},
+			},
+			"isError": isError,
+		})
+
+	default:
+		s.writeError(msg.ID, codeMethodNotFound, fmt.Sprintf("method not found: %s", msg.Method))
+	}
+}
+
+func (s *Server) writeResult(id json.RawMessage, result interface{}) {
+	s.write(response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (s *Server) writeError(id json.RawMessage, code int, message string) {
+	s.write(response{JSONRPC: "2.0", ID: id, Error: &responseError{Code: code, Message: message}})
+}
+
+func (s *Server) write(resp response) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.w.Write(append(data, '\n'))
+}
@@ -0,0 +1,88 @@
+package mcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// serve runs a scripted session, one message per line, and returns the
+// responses keyed by id. Tool calls may be answered out of order.
+func serve(t *testing.T, s *Server, script ...string) map[string]json.RawMessage {
+	t.Helper()
+	var out bytes.Buffer
+	if err := s.Serve(strings.NewReader(strings.Join(script, "\n")+"\n"), &out); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	responses := make(map[string]json.RawMessage)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var resp struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("bad response %q: %v", line, err)
+		}
+		responses[string(resp.ID)] = json.RawMessage(line)
+	}
+	return responses
+}
+
+func newTestServer() *Server {
+	s := NewServer("test", "1.0")
+	s.AddTool(Tool{Name: "echo", InputSchema: map[string]string{"type": "object"}}, func(args json.RawMessage) (string, error) {
+		var p struct {
+			Text string `json:"text"`
+		}
+		json.Unmarshal(args, &p)
+		return p.Text, nil
+	})
+	s.AddTool(Tool{Name: "fail"}, func(args json.RawMessage) (string, error) {
+		return "", errors.New("it failed")
+	})
+	return s
+}
+
+func TestServe(t *testing.T) {
+	responses := serve(t, newTestServer(),
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05"}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"echo","arguments":{"text":"hi"}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"fail","arguments":{}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"nope"}}`,
+		`{"jsonrpc":"2.0","id":"six","method":"ping"}`,
+		`{"jsonrpc":"2.0","id":7,"method":"resources/list"}`,
+		`not json`,
+	)
+
+	want := map[string]string{
+		`1`:     `{"jsonrpc":"2.0","id":1,"result":{"capabilities":{"tools":{}},"protocolVersion":"2024-11-05","serverInfo":{"name":"test","version":"1.0"}}}`,
+		`2`:     `{"jsonrpc":"2.0","id":2,"result":{"tools":[{"name":"echo","description":"","inputSchema":{"type":"object"}},{"name":"fail","description":"","inputSchema":null}]}}`,
+		`3`:     `{"jsonrpc":"2.0","id":3,"result":{"content":[{"text":"hi","type":"text"}],"isError":false}}`,
+		`4`:     `{"jsonrpc":"2.0","id":4,"result":{"content":[{"text":"it failed","type":"text"}],"isError":true}}`,
+		`5`:     `{"jsonrpc":"2.0","id":5,"error":{"code":-32602,"message":"unknown tool \"nope\""}}`,
+		`"six"`: `{"jsonrpc":"2.0","id":"six","result":{}}`,
+		`7`:     `{"jsonrpc":"2.0","id":7,"error":{"code":-32601,"message":"method not found: resources/list"}}`,
+	}
+	for id, w := range want {
+		if got := string(responses[id]); got != w {
+			t.Errorf("response %s:\n got %s\nwant %s", id, got, w)
+		}
+	}
+	if got := string(responses["null"]); !strings.Contains(got, `"code":-32700`) {
+		t.Errorf("response to bad JSON: got %s, want a parse error", got)
+	}
+	if len(responses) != len(want)+1 {
+		t.Errorf("got %d responses, want %d (notifications are not answered)", len(responses), len(want)+1)
+	}
+}
+
+func TestServeUnknownVersion(t *testing.T) {
+	responses := serve(t, newTestServer(),
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"1999-01-01"}}`)
+	if got := string(responses["1"]); !strings.Contains(got, `"protocolVersion":"`+supportedVersions[0]+`"`) {
+		t.Errorf("initialize with an unknown version: got %s, want the newest supported", got)
+	}
+}
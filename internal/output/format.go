@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/c3d4r/agent-cli-tools/internal/diff"
 	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+	"github.com/c3d4r/agent-cli-tools/internal/textedit"
 )
 
 // Formatter controls how results are displayed.
@@ -77,6 +81,39 @@ func (f *Formatter) SymbolInformations(symbols []lsp.SymbolInformation) error {
 	return nil
 }
 
+// HierarchyNode is an entry of a call or type hierarchy tree. Its fields
+// follow the LSP hierarchy item, so JSON output nests items as the server
+// describes them.
+type HierarchyNode struct {
+	Name           string         `json:"name"`
+	Kind           lsp.SymbolKind `json:"kind"`
+	Detail         string         `json:"detail,omitempty"`
+	URI            string         `json:"uri"`
+	Range          lsp.Range      `json:"range"`
+	SelectionRange lsp.Range      `json:"selectionRange"`
+
+	// For call hierarchies: the call sites linking the node to its
+	// parent, in the document FromURI.
+	FromURI    string      `json:"fromUri,omitempty"`
+	FromRanges []lsp.Range `json:"fromRanges,omitempty"`
+
+	// Seen marks a node already expanded elsewhere in the tree (or one
+	// of its own ancestors); its children are not repeated.
+	Seen     bool            `json:"seen,omitempty"`
+	Children []HierarchyNode `json:"children,omitempty"`
+}
+
+// Hierarchy prints hierarchy trees, one indented line per node.
+func (f *Formatter) Hierarchy(roots []HierarchyNode) error {
+	if f.JSON {
+		return f.writeJSON(roots)
+	}
+	for _, n := range roots {
+		printHierarchyNode(f.Writer, n, 0)
+	}
+	return nil
+}
+
 // Diagnostics prints diagnostics.
 func (f *Formatter) Diagnostics(uri string, diags []lsp.Diagnostic) error {
 	if f.JSON {
@@ -87,17 +124,120 @@ func (f *Formatter) Diagnostics(uri string, diags []lsp.Diagnostic) error {
 	}
 	path := lsp.URIToPath(uri)
 	for _, d := range diags {
-		fmt.Fprintf(f.Writer, "%s:%d:%d: %s: %s\n",
+		fmt.Fprintf(f.Writer, "%s:%d:%d: %s: %s",
 			path,
 			d.Range.Start.Line+1,
 			d.Range.Start.Character+1,
 			d.Severity,
 			d.Message,
 		)
+		// Source and code, which --source and --code select on.
+		var tag []string
+		if d.Source != "" {
+			tag = append(tag, d.Source)
+		}
+		if d.Code != nil {
+			tag = append(tag, fmt.Sprint(d.Code))
+		}
+		if len(tag) > 0 {
+			fmt.Fprintf(f.Writer, " [%s]", strings.Join(tag, " "))
+		}
+		fmt.Fprintln(f.Writer)
 	}
 	return nil
 }
 
+// FileDiagnostics is the diagnostics of one file in a report.
+type FileDiagnostics struct {
+	URI         string           `json:"uri"`
+	Diagnostics []lsp.Diagnostic `json:"diagnostics"`
+}
+
+// DiagnosticSummary counts the diagnostics of a report by severity.
+// Diagnostics without a severity count as errors, as clients treat them.
+type DiagnosticSummary struct {
+	Errors   int `json:"errors"`
+	Warnings int `json:"warnings"`
+	Infos    int `json:"infos"`
+	Hints    int `json:"hints"`
+	Files    int `json:"files"`   // files with diagnostics
+	Checked  int `json:"checked"` // files in the report
+}
+
+// Summarize counts the diagnostics of files.
+func Summarize(files []FileDiagnostics) DiagnosticSummary {
+	sum := DiagnosticSummary{Checked: len(files)}
+	for _, f := range files {
+		if len(f.Diagnostics) > 0 {
+			sum.Files++
+		}
+		for _, d := range f.Diagnostics {
+			switch d.Severity {
+			case lsp.DiagnosticSeverityWarning:
+				sum.Warnings++
+			case lsp.DiagnosticSeverityInformation:
+				sum.Infos++
+			case lsp.DiagnosticSeverityHint:
+				sum.Hints++
+			default:
+				sum.Errors++
+			}
+		}
+	}
+	return sum
+}
+
+// String returns the summary as a line such as "3 errors, 2 warnings in
+// 2 files", or "no diagnostics in 4 files".
+func (s DiagnosticSummary) String() string {
+	var parts []string
+	for _, c := range []struct {
+		n    int
+		noun string
+	}{{s.Errors, "error"}, {s.Warnings, "warning"}, {s.Infos, "info"}, {s.Hints, "hint"}} {
+		if c.n > 0 {
+			parts = append(parts, plural(c.n, c.noun))
+		}
+	}
+	if len(parts) == 0 {
+		return "no diagnostics in " + plural(s.Checked, "file")
+	}
+	return strings.Join(parts, ", ") + " in " + plural(s.Files, "file")
+}
+
+func plural(n int, noun string) string {
+	if n == 1 || noun == "info" {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// DiagnosticReport prints the diagnostics of several files, grouped by
+// file, and a summary line. In JSON mode it prints one object holding the
+// files with diagnostics and the summary.
+func (f *Formatter) DiagnosticReport(files []FileDiagnostics) error {
+	sum := Summarize(files)
+	if f.JSON {
+		withDiags := []FileDiagnostics{}
+		for _, fd := range files {
+			if len(fd.Diagnostics) > 0 {
+				withDiags = append(withDiags, fd)
+			}
+		}
+		return f.writeJSON(map[string]interface{}{
+			"files":   withDiags,
+			"summary": sum,
+		})
+	}
+	for _, fd := range files {
+		if err := f.Diagnostics(fd.URI, fd.Diagnostics); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(f.Writer, sum)
+	return nil
+}
+
 // AllDiagnostics prints diagnostics for multiple URIs.
 func (f *Formatter) AllDiagnostics(allDiags map[string][]lsp.Diagnostic) error {
 	if f.JSON {
@@ -109,6 +249,195 @@ func (f *Formatter) AllDiagnostics(allDiags map[string][]lsp.Diagnostic) error {
 	return nil
 }
 
+// CompletionEntry is a completion item as printed: its documentation is
+// plain text and its insert text is always set.
+type CompletionEntry struct {
+	Label         string                 `json:"label"`
+	Kind          lsp.CompletionItemKind `json:"kind,omitempty"`
+	Detail        string                 `json:"detail,omitempty"`
+	InsertText    string                 `json:"insertText"`
+	Documentation string                 `json:"documentation,omitempty"`
+}
+
+// Completions prints completion items, one per line as "kind label detail",
+// followed by the insert text when it differs from the label and the first
+// paragraph of the documentation.
+func (f *Formatter) Completions(items []lsp.CompletionItem) error {
+	if f.JSON {
+		entries := make([]CompletionEntry, len(items))
+		for i, item := range items {
+			entries[i] = CompletionEntry{
+				Label:         item.Label,
+				Kind:          item.Kind,
+				Detail:        item.Detail,
+				InsertText:    item.InsertString(),
+				Documentation: item.DocumentationText(),
+			}
+		}
+		return f.writeJSON(entries)
+	}
+	for _, item := range items {
+		fmt.Fprintf(f.Writer, "%s %s", item.Kind, item.Label)
+		if item.Detail != "" {
+			fmt.Fprintf(f.Writer, " %s", item.Detail)
+		}
+		fmt.Fprintln(f.Writer)
+		if insert := item.InsertString(); insert != item.Label {
+			fmt.Fprintf(f.Writer, "    insert: %s\n", insert)
+		}
+		if doc := firstParagraph(item.DocumentationText()); doc != "" {
+			fmt.Fprintf(f.Writer, "    %s\n", strings.ReplaceAll(doc, "\n", "\n    "))
+		}
+	}
+	return nil
+}
+
+// SignatureEntry is a signature as printed, with plain-text documentation
+// and the index of its active parameter, or -1.
+type SignatureEntry struct {
+	Label           string           `json:"label"`
+	Documentation   string           `json:"documentation,omitempty"`
+	Parameters      []ParameterEntry `json:"parameters,omitempty"`
+	Active          bool             `json:"active"`
+	ActiveParameter int              `json:"activeParameter"`
+}
+
+// ParameterEntry is a parameter of a SignatureEntry.
+type ParameterEntry struct {
+	Label         string `json:"label"`
+	Documentation string `json:"documentation,omitempty"`
+}
+
+// Signatures prints every signature of a call. The active signature is
+// marked with "*" and its active parameter is shown in **bold**; each
+// signature is followed by its documentation and its parameters.
+func (f *Formatter) Signatures(help *lsp.SignatureHelp) error {
+	entries := signatureEntries(help)
+	if f.JSON {
+		return f.writeJSON(entries)
+	}
+	for i, e := range entries {
+		sig := help.Signatures[i]
+		label := e.Label
+		if e.ActiveParameter >= 0 {
+			span := sig.ParameterSpans()[e.ActiveParameter]
+			if start, end := span[0], span[1]; start >= 0 {
+				label = label[:start] + "**" + label[start:end] + "**" + label[end:]
+			}
+		}
+		marker := " "
+		if e.Active {
+			marker = "*"
+		}
+		fmt.Fprintf(f.Writer, "%s %s\n", marker, label)
+		if doc := firstParagraph(e.Documentation); doc != "" {
+			fmt.Fprintf(f.Writer, "    %s\n", strings.ReplaceAll(doc, "\n", "\n    "))
+		}
+		for _, p := range e.Parameters {
+			fmt.Fprintf(f.Writer, "    - %s", p.Label)
+			if doc := firstParagraph(p.Documentation); doc != "" {
+				fmt.Fprintf(f.Writer, ": %s", strings.ReplaceAll(doc, "\n", " "))
+			}
+			fmt.Fprintln(f.Writer)
+		}
+	}
+	return nil
+}
+
+func signatureEntries(help *lsp.SignatureHelp) []SignatureEntry {
+	active := 0
+	if help.ActiveSignature != nil {
+		active = *help.ActiveSignature
+	}
+	entries := make([]SignatureEntry, len(help.Signatures))
+	for i, sig := range help.Signatures {
+		e := SignatureEntry{
+			Label:           sig.Label,
+			Documentation:   sig.DocumentationText(),
+			Active:          i == active,
+			ActiveParameter: -1,
+		}
+		param := help.ActiveParameter
+		if sig.ActiveParameter != nil {
+			param = sig.ActiveParameter
+		}
+		if param != nil && *param >= 0 && *param < len(sig.Parameters) {
+			e.ActiveParameter = *param
+		}
+		spans := sig.ParameterSpans()
+		for j, p := range sig.Parameters {
+			label := ""
+			if start, end := spans[j][0], spans[j][1]; start >= 0 {
+				label = sig.Label[start:end]
+			}
+			e.Parameters = append(e.Parameters, ParameterEntry{Label: label, Documentation: p.DocumentationText()})
+		}
+		entries[i] = e
+	}
+	return entries
+}
+
+// CodeActions prints code actions numbered from 1, the numbers that
+// code-actions --apply takes.
+func (f *Formatter) CodeActions(actions []lsp.CodeAction) error {
+	if f.JSON {
+		entries := make([]CodeActionEntry, len(actions))
+		for i, a := range actions {
+			entries[i] = CodeActionEntry{Index: i + 1, CodeAction: a}
+		}
+		return f.writeJSON(entries)
+	}
+	for i, a := range actions {
+		fmt.Fprintf(f.Writer, "%d. ", i+1)
+		if a.Kind != "" {
+			fmt.Fprintf(f.Writer, "[%s] ", a.Kind)
+		}
+		fmt.Fprint(f.Writer, a.Title)
+		if a.IsPreferred {
+			fmt.Fprint(f.Writer, " (preferred)")
+		}
+		if a.Disabled != nil {
+			fmt.Fprintf(f.Writer, " (disabled: %s)", a.Disabled.Reason)
+		}
+		fmt.Fprintln(f.Writer)
+	}
+	return nil
+}
+
+// CodeActionEntry is a code action with its number in the listing.
+type CodeActionEntry struct {
+	Index int `json:"index"`
+	lsp.CodeAction
+}
+
+// FileChanges prints pending file changes as a unified diff, or as the
+// per-file text edits in JSON mode.
+func (f *Formatter) FileChanges(changes []textedit.FileChange) error {
+	if f.JSON {
+		return f.writeJSON(changes)
+	}
+	for _, c := range changes {
+		oldLabel, newLabel := diffLabels(c.Path)
+		fmt.Fprint(f.Writer, diff.Unified(oldLabel, newLabel, c.Old, c.New, diff.DefaultContext))
+	}
+	return nil
+}
+
+// AppliedChanges summarizes file changes that were written to disk.
+func (f *Formatter) AppliedChanges(changes []textedit.FileChange) error {
+	if f.JSON {
+		return f.writeJSON(changes)
+	}
+	for _, c := range changes {
+		noun := "edits"
+		if len(c.Edits) == 1 {
+			noun = "edit"
+		}
+		fmt.Fprintf(f.Writer, "%s: %d %s\n", c.Path, len(c.Edits), noun)
+	}
+	return nil
+}
+
 func (f *Formatter) writeJSON(v interface{}) error {
 	enc := json.NewEncoder(f.Writer)
 	enc.SetIndent("", "  ")
@@ -128,6 +457,59 @@ func printDocSymbol(w io.Writer, sym lsp.DocumentSymbol, depth int) {
 	}
 }
 
+func printHierarchyNode(w io.Writer, n HierarchyNode, depth int) {
+	fmt.Fprintf(w, "%s%s:%d:%d %s %s",
+		strings.Repeat("  ", depth),
+		lsp.URIToPath(n.URI),
+		n.SelectionRange.Start.Line+1,
+		n.SelectionRange.Start.Character+1,
+		n.Kind,
+		n.Name,
+	)
+	if len(n.FromRanges) > 0 {
+		sites := make([]string, len(n.FromRanges))
+		for i, r := range n.FromRanges {
+			sites[i] = fmt.Sprintf("%s:%d:%d", lsp.URIToPath(n.FromURI), r.Start.Line+1, r.Start.Character+1)
+		}
+		fmt.Fprintf(w, " (called at %s)", strings.Join(sites, ", "))
+	}
+	if n.Seen {
+		fmt.Fprint(w, " (see above)")
+	}
+	fmt.Fprintln(w)
+	for _, child := range n.Children {
+		printHierarchyNode(w, child, depth+1)
+	}
+}
+
+// diffLabels returns the old and new file names of path in a diff: a/ and
+// b/ before the path relative to the working directory when it is inside
+// it, so diffs can be applied with patch -p1 or git apply, or else the
+// absolute path, for patch -p0.
+func diffLabels(path string) (string, string) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path, path
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return abs, abs
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return abs, abs
+	}
+	rel = filepath.ToSlash(rel)
+	return "a/" + rel, "b/" + rel
+}
+
+// firstParagraph returns the documentation text up to the first blank
+// line, without code fences.
+func firstParagraph(doc string) string {
+	para, _, _ := strings.Cut(strings.TrimSpace(stripCodeFences(doc)), "\n\n")
+	return para
+}
+
 func stripCodeFences(s string) string {
 	lines := strings.Split(s, "\n")
 	var out []string
@@ -0,0 +1,149 @@
+// Package textedit applies LSP text edits to file contents.
+package textedit
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/c3d4r/agent-cli-tools/internal/atomicfile"
+	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+)
+
+// FileChange is the result of applying edits to one file.
+type FileChange struct {
+	Path  string         `json:"file"`
+	Edits []lsp.TextEdit `json:"edits"`
+	Old   string         `json:"-"`
+	New   string         `json:"-"`
+}
+
+// Apply applies edits to content. Edits are applied as if simultaneously,
+// against the original positions, and must not overlap. Positions count
+// UTF-16 code units, the LSP default encoding.
+func Apply(content string, edits []lsp.TextEdit) (string, error) {
+	type span struct {
+		start, end int
+		text       string
+	}
+
+	starts := lineStarts(content)
+	spans := make([]span, len(edits))
+	for i, e := range edits {
+		start, err := offset(content, starts, e.Range.Start)
+		if err != nil {
+			return "", err
+		}
+		end, err := offset(content, starts, e.Range.End)
+		if err != nil {
+			return "", err
+		}
+		if end < start {
+			return "", fmt.Errorf("edit range %d:%d-%d:%d is reversed",
+				e.Range.Start.Line+1, e.Range.Start.Character+1, e.Range.End.Line+1, e.Range.End.Character+1)
+		}
+		spans[i] = span{start, end, e.NewText}
+	}
+
+	// Stable, so inserts at the same position keep their given order.
+	sort.SliceStable(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+
+	var sb strings.Builder
+	pos := 0
+	for _, s := range spans {
+		if s.start < pos {
+			return "", fmt.Errorf("overlapping edits at byte offset %d", s.start)
+		}
+		sb.WriteString(content[pos:s.start])
+		sb.WriteString(s.text)
+		pos = s.end
+	}
+	sb.WriteString(content[pos:])
+	return sb.String(), nil
+}
+
+// Compute reads every file named in edits (keyed by URI) and applies its
+// edits in memory. Changes are returned sorted by path; files whose content
+// does not change are omitted.
+func Compute(edits map[string][]lsp.TextEdit) ([]FileChange, error) {
+	var changes []FileChange
+	for uri, fileEdits := range edits {
+		path := lsp.URIToPath(uri)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := Apply(string(data), fileEdits)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if updated == string(data) {
+			continue
+		}
+		changes = append(changes, FileChange{
+			Path:  path,
+			Edits: fileEdits,
+			Old:   string(data),
+			New:   updated,
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// Write writes the new content of every change, all files or none.
+func Write(changes []FileChange) error {
+	files := make([]atomicfile.File, len(changes))
+	for i, c := range changes {
+		files[i] = atomicfile.File{Path: c.Path, Data: []byte(c.New)}
+	}
+	return atomicfile.WriteFiles(files, 0644)
+}
+
+// lineStarts returns the byte offset at which each line begins.
+func lineStarts(content string) []int {
+	starts := []int{0}
+	for i := 0; i < len(content); i++ {
+		if content[i] == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+// offset converts an LSP position to a byte offset. A character past the
+// end of its line means the end of the line, and a line past the end of
+// the content means the end of the content, as the specification asks.
+func offset(content string, starts []int, p lsp.Position) (int, error) {
+	if p.Line < 0 || p.Character < 0 {
+		return 0, fmt.Errorf("invalid position %d:%d", p.Line, p.Character)
+	}
+	if p.Line >= len(starts) {
+		return len(content), nil
+	}
+
+	lineEnd := len(content)
+	if p.Line+1 < len(starts) {
+		lineEnd = starts[p.Line+1] - 1 // the '\n'
+	}
+	line := content[starts[p.Line]:lineEnd]
+	line = strings.TrimSuffix(line, "\r")
+
+	units := 0
+	for i, r := range line {
+		if units >= p.Character {
+			return starts[p.Line] + i, nil
+		}
+		if r >= 0x10000 {
+			units += 2
+		} else {
+			units++
+		}
+	}
+	return starts[p.Line] + len(line), nil
+}
@@ -0,0 +1,133 @@
+package textedit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/c3d4r/agent-cli-tools/internal/lsp"
+)
+
+func edit(startLine, startChar, endLine, endChar int, text string) lsp.TextEdit {
+	return lsp.TextEdit{
+		Range: lsp.Range{
+			Start: lsp.Position{Line: startLine, Character: startChar},
+			End:   lsp.Position{Line: endLine, Character: endChar},
+		},
+		NewText: text,
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		edits   []lsp.TextEdit
+		want    string
+	}{
+		{
+			name:    "replace",
+			content: "func foo() {}\nfoo()\n",
+			edits:   []lsp.TextEdit{edit(0, 5, 0, 8, "bar"), edit(1, 0, 1, 3, "bar")},
+			want:    "func bar() {}\nbar()\n",
+		},
+		{
+			name:    "edits given out of order",
+			content: "a b c\n",
+			edits:   []lsp.TextEdit{edit(0, 4, 0, 5, "C"), edit(0, 0, 0, 1, "A")},
+			want:    "A b C\n",
+		},
+		{
+			name:    "inserts at one position keep their order",
+			content: "x\n",
+			edits:   []lsp.TextEdit{edit(0, 0, 0, 0, "1"), edit(0, 0, 0, 0, "2")},
+			want:    "12x\n",
+		},
+		{
+			name:    "across lines",
+			content: "one\ntwo\nthree\n",
+			edits:   []lsp.TextEdit{edit(0, 2, 2, 2, "NE\nTH")},
+			want:    "onNE\nTHree\n",
+		},
+		{
+			name:    "utf-16 columns",
+			content: "s := \"😀é\" + x\n",
+			// 😀 is two UTF-16 units and four bytes; é one unit and two bytes.
+			edits: []lsp.TextEdit{edit(0, 13, 0, 14, "y")},
+			want:  "s := \"😀é\" + y\n",
+		},
+		{
+			name:    "end of a crlf line",
+			content: "ab\r\ncd\r\n",
+			edits:   []lsp.TextEdit{edit(0, 2, 0, 2, "!"), edit(1, 99, 1, 99, "?")},
+			want:    "ab!\r\ncd?\r\n",
+		},
+		{
+			name:    "line past the end",
+			content: "a\n",
+			edits:   []lsp.TextEdit{edit(5, 0, 5, 0, "b\n")},
+			want:    "a\nb\n",
+		},
+	}
+	for _, tt := range tests {
+		got, err := Apply(tt.content, tt.edits)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		edits []lsp.TextEdit
+		want  string
+	}{
+		{"overlapping", []lsp.TextEdit{edit(0, 0, 0, 3, "x"), edit(0, 2, 0, 4, "y")}, "overlapping"},
+		{"reversed", []lsp.TextEdit{edit(0, 3, 0, 1, "x")}, "reversed"},
+		{"negative", []lsp.TextEdit{edit(-1, 0, 0, 1, "x")}, "invalid position"},
+	}
+	for _, tt := range tests {
+		_, err := Apply("abcdef\n", tt.edits)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestComputeAndWrite(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")
+	for path, content := range map[string]string{a: "foo\n", b: "bar\n"} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes, err := Compute(map[string][]lsp.TextEdit{
+		"file://" + b: {edit(0, 0, 0, 3, "bar")}, // no change
+		"file://" + a: {edit(0, 0, 0, 3, "baz")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Path != a || changes[0].Old != "foo\n" || changes[0].New != "baz\n" {
+		t.Fatalf("changes = %+v, want only a.go", changes)
+	}
+
+	if err := Write(changes); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "baz\n" {
+		t.Errorf("a.go = %q, want baz", data)
+	}
+}